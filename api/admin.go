@@ -0,0 +1,585 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xetkloset/demo/bot"
+	"github.com/xetkloset/demo/flow"
+	"github.com/xetkloset/demo/ledger"
+	"github.com/xetkloset/demo/money"
+	"github.com/xetkloset/demo/repay"
+)
+
+// Admin API for loan officers, over the same stores as the chat handler.
+// Vercel routes /api/admin/* here (see vercel.json); every request needs
+// "Authorization: Bearer <token>" with a token listed in
+// WALLETBOT_ADMIN_TOKENS.
+//
+//	GET    /api/admin/loans?status=&region=&applicant=&page=&per_page=
+//	GET    /api/admin/loans/{id}
+//	GET    /api/admin/loans/{id}/events
+//	GET    /api/admin/sessions/{number}
+//	GET    /api/admin/members
+//	PUT    /api/admin/members/{number}   {"name", "role", "region"}
+//	DELETE /api/admin/members/{number}
+//	GET    /api/admin/wallets/{number}
+//	POST   /api/admin/wallets/{number}/adjustments   {"amount", "direction", "reason_code", "note"}
+//	GET    /api/admin/export
+//
+// Numbers take any form bot.WhatsAppID accepts.
+var admin = newAdminMux()
+
+func Admin(w http.ResponseWriter, r *http.Request) {
+	admin.ServeHTTP(w, r)
+}
+
+func newAdminMux() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/admin/loans", adminListLoans)
+	mux.HandleFunc("GET /api/admin/loans/{id}", adminLoan)
+	mux.HandleFunc("GET /api/admin/loans/{id}/events", adminLoanEvents)
+	mux.HandleFunc("GET /api/admin/sessions/{number}", adminSession)
+	mux.HandleFunc("GET /api/admin/members", adminListMembers)
+	mux.HandleFunc("PUT /api/admin/members/{number}", adminSaveMember)
+	mux.HandleFunc("DELETE /api/admin/members/{number}", adminDeleteMember)
+	mux.HandleFunc("GET /api/admin/wallets/{number}", adminWallet)
+	mux.HandleFunc("POST /api/admin/wallets/{number}/adjustments", adminAdjustWallet)
+	mux.HandleFunc("GET /api/admin/export", adminExport)
+	return adminTokensFromEnv().wrap(mux)
+}
+
+// adminTokens maps bearer tokens to the name of the officer holding them.
+type adminTokens map[string]string
+
+// adminTokensFromEnv reads WALLETBOT_ADMIN_TOKENS, a comma-separated list of
+// name=token pairs.
+func adminTokensFromEnv() adminTokens {
+	t := adminTokens{}
+	for _, pair := range strings.Split(os.Getenv("WALLETBOT_ADMIN_TOKENS"), ",") {
+		name, token, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" || token == "" {
+			if pair != "" {
+				log.Printf("WALLETBOT_ADMIN_TOKENS: ignoring malformed entry")
+			}
+			continue
+		}
+		t[token] = name
+	}
+	return t
+}
+
+// officer returns the name the request's bearer token belongs to.
+func (t adminTokens) officer(r *http.Request) (string, bool) {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", false
+	}
+	for token, name := range t {
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// wrap rejects requests without a known token; with no tokens configured
+// the API is closed.
+func (t adminTokens) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(t) == 0 {
+			writeJSONError(w, http.StatusServiceUnavailable, "admin API not configured")
+			return
+		}
+		name, ok := t.officer(r)
+		if !ok {
+			writeJSONError(w, http.StatusUnauthorized, "missing or unknown token")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), officerKey{}, name)))
+	})
+}
+
+type officerKey struct{}
+
+// officerOf is the name of the officer making an authenticated request.
+func officerOf(r *http.Request) string {
+	name, _ := r.Context().Value(officerKey{}).(string)
+	return name
+}
+
+// ---------- Loans ----------
+
+type loanJSON struct {
+	ID              string         `json:"id"`
+	Applicant       string         `json:"applicant"`
+	ApplicantID     string         `json:"applicant_id"`
+	Region          string         `json:"region"`
+	Status          bot.LoanStatus `json:"status"`
+	Requested       money.Money    `json:"requested"`
+	ApprovedLimit   money.Money    `json:"approved_limit"`
+	TermMonths      int            `json:"term_months"`
+	Borrowed        money.Money    `json:"borrowed"`
+	Owed            money.Money    `json:"owed"`
+	Arrears         money.Money    `json:"arrears"`
+	PolicyVersion   string         `json:"policy_version"`
+	Mufundisi       bool           `json:"mufundisi_approved"`
+	ElderApprovals  int            `json:"elder_approvals"`
+	Recommendations []string       `json:"recommendations"`
+	DeclineReason   string         `json:"decline_reason,omitempty"`
+	SubmittedBy     string         `json:"submitted_by"`
+}
+
+func newLoanJSON(l *bot.Loan, now time.Time) loanJSON {
+	return loanJSON{
+		ID:              l.ID,
+		Applicant:       l.ApplicantName,
+		ApplicantID:     l.ApplicantID,
+		Region:          l.Region,
+		Status:          l.State(),
+		Requested:       l.RequestedAmount,
+		ApprovedLimit:   l.ApprovedLimit,
+		TermMonths:      l.TermMonths,
+		Borrowed:        l.Borrowed,
+		Owed:            repay.Owed(l.Installments),
+		Arrears:         repay.Arrears(l.Installments, now),
+		PolicyVersion:   l.PolicyVersion,
+		Mufundisi:       l.MufundisiApproved,
+		ElderApprovals:  countTrue(l.ElderApprovals),
+		Recommendations: append([]string{}, l.Recommendations...),
+		DeclineReason:   l.DeclineReason,
+		SubmittedBy:     l.SubmittedBy,
+	}
+}
+
+// adminListLoans lists loans across regions, filtered by status, region and
+// applicant (a case-insensitive part of the name), a page at a time
+func adminListLoans(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, perPage, ok := pageParams(q.Get("page"), q.Get("per_page"))
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "page and per_page must be positive numbers, per_page at most 100")
+		return
+	}
+	all, err := loans.List()
+	if err != nil {
+		adminFail(w, err)
+		return
+	}
+	status, region, applicant := q.Get("status"), q.Get("region"), strings.ToLower(q.Get("applicant"))
+	now := time.Now()
+	matched := []loanJSON{}
+	for _, l := range all {
+		if (status == "" || string(l.State()) == status) &&
+			(region == "" || strings.EqualFold(l.Region, region)) &&
+			(applicant == "" || strings.Contains(strings.ToLower(l.ApplicantName), applicant)) {
+			matched = append(matched, newLoanJSON(l, now))
+		}
+	}
+	total := len(matched)
+	start := min((page-1)*perPage, total)
+	end := min(start+perPage, total)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"loans":    matched[start:end],
+		"page":     page,
+		"per_page": perPage,
+		"total":    total,
+	})
+}
+
+// pageParams reads 1-based page numbering, 20 to a page by default
+func pageParams(page, perPage string) (int, int, bool) {
+	p, pp := 1, 20
+	var err error
+	if page != "" {
+		if p, err = strconv.Atoi(page); err != nil || p < 1 {
+			return 0, 0, false
+		}
+	}
+	if perPage != "" {
+		if pp, err = strconv.Atoi(perPage); err != nil || pp < 1 || pp > 100 {
+			return 0, 0, false
+		}
+	}
+	return p, pp, true
+}
+
+type installmentJSON struct {
+	Due       time.Time   `json:"due"`
+	Principal money.Money `json:"principal"`
+	Interest  money.Money `json:"interest"`
+	Owed      money.Money `json:"owed"`
+}
+
+type underwritingStepJSON struct {
+	Rule  string      `json:"rule"`
+	Limit money.Money `json:"limit"`
+	Note  string      `json:"note"`
+}
+
+// voteJSON is one approver's or recommender's say on a loan. Loans voted on
+// before votes were kept by WhatsApp ID have the voter's name in From.
+type voteJSON struct {
+	From string `json:"from"`
+	Name string `json:"name,omitempty"`
+	Vote string `json:"vote"`
+}
+
+func newVotesJSON(l *bot.Loan) []voteJSON {
+	votes := []voteJSON{}
+	for from, vote := range l.ApprovalReasons {
+		votes = append(votes, voteJSON{From: from, Name: l.ApproverNames[from], Vote: vote})
+	}
+	sort.Slice(votes, func(i, j int) bool { return votes[i].From < votes[j].From })
+	return votes
+}
+
+// adminLoan returns one loan with its schedule, underwriting and history
+func adminLoan(w http.ResponseWriter, r *http.Request) {
+	l, ok := adminGetLoan(w, r)
+	if !ok {
+		return
+	}
+	history, err := events.ForLoan(l.ID)
+	if err != nil {
+		adminFail(w, err)
+		return
+	}
+	if history == nil {
+		history = []bot.LoanEvent{}
+	}
+	schedule := []installmentJSON{}
+	for _, in := range l.Installments {
+		schedule = append(schedule, installmentJSON{Due: in.Due, Principal: in.Principal, Interest: in.Interest, Owed: in.Owed()})
+	}
+	steps := []underwritingStepJSON{}
+	if l.Underwriting != nil {
+		for _, st := range l.Underwriting.Steps {
+			steps = append(steps, underwritingStepJSON{Rule: st.Rule, Limit: st.Limit, Note: st.Note})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"loan":         newLoanJSON(l, time.Now()),
+		"votes":        newVotesJSON(l),
+		"installments": schedule,
+		"underwriting": steps,
+		"events":       history,
+	})
+}
+
+// adminGetLoan looks up the loan named in the path, answering 404 itself
+func adminGetLoan(w http.ResponseWriter, r *http.Request) (*bot.Loan, bool) {
+	l, err := loans.Get(strings.ToUpper(r.PathValue("id")))
+	if err == bot.ErrNotFound {
+		writeJSONError(w, http.StatusNotFound, "loan not found")
+		return nil, false
+	} else if err != nil {
+		adminFail(w, err)
+		return nil, false
+	}
+	return l, true
+}
+
+// adminLoanEvents returns a loan's audit log, oldest first
+func adminLoanEvents(w http.ResponseWriter, r *http.Request) {
+	l, ok := adminGetLoan(w, r)
+	if !ok {
+		return
+	}
+	history, err := events.ForLoan(l.ID)
+	if err != nil {
+		adminFail(w, err)
+		return
+	}
+	if history == nil {
+		history = []bot.LoanEvent{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"loan_id": l.ID, "events": history})
+}
+
+// ---------- Sessions ----------
+
+// adminSession shows a member's profile, without its PIN hash, and the stage
+// of their conversation if one is in progress
+func adminSession(w http.ResponseWriter, r *http.Request) {
+	from, ok := adminNumber(w, r)
+	if !ok {
+		return
+	}
+	p, err := profiles.Get(from)
+	if err == bot.ErrNotFound {
+		writeJSONError(w, http.StatusNotFound, "no session for that number")
+		return
+	} else if err != nil {
+		adminFail(w, err)
+		return
+	}
+	var stage flow.StageID
+	if c, err := conversations.Get(from); err == nil {
+		stage = c.Stage
+	} else if err != bot.ErrNotFound {
+		adminFail(w, err)
+		return
+	}
+	now := time.Now()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"from":         from,
+		"name":         p.Name,
+		"handle":       p.Handle,
+		"stage":        stage,
+		"role":         p.Role,
+		"region":       p.Region,
+		"language":     p.Language,
+		"pin_enrolled": p.PIN.Enrolled(),
+		"pin_locked":   p.PIN.Locked(now),
+	})
+}
+
+// adminNumber reads the WhatsApp number in the path, answering 400 itself
+func adminNumber(w http.ResponseWriter, r *http.Request) (string, bool) {
+	from, ok := bot.WhatsAppID(r.PathValue("number"))
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "not a phone number")
+	}
+	return from, ok
+}
+
+// ---------- Members ----------
+
+type memberJSON struct {
+	From      string    `json:"from"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	Region    string    `json:"region"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+func newMemberJSON(m *bot.Member) memberJSON {
+	return memberJSON{From: m.From, Name: m.Name, Role: m.Role, Region: m.Region, UpdatedBy: m.UpdatedBy, UpdatedAt: m.UpdatedAt}
+}
+
+func adminListMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := stores.Members.List()
+	if err != nil {
+		adminFail(w, err)
+		return
+	}
+	out := []memberJSON{}
+	for _, m := range members {
+		out = append(out, newMemberJSON(m))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"members": out})
+}
+
+// adminSaveMember registers a member or changes their role and region
+func adminSaveMember(w http.ResponseWriter, r *http.Request) {
+	from, ok := adminNumber(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name   string `json:"name"`
+		Role   string `json:"role"`
+		Region string `json:"region"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	region, okRegion := bot.NormalizeRegion(req.Region)
+	name := strings.TrimSpace(req.Name)
+	if !okRegion || !bot.ValidRole(req.Role) || name == "" {
+		writeJSONError(w, http.StatusBadRequest, "need a name, a role ("+strings.Join(bot.Roles, ", ")+") and a region ("+strings.Join(bot.Regions, ", ")+")")
+		return
+	}
+	m := &bot.Member{From: from, Name: name, Role: req.Role, Region: region,
+		UpdatedBy: "admin-api:" + officerOf(r), UpdatedAt: time.Now()}
+	if err := stores.Members.Save(m); err != nil {
+		adminFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newMemberJSON(m))
+}
+
+func adminDeleteMember(w http.ResponseWriter, r *http.Request) {
+	from, ok := adminNumber(w, r)
+	if !ok {
+		return
+	}
+	if err := stores.Members.Delete(from); err == bot.ErrNotFound {
+		writeJSONError(w, http.StatusNotFound, "not in the registry")
+		return
+	} else if err != nil {
+		adminFail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ---------- Wallets ----------
+
+// adjustmentReasons are the reason codes a wallet adjustment must carry
+var adjustmentReasons = map[string]string{
+	"correction": "fixes a posting error",
+	"reversal":   "undoes a transaction made in error",
+	"refund":     "returns a charge to the member",
+	"goodwill":   "credit given at the operator's discretion",
+	"write_off":  "removes a balance that can't be recovered",
+}
+
+func adminWallet(w http.ResponseWriter, r *http.Request) {
+	from, ok := adminNumber(w, r)
+	if !ok {
+		return
+	}
+	wallet := ledger.Wallet(from)
+	history, err := books.History(wallet)
+	if err != nil {
+		adminFail(w, err)
+		return
+	}
+	if len(history) == 0 {
+		writeJSONError(w, http.StatusNotFound, "no wallet for that number")
+		return
+	}
+	bal, err := books.Balance(wallet)
+	if err != nil {
+		adminFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"wallet": from, "balance": bal, "entries": history})
+}
+
+// adminAdjustWallet credits or debits a wallet against the adjustments
+// account. The reason code and the officer are kept on the journal entry.
+func adminAdjustWallet(w http.ResponseWriter, r *http.Request) {
+	from, ok := adminNumber(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Amount     string `json:"amount"`
+		Direction  string `json:"direction"`
+		ReasonCode string `json:"reason_code"`
+		Note       string `json:"note"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if _, ok := adjustmentReasons[req.ReasonCode]; !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "unknown reason_code", "reason_codes": adjustmentReasons})
+		return
+	}
+	amt, err := money.Parse(req.Amount, money.USD)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "amount: "+err.Error())
+		return
+	}
+	wallet := ledger.Wallet(from)
+	if history, err := books.History(wallet); err != nil {
+		adminFail(w, err)
+		return
+	} else if len(history) == 0 {
+		writeJSONError(w, http.StatusNotFound, "no wallet for that number")
+		return
+	}
+	meta := map[string]string{"reason_code": req.ReasonCode, "note": req.Note, "officer": officerOf(r)}
+	var entry ledger.Entry
+	switch req.Direction {
+	case "credit":
+		entry = ledger.Transfer(ledger.KindAdjustment, ledger.Adjustments, wallet, amt, meta)
+	case "debit":
+		entry = ledger.Transfer(ledger.KindAdjustment, wallet, ledger.Adjustments, amt, meta)
+	default:
+		writeJSONError(w, http.StatusBadRequest, `direction must be "credit" or "debit"`)
+		return
+	}
+	posted, err := books.Post(entry)
+	if err == ledger.ErrInsufficientFunds {
+		writeJSONError(w, http.StatusConflict, "the wallet balance can't go below zero")
+		return
+	} else if err != nil {
+		adminFail(w, err)
+		return
+	}
+	log.Printf("admin: %s %s %s on %s (%s)", officerOf(r), req.Direction, amt, from, req.ReasonCode)
+	bal, err := books.Balance(wallet)
+	if err != nil {
+		adminFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"entry": posted, "balance": bal})
+}
+
+// ---------- Export ----------
+
+// adminExport dumps loans, the member registry and the ledger journal
+func adminExport(w http.ResponseWriter, r *http.Request) {
+	all, err := loans.List()
+	if err != nil {
+		adminFail(w, err)
+		return
+	}
+	members, err := stores.Members.List()
+	if err != nil {
+		adminFail(w, err)
+		return
+	}
+	journal, err := stores.Journal.Entries()
+	if err != nil {
+		adminFail(w, err)
+		return
+	}
+	now := time.Now()
+	out := struct {
+		ExportedAt time.Time      `json:"exported_at"`
+		Loans      []loanJSON     `json:"loans"`
+		Members    []memberJSON   `json:"members"`
+		Journal    []ledger.Entry `json:"journal"`
+	}{ExportedAt: now, Loans: []loanJSON{}, Members: []memberJSON{}, Journal: journal}
+	for _, l := range all {
+		out.Loans = append(out.Loans, newLoanJSON(l, now))
+	}
+	for _, m := range members {
+		out.Members = append(out.Members, newMemberJSON(m))
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="walletbot-export.json"`)
+	writeJSON(w, http.StatusOK, out)
+}
+
+// readJSON decodes a request body of at most 64 KiB, answering 400 itself
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "bad JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("admin: write response: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// adminFail answers a store failure
+func adminFail(w http.ResponseWriter, err error) {
+	log.Printf("admin: %v", err)
+	writeJSONError(w, http.StatusServiceUnavailable, "service unavailable")
+}
@@ -0,0 +1,16 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/xetkloset/demo/meta"
+)
+
+// metaWebhook serves the same conversation as Handler over the WhatsApp
+// Cloud API: point the app's webhook at /api/meta (see meta.FromEnv for the
+// settings it reads). Handled message IDs are kept in the shared stores.
+var metaWebhook = meta.FromEnv(stores.Replays).Handler(converse)
+
+func Meta(w http.ResponseWriter, r *http.Request) {
+	metaWebhook.ServeHTTP(w, r)
+}
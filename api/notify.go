@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Notify delivers the notifications that are due, retrying earlier failures,
+// and resets timed-out conversations. Sends are also started right after a
+// notification is queued, but a serverless instance may be frozen before they
+// finish, and a frozen instance runs no background work at all, so Vercel
+// Cron calls this every few minutes (see vercel.json) with
+// "Authorization: Bearer <CRON_SECRET>".
+func Notify(w http.ResponseWriter, r *http.Request) {
+	secret := os.Getenv("CRON_SECRET")
+	if secret == "" {
+		writeJSONError(w, http.StatusServiceUnavailable, "CRON_SECRET not configured")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+secret)) != 1 {
+		writeJSONError(w, http.StatusUnauthorized, "missing or unknown token")
+		return
+	}
+	sent, err := notifier.Deliver()
+	if err != nil {
+		adminFail(w, err)
+		return
+	}
+	swept, err := sweepConversations(time.Now())
+	if err != nil {
+		adminFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"sent": sent, "swept": swept})
+}
@@ -0,0 +1,15 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/xetkloset/demo/ussd"
+)
+
+// ussdGateway serves the same conversation as Handler to feature phones:
+// point the USSD service's callback at /api/ussd?key=<USSD_CALLBACK_SECRET>.
+var ussdGateway = ussd.FromEnv().Handler(converse)
+
+func USSD(w http.ResponseWriter, r *http.Request) {
+	ussdGateway.ServeHTTP(w, r)
+}
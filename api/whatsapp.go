@@ -1,875 +1,1462 @@
 package handler
 
 import (
-	"encoding/xml"
 	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/xetkloset/demo/auth"
+	"github.com/xetkloset/demo/bot"
+	"github.com/xetkloset/demo/flow"
+	"github.com/xetkloset/demo/i18n"
+	"github.com/xetkloset/demo/ledger"
+	"github.com/xetkloset/demo/meta"
+	"github.com/xetkloset/demo/money"
+	"github.com/xetkloset/demo/notify"
+	"github.com/xetkloset/demo/policy"
+	"github.com/xetkloset/demo/repay"
+	"github.com/xetkloset/demo/transport"
+	"github.com/xetkloset/demo/twilio"
+	"github.com/xetkloset/demo/underwrite"
+	"github.com/xetkloset/demo/ussd"
 )
 
-// TwiML response
-type MessageResponse struct {
-	XMLName xml.Name `xml:"Response"`
-	Message string   `xml:"Message"`
-}
+// Stores picked from the environment at startup (see bot.OpenStores)
+var stores = openStores()
+var profiles, conversations, loans = stores.Profiles, stores.Conversations, stores.Loans
 
-// Session store (shared)
-var sessions = make(map[string]*Session)
+// events is the append-only audit log of loan actions
+var events = stores.Events
 var mu sync.Mutex
 
-// Global loan store (shared across sessions for demo)
-var loans = make(map[string]*Loan)
+// sessionExpiry is how long a conversation may sit idle, and last, before
+// the member is asked for their PIN again (see openExpiry)
+var sessionExpiry = openExpiry()
+
+// openExpiry times conversations out after 15 minutes idle, 5 at the
+// confirmation stages where a stray reply would move money, and 12 hours
+// after they began. WALLETBOT_SESSION_IDLE and WALLETBOT_SESSION_LIFETIME
+// override the idle and lifetime limits ("30m", "0" for none).
+func openExpiry() bot.Expiry {
+	e := bot.Expiry{
+		Idle: 15 * time.Minute,
+		StageIdle: map[flow.StageID]time.Duration{
+			stConfirmSend: 5 * time.Minute,
+			stConfirmPIN:  5 * time.Minute,
+		},
+		Lifetime: 12 * time.Hour,
+	}
+	for name, d := range map[string]*time.Duration{
+		"WALLETBOT_SESSION_IDLE":     &e.Idle,
+		"WALLETBOT_SESSION_LIFETIME": &e.Lifetime,
+	} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed < 0 {
+				log.Fatalf("%s: invalid duration %q", name, v)
+			}
+			*d = parsed
+		}
+	}
+	return e
+}
+
+// sweepConversations resets every conversation that has timed out at now, and
+// drops the ones that only still say so to a member who hasn't come back. The
+// cron endpoint (see Notify) runs it, so a transfer left at confirm_send isn't
+// kept until the member comes back.
+func sweepConversations(now time.Time) (int, error) {
+	return conversations.Sweep(func(_ string, c *bot.Conversation) (*bot.Conversation, bool) {
+		switch {
+		case expired(c, now):
+			return &bot.Conversation{Stage: stAskPIN, LastSeen: c.LastSeen, TimedOut: true}, true
+		case c.Stage == stAskPIN && now.Sub(c.LastSeen) > staleConversation:
+			return nil, true
+		}
+		return c, false
+	})
+}
+
+// staleConversation is how long a timed-out conversation is kept to tell the
+// member why they are asked for their PIN
+const staleConversation = 7 * 24 * time.Hour
+
+// notifier queues messages to members outside the conversation, such as
+// loan decisions (see notify.SenderFromEnv for the provider)
+var notifier = notify.New(stores.Outbox, notify.SenderFromEnv())
+
+// books is the wallet ledger; balances and history come from its postings
+var books = ledger.New(stores.Journal, money.USD)
+
+// openingBalance is credited to every new wallet for the demo
+var openingBalance = money.FromMajor(500, money.USD)
+
+// loanMu serializes read-modify-write of loans across sessions
 var loanMu sync.Mutex
-var loanCounter int
-
-// Translations map: language -> key -> translated text
-var translations = map[string]map[string]string{
-	"en": { // English
-		"welcome":               "👋 Welcome! Please enter your 4-digit PIN to continue.",
-		"pin_accepted":          "✅ PIN accepted! Please enter your name to continue.",
-		"pin_invalid":           "❌ Invalid PIN. Please enter a 4-digit PIN.",
-		"good_day":              "Good day, %s 👋\n\nWhat would you like to do today?",
-		"menu_tip":              "\n\nTip: After entering Loan Menu you can switch roles and regions for demo.",
-		"menu_1_balance":        "1️⃣ Check Balance",
-		"menu_2_send":           "2️⃣ Send Money",
-		"menu_3_airtime":        "3️⃣ Buy Airtime",
-		"menu_4_bills":          "4️⃣ Pay Bills",
-		"menu_5_transactions":   "5️⃣ View Transactions",
-		"menu_6_support":        "6️⃣ Talk to Support",
-		"menu_7_loan":           "7️⃣ Microfin Loan 💸",
-		"menu_8_language":       "8️⃣ Change Language 🌍",
-		"your_balance":          "💰 Your current balance is $%.2f\n\nWould you like to do anything else?\n1️⃣ Main Menu\n0️⃣ Exit",
-		"send_to_who":           "Who would you like to send money to?",
-		"send_how_much":         "How much would you like to send to %s?",
-		"invalid_amount":        "❌ Invalid amount. Try again (e.g., 20 or $20).",
-		"confirm_send":          "Send $%.2f to %s? ✅ Yes / ❌ No",
-		"transaction_success":   "✅ Transaction successful!\nNew balance: $%.2f\n\nWould you like to do anything else?\n1️⃣ Main Menu\n0️⃣ Exit",
-		"insufficient_funds":    "⚠️ Insufficient funds.",
-		"transaction_cancelled": "❌ Transaction cancelled.\n\nWould you like to do anything else?\n1️⃣ Main Menu\n0️⃣ Exit",
-		"sent_to":               "Sent $%.2f to %s ✅",
-		"airtime_prompt":        "Enter amount and mobile number (e.g. $2 to 0772123456)",
-		"airtime_invalid":       "❌ Invalid format. Try again (e.g., $2 to 0772123456).",
-		"airtime_success":       "✅ Airtime purchase successful! New balance: $%.2f\n\nWould you like to do anything else?\n1️⃣ Main Menu\n0️⃣ Exit",
-		"bought_airtime":        "Bought $%.2f airtime 📱",
-		"not_enough_balance":    "⚠️ Not enough balance.",
-		"bills_demo":            "⚙️ Bill payment demo not active.\n\nWould you like to do anything else?\n1️⃣ Main Menu\n0️⃣ Exit",
-		"recent_transactions":   "🧾 Recent Transactions:\n%s\n\nWould you like to do anything else?\n1️⃣ Main Menu\n0️⃣ Exit",
-		"no_transactions":       "No transactions yet",
-		"support_menu":          "I can help you with:\n1️⃣ Lost Card\n2️⃣ Transaction Issue\n3️⃣ Talk to Agent",
-		"support_lost_card":     "🧾 Lost Card: Please call 0800 123 456.",
-		"support_issue_logged":  "⚙️ Transaction Issue logged.",
-		"support_agent":         "👩🏾‍💼 Connecting to an agent...",
-		"choose_valid_support":  "❓ Please choose 1, 2, or 3.",
-		"post_action_menu":      "Please choose:\n1️⃣ Main Menu\n0️⃣ Exit",
-		"goodbye":               "👋 Thank you for using WalletBot! Goodbye!",
-		"choose_valid_option":   "❓ Please choose a valid option (1–8).",
-		"loan_menu_title":       "🏦 Microfin Loan Menu — Role: %s | Region: %s\n\n",
-		"loan_menu_1":           "1️⃣ Request Loan",
-		"loan_menu_2":           "2️⃣ View Loan Status",
-		"loan_menu_3":           "3️⃣ Recommend Borrower",
-		"loan_menu_4":           "4️⃣ Switch Role",
-		"loan_menu_5":           "5️⃣ Borrow Funds",
-		"loan_menu_6":           "6️⃣ Approve Loans",
-		"loan_menu_0":           "0️⃣ Back to Main Menu",
-		"loan_menu_note":        "\n\n(Use numeric choices)",
-		"loan_request_name":     "Loan Request — Enter applicant *name*:",
-		"loan_request_id":       "Enter applicant ID:",
-		"loan_request_region":   "Select applicant region:\n1️⃣ Tabhera\n2️⃣ Nyika",
-		"loan_request_amount":   "Enter requested loan amount (e.g., 300):",
-		"loan_submitted":        "✅ Loan request submitted with ID: %s\nStatus: pending (awaiting Mufundisi approval)\n\nWould you like to do anything else?\n1️⃣ Main Menu\n0️⃣ Exit",
-		"choose_region":         "Please choose 1 for Tabhera or 2 for Nyika.",
-		"recommend_title":       "📋 Borrowers awaiting recommendation:\n\n",
-		"recommend_none":        "✅ No borrowers awaiting recommendation in your region.",
-		"recommend_footer":      "\nReply with a number (1–%d) or 0️⃣ to go back.",
-		"recommend_invalid":     "❌ Invalid choice. Please reply with a valid number.",
-		"recommend_not_found":   "Loan not found.",
-		"recommend_question":    "Would you like to recommend %s?\n1️⃣ Yes\n2️⃣ No",
-		"recommend_yes_no":      "Please reply with 1️⃣ Yes or 2️⃣ No.",
-		"recommend_success":     "✅ Recommendation recorded for %s.",
-		"recommend_already":     "✅ You already recommended this borrower.",
-		"recommend_reason":      "Please provide a reason for not recommending:",
-		"not_recommended":       "❌ Not recommended (%s).",
-		"approver_switch":       "To approve loans switch to role Mufundisi or Elder first. Use Switch Role (option 4).",
-		"role_switched":         "🔁 Role switched to %s. Region: %s\n\nGo to Main Menu -> 7 for Microfin Loan.",
-		"role_unknown":          "Unknown role. Valid: member, mufundisi, elder, recommender.",
-		"switch_role_menu":      "Select your role:\n1️⃣ Member / Requester\n2️⃣ Mufundisi (Approver)\n3️⃣ Elder (Approver)\n4️⃣ Back",
-		"language_menu":         "🌍 Choose your language / Sarudza mutauro / Khetha ulimi lwakho:\n\n1️⃣ English\n2️⃣ Shona\n3️⃣ Ndebele\n0️⃣ Back",
-		"language_changed":      "✅ Language changed to %s",
-	},
-	"sn": { // Shona
-		"welcome":               "👋 Mauya! Ndapota isa PIN yako ine manhamba mana.",
-		"pin_accepted":          "✅ PIN yakagamuchirwa! Ndapota isa zita rako.",
-		"pin_invalid":           "❌ PIN isiri yechokwadi. Ndapota isa PIN ine manhamba mana.",
-		"good_day":              "Mhoro, %s 👋\n\nUngada kuita chii nhasi?",
-		"menu_tip":              "\n\nChiziviso: Mushure mekupinda muMenu yeChikwereti unogona kushandura mabasa nematunhu.",
-		"menu_1_balance":        "1️⃣ Tarisa Mari Yangu",
-		"menu_2_send":           "2️⃣ Tumira Mari",
-		"menu_3_airtime":        "3️⃣ Tenga Airtime",
-		"menu_4_bills":          "4️⃣ Bhadhara Mabhiri",
-		"menu_5_transactions":   "5️⃣ Ona Zvakaitika",
-		"menu_6_support":        "6️⃣ Taura neRubatsiro",
-		"menu_7_loan":           "7️⃣ Chikwereti cheMicrofin 💸",
-		"menu_8_language":       "8️⃣ Shandura Mutauro 🌍",
-		"your_balance":          "💰 Mari yako yakasvika $%.2f\n\nUngade kuita chimwe chinhu here?\n1️⃣ Menu Huru\n0️⃣ Buda",
-		"send_to_who":           "Ungade kutumira mari kuna ani?",
-		"send_how_much":         "Ungade kutumira mari yakawanda sei kuna %s?",
-		"invalid_amount":        "❌ Mari isiri yechokwadi. Edza zvakare (somuenzaniso, 20 kana $20).",
-		"confirm_send":          "Tumira $%.2f kuna %s? ✅ Hongu / ❌ Kwete",
-		"transaction_success":   "✅ Kutumira kwakafambira mberi!\nMari yatsva: $%.2f\n\nUngade kuita chimwe chinhu here?\n1️⃣ Menu Huru\n0️⃣ Buda",
-		"insufficient_funds":    "⚠️ Mari haina kukwana.",
-		"transaction_cancelled": "❌ Kutumira kwakamiswa.\n\nUngade kuita chimwe chinhu here?\n1️⃣ Menu Huru\n0️⃣ Buda",
-		"sent_to":               "Kutumira $%.2f kuna %s ✅",
-		"airtime_prompt":        "Isa mari nenhamba (somuenzaniso $2 ku 0772123456)",
-		"airtime_invalid":       "❌ Chisiri chechokwadi. Edza zvakare (somuenzaniso, $2 ku 0772123456).",
-		"airtime_success":       "✅ Kutenga airtime kwakafambira mberi! Mari yatsva: $%.2f\n\nUngade kuita chimwe chinhu here?\n1️⃣ Menu Huru\n0️⃣ Buda",
-		"bought_airtime":        "Kutenga $%.2f airtime 📱",
-		"not_enough_balance":    "⚠️ Mari haina kukwana.",
-		"bills_demo":            "⚙️ Kubhadhara mabhiri hakusati kwatanga kushanda.\n\nUngade kuita chimwe chinhu here?\n1️⃣ Menu Huru\n0️⃣ Buda",
-		"recent_transactions":   "🧾 Zvakaita Zvekupedzisira:\n%s\n\nUngade kuita chimwe chinhu here?\n1️⃣ Menu Huru\n0️⃣ Buda",
-		"no_transactions":       "Hapana zvakaita parizvino",
-		"support_menu":          "Ndinogona kukubatsira ne:\n1️⃣ Kadhi Rakarasika\n2️⃣ Dambudziko Rekutumira\n3️⃣ Taura neMumiriri",
-		"support_lost_card":     "🧾 Kadhi Rakarasika: Ndapota fona 0800 123 456.",
-		"support_issue_logged":  "⚙️ Dambudziko ranyorwa.",
-		"support_agent":         "👩🏾‍💼 Tiri kukubatanidza nemumiriri...",
-		"choose_valid_support":  "❓ Ndapota sarudza 1, 2, kana 3.",
-		"post_action_menu":      "Ndapota sarudza:\n1️⃣ Menu Huru\n0️⃣ Buda",
-		"goodbye":               "👋 Tinotenda kushandisa WalletBot! Sara zvakanaka!",
-		"choose_valid_option":   "❓ Ndapota sarudza sarudzo chaiyo (1–8).",
-		"loan_menu_title":       "🏦 Menu yeChikwereti cheMicrofin — Basa: %s | Dunhu: %s\n\n",
-		"loan_menu_1":           "1️⃣ Kumbira Chikwereti",
-		"loan_menu_2":           "2️⃣ Ona Chikwereti Changu",
-		"loan_menu_3":           "3️⃣ Kurudzira Mukwereti",
-		"loan_menu_4":           "4️⃣ Shandura Basa",
-		"loan_menu_5":           "5️⃣ Tora Mari Yakabvumidzwa",
-		"loan_menu_6":           "6️⃣ Bvumidza Zvikwereti",
-		"loan_menu_0":           "0️⃣ Dzokera kuMenu Huru",
-		"loan_menu_note":        "\n\n(Shandisa nhamba)",
-		"loan_request_name":     "Chikwereti — Isa *zita* remunyoreri:",
-		"loan_request_id":       "Isa ID yemunyoreri:",
-		"loan_request_region":   "Sarudza dunhu remunyoreri:\n1️⃣ Tabhera\n2️⃣ Nyika",
-		"loan_request_amount":   "Isa mari yechikwereti (somuenzaniso, 300):",
-		"loan_submitted":        "✅ Chikwereti chaendeswa neID: %s\nChimiro: Chakamirira kubvumidzwa naMufundisi\n\nUngade kuita chimwe chinhu here?\n1️⃣ Menu Huru\n0️⃣ Buda",
-		"choose_region":         "Ndapota sarudza 1 yeTabhera kana 2 yeNyika.",
-		"recommend_title":       "📋 Vanhu vari kumirira kurudzirwa:\n\n",
-		"recommend_none":        "✅ Hapana munhu arikumirira kurudzirwa mudunhu rako.",
-		"recommend_footer":      "\nPindura nenhamba (1–%d) kana 0️⃣ kudzokera.",
-		"recommend_invalid":     "❌ Sarudzo isiri yechokwadi. Ndapota sarudza nhamba chaiyo.",
-		"recommend_not_found":   "Chikwereti hachina kuwanikwa.",
-		"recommend_question":    "Ungade kurudzira %s here?\n1️⃣ Hongu\n2️⃣ Kwete",
-		"recommend_yes_no":      "Ndapota pindura 1️⃣ Hongu kana 2️⃣ Kwete.",
-		"recommend_success":     "✅ Kurudziro kwakanyorwa kuna %s.",
-		"recommend_already":     "✅ Watozvikurudzira munhu uyu.",
-		"recommend_reason":      "Ndapota ipa chikonzero chekusarudzira:",
-		"not_recommended":       "❌ Haina kurudzirwa (%s).",
-		"approver_switch":       "Kuti ubvumidze zvikwereti shandura basa kuMufundisi kana Mukuru. Shandisa Shandura Basa (sarudzo 4).",
-		"role_switched":         "🔁 Basa rakashandurwa kuita %s. Dunhu: %s\n\nEnda kuMenu Huru -> 7 yeChikwereti.",
-		"role_unknown":          "Basa risingazivikanwe. Mabasa: member, mufundisi, elder, recommender.",
-		"switch_role_menu":      "Sarudza basa rako:\n1️⃣ Nhengo / Munyoreri\n2️⃣ Mufundisi (Mubvumidzi)\n3️⃣ Mukuru (Mubvumidzi)\n4️⃣ Dzoka",
-		"language_menu":         "🌍 Choose your language / Sarudza mutauro / Khetha ulimi lwakho:\n\n1️⃣ English\n2️⃣ Shona\n3️⃣ Ndebele\n0️⃣ Back / Dzoka / Buyela",
-		"language_changed":      "✅ Mutauro wakashandurwa kuita %s",
-	},
-	"nd": { // Ndebele
-		"welcome":               "👋 Siyekelele! Sicela ufake i-PIN yakho enezinombolo ezine.",
-		"pin_accepted":          "✅ I-PIN yamukelwe! Sicela ufake igama lakho.",
-		"pin_invalid":           "❌ I-PIN engalungile. Sicela ufake i-PIN enezinombolo ezine.",
-		"good_day":              "Livukile, %s 👋\n\nUfunani ukwenza namhlanje?",
-		"menu_tip":              "\n\nIcebo: Ngemva kokungena ku-Menu Yezemalimboleko ungashintsha imihlomba lezifunda.",
-		"menu_1_balance":        "1️⃣ Bona Imali Yami",
-		"menu_2_send":           "2️⃣ Thumela Imali",
-		"menu_3_airtime":        "3️⃣ Thenga I-airtime",
-		"menu_4_bills":          "4️⃣ Bhadala Izikweletu",
-		"menu_5_transactions":   "5️⃣ Bona Okwenzakeleyo",
-		"menu_6_support":        "6️⃣ Khuluma Ngosizo",
-		"menu_7_loan":           "7️⃣ Imalimboleko Ye-Microfin 💸",
-		"menu_8_language":       "8️⃣ Shintsha Ulimi 🌍",
-		"your_balance":          "💰 Imali yakho ifinyelela ku-$%.2f\n\nUfuna ukwenza okunye na?\n1️⃣ I-Menu Enkulu\n0️⃣ Phuma",
-		"send_to_who":           "Ufuna ukuthumela imali kubani?",
-		"send_how_much":         "Ufuna ukuthumela imali engakanani ku-%s?",
-		"invalid_amount":        "❌ Imali engalungile. Zama futhi (isibonelo, 20 kumbe $20).",
-		"confirm_send":          "Thumela $%.2f ku-%s? ✅ Yebo / ❌ Hatshi",
-		"transaction_success":   "✅ Ukuthumela kuphumelele!\nImali entsha: $%.2f\n\nUfuna ukwenza okunye na?\n1️⃣ I-Menu Enkulu\n0️⃣ Phuma",
-		"insufficient_funds":    "⚠️ Imali ayeneli.",
-		"transaction_cancelled": "❌ Ukuthumela kuvalwe.\n\nUfuna ukwenza okunye na?\n1️⃣ I-Menu Enkulu\n0️⃣ Phuma",
-		"sent_to":               "Ukuthumela $%.2f ku-%s ✅",
-		"airtime_prompt":        "Faka imali lenombolo (isibonelo $2 ku-0772123456)",
-		"airtime_invalid":       "❌ Akusilo esilungile. Zama futhi (isibonelo, $2 ku-0772123456).",
-		"airtime_success":       "✅ Ukuthenga i-airtime kuphumelele! Imali entsha: $%.2f\n\nUfuna ukwenza okunye na?\n1️⃣ I-Menu Enkulu\n0️⃣ Phuma",
-		"bought_airtime":        "Ukuthenga $%.2f airtime 📱",
-		"not_enough_balance":    "⚠️ Imali ayeneli.",
-		"bills_demo":            "⚙️ Ukubhadala izikweletu akusasebenzi okwamanje.\n\nUfuna ukwenza okunye na?\n1️⃣ I-Menu Enkulu\n0️⃣ Phuma",
-		"recent_transactions":   "🧾 Okwenzakeleyo Kamuva:\n%s\n\nUfuna ukwenza okunye na?\n1️⃣ I-Menu Enkulu\n0️⃣ Phuma",
-		"no_transactions":       "Akulalutho olwenzakeleyo okwamanje",
-		"support_menu":          "Ngingakusiza nge:\n1️⃣ Ikhadi Elilahlekileko\n2️⃣ Inkinga Yokuthumela\n3️⃣ Khuluma Lo-agent",
-		"support_lost_card":     "🧾 Ikhadi Elilahlekileko: Sicela ubize 0800 123 456.",
-		"support_issue_logged":  "⚙️ Inkinga ibhaliwe.",
-		"support_agent":         "👩🏾‍💼 Siyakuxhuma lo-agent...",
-		"choose_valid_support":  "❓ Sicela ukhethe 1, 2, kumbe 3.",
-		"post_action_menu":      "Sicela ukhethe:\n1️⃣ I-Menu Enkulu\n0️⃣ Phuma",
-		"goodbye":               "👋 Siyabonga ukusebenzisa i-WalletBot! Sala kuhle!",
-		"choose_valid_option":   "❓ Sicela ukhethe okufaneleyo (1–8).",
-		"loan_menu_title":       "🏦 I-Menu Yemalimboleko Ye-Microfin — Umhlomba: %s | Isifunda: %s\n\n",
-		"loan_menu_1":           "1️⃣ Cela Imalimboleko",
-		"loan_menu_2":           "2️⃣ Bona Imalimboleko Yami",
-		"loan_menu_3":           "3️⃣ Ncoma Umboleki",
-		"loan_menu_4":           "4️⃣ Shintsha Umhlomba",
-		"loan_menu_5":           "5️⃣ Thatha Imali Evunyiweyo",
-		"loan_menu_6":           "6️⃣ Vumela Amalimboleko",
-		"loan_menu_0":           "0️⃣ Buyela ku-Menu Enkulu",
-		"loan_menu_note":        "\n\n(Sebenzisa izinombolo)",
-		"loan_request_name":     "Imalimboleko — Faka *igama* lomceli:",
-		"loan_request_id":       "Faka i-ID yomceli:",
-		"loan_request_region":   "Khetha isifunda somceli:\n1️⃣ Tabhera\n2️⃣ Nyika",
-		"loan_request_amount":   "Faka imali yemalimboleko (isibonelo, 300):",
-		"loan_submitted":        "✅ Imalimboleko ithunyelwe nge-ID: %s\nIsimo: Ilindele ukuvunywa ngu-Mufundisi\n\nUfuna ukwenza okunye na?\n1️⃣ I-Menu Enkulu\n0️⃣ Phuma",
-		"choose_region":         "Sicela ukhethe 1 ye-Tabhera kumbe 2 ye-Nyika.",
-		"recommend_title":       "📋 Abantu abalindele ukuncomwa:\n\n",
-		"recommend_none":        "✅ Akukho muntu olindele ukuncomwa esifundeni sakho.",
-		"recommend_footer":      "\nPhendula ngenombolo (1–%d) kumbe 0️⃣ ukubuyela.",
-		"recommend_invalid":     "❌ Ukukhetha okungalungile. Sicela ukhethe inombolo efaneleyo.",
-		"recommend_not_found":   "Imalimboleko ayitholwa.",
-		"recommend_question":    "Ufuna ukuncoma %s na?\n1️⃣ Yebo\n2️⃣ Hatshi",
-		"recommend_yes_no":      "Sicela uphendule 1️⃣ Yebo kumbe 2️⃣ Hatshi.",
-		"recommend_success":     "✅ Ukuncoma kubhaliwe ku-%s.",
-		"recommend_already":     "✅ Usumthembisile umuntu lo.",
-		"recommend_reason":      "Sicela unikele isizatho sokungancomi:",
-		"not_recommended":       "❌ Akanconywanga (%s).",
-		"approver_switch":       "Ukuze uvumele amalimboleko shintsha umhlomba ku-Mufundisi kumbe ku-Elder. Sebenzisa Shintsha Umhlomba (ukukhetha 4).",
-		"role_switched":         "🔁 Umhlomba ushintshiwe waba ngu-%s. Isifunda: %s\n\nYiya ku-Menu Enkulu -> 7 Yemalimboleko.",
-		"role_unknown":          "Umhlomba ongaziwa. Imihlomba: member, mufundisi, elder, recommender.",
-		"switch_role_menu":      "Khetha umhlomba wakho:\n1️⃣ Ilungu / Umceli\n2️⃣ Mufundisi (Umvumeli)\n3️⃣ Elder (Umvumeli)\n4️⃣ Buyela",
-		"language_menu":         "🌍 Choose your language / Sarudza mutauro / Khetha ulimi lwakho:\n\n1️⃣ English\n2️⃣ Shona\n3️⃣ Ndebele\n0️⃣ Back / Dzoka / Buyela",
-		"language_changed":      "✅ Ulimi lushintshiwe lwaba ngu-%s",
-	},
-}
-
-// Session represents a user session (single shared session per WhatsApp number)
-type Session struct {
-	Name             string
-	Stage            string
-	PIN              string
-	Balance          float64
-	PendingName      string
-	PendingAmt       float64
-	Transactions     []string
-	Role             string // "member", "mufundisi", "elder", "recommender" (we treat recommender as member with flag)
-	Region           string // "Tabhera" or "Nyika"
-	TempLoanList     map[string]string `json:"-"` // Maps numbers to loan IDs for recommendation selection
-	Language         string            // "en" (English), "sn" (Shona), "nd" (Ndebele)
-}
-
-// Loan model
-type Loan struct {
-	ID               string
-	ApplicantName    string
-	ApplicantID      string
-	Region           string
-	RequestedAmount  float64
-	Status           string // pending, approved, declined
-	MufundisiApproved bool
-	ElderApprovals   map[string]bool // keyed by approver name
-	ApprovalReasons  map[string]string
-	Recommendations  []string // recommender names
-	ApprovedLimit    float64
-	TermMonths       int
-	DeclineReason    string
-	Borrowed         float64
+
+func openStores() *bot.Stores {
+	st, err := bot.OpenStores()
+	if err != nil {
+		log.Fatalf("open stores: %v", err)
+	}
+	return st
+}
+
+// loanTerms price the loans drawn from now on (see openTerms)
+var loanTerms = openTerms()
+
+// openTerms reads the interest method (flat or declining) and monthly rate in
+// basis points from WALLETBOT_INTEREST_METHOD and WALLETBOT_INTEREST_RATE_BP,
+// defaulting to flat interest of 2% a month
+func openTerms() repay.Terms {
+	t := repay.Terms{Method: repay.Flat, MonthlyRateBP: 200}
+	if m := os.Getenv("WALLETBOT_INTEREST_METHOD"); m != "" {
+		t.Method = repay.Method(m)
+	}
+	if bp := os.Getenv("WALLETBOT_INTEREST_RATE_BP"); bp != "" {
+		n, err := strconv.ParseInt(bp, 10, 64)
+		if err != nil {
+			log.Fatalf("WALLETBOT_INTEREST_RATE_BP: %v", err)
+		}
+		t.MonthlyRateBP = n
+	}
+	if !t.Valid() {
+		log.Fatalf("interest terms %+v: %v", t, repay.ErrTerms)
+	}
+	return t
+}
+
+// access answers, from the member registry, who may hold which role and act
+// on which loan
+var access = openAccess()
+
+func openAccess() *bot.Access {
+	a, err := bot.AccessFromEnv(stores.Members)
+	if err != nil {
+		log.Fatalf("access: %v", err)
+	}
+	return a
+}
+
+// limits is the loan limit policy, re-read when its file changes (see
+// policy.FromEnv)
+var limits = openLimits()
+
+func openLimits() *policy.Source {
+	src, err := policy.FromEnv()
+	if err != nil {
+		log.Fatalf("loan policy: %v", err)
+	}
+	return src
+}
+
+// catalogs are the texts the bot speaks, by language (see i18n.FromEnv)
+var catalogs = openCatalogs()
+
+// openCatalogs loads the catalogs and hands the channels their labels for
+// every language
+func openCatalogs() *i18n.Catalogs {
+	c, err := i18n.FromEnv()
+	if err != nil {
+		log.Fatalf("catalogs: %v", err)
+	}
+	for _, lang := range c.Languages() {
+		money.Locales[lang.Code] = money.Locale{Group: lang.Group, Decimal: lang.Decimal}
+		meta.ListButtons[lang.Code] = c.Text(lang.Code, "list_button")
+		ussd.PageLabels[lang.Code] = [2]string{c.Text(lang.Code, "page_more"), c.Text(lang.Code, "back")}
+	}
+	return c
+}
+
+// webhook only lets requests signed by Twilio through to the bot, so forged
+// and replayed messages never touch session state. Handled MessageSids are
+// kept in the shared stores, so a replay to another instance is refused too.
+var webhook = openWebhook()
+
+func openWebhook() http.Handler {
+	v, err := twilio.FromEnv(stores.Replays)
+	if err != nil {
+		log.Fatalf("webhook: %v", err)
+	}
+	return v.Wrap(twilio.TwiML(converse))
 }
 
 func Handler(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
+	webhook.ServeHTTP(w, r)
+}
+
+// converse runs one message through the conversation, whichever channel it
+// came in on. The profile and conversation are saved before the reply is
+// returned, so a reply is never sent for a change that wasn't stored.
+func converse(m transport.Message) (transport.Reply, error) {
+	from := m.From
+	body := strings.TrimSpace(strings.ToLower(m.Body))
+
+	s, err := loadSession(from)
+	if err != nil {
+		return transport.Reply{}, fmt.Errorf("load session %s: %w", from, err)
 	}
 
-	from := r.FormValue("From")
-	body := strings.TrimSpace(strings.ToLower(r.FormValue("Body")))
+	migrateStage(s)
+	now := time.Now()
+	if expired(&s.Conversation, now) {
+		expire(s)
+	}
+	if !conversation.Has(s.Stage) || m.Start {
+		// a stage no longer in the flow, or a new USSD session: start over
+		// from the PIN
+		signOut(s)
+	}
+	if s.StartedAt.IsZero() {
+		s.StartedAt = now
+	}
+	s.LastSeen = now
+	next, response, err := conversation.Step(&turn{from: from, channel: m.Channel, s: s}, s.Stage, body)
+	if err != nil {
+		return transport.Reply{}, fmt.Errorf("store %s: %w", from, err)
+	}
+	s.Stage = next
+	if s.TimedOut {
+		response = getText(s.Language, "session_expired") + "\n\n" + response
+		s.TimedOut = false
+	}
+	if err := saveSession(from, s); err != nil {
+		return transport.Reply{}, fmt.Errorf("save session %s: %w", from, err)
+	}
+	return transport.Reply{Text: response, Language: s.Language, Done: next == stAskPIN}, nil
+}
 
+// loadSession reads the member's profile and conversation. A first message
+// creates the profile and opens the wallet; a member without a conversation
+// in progress starts a new one at the PIN.
+func loadSession(from string) (*bot.Session, error) {
 	mu.Lock()
-	s, ok := sessions[from]
-	if !ok {
-		// default session
-		s = &Session{
-			Stage:        "ask_pin",
-			Balance:      500,
-			Transactions: []string{},
-			Role:         "member",
-			Region:       "Tabhera",
-			Language:     "en",
+	defer mu.Unlock()
+	p, err := profiles.Get(from)
+	if err == bot.ErrNotFound {
+		p = &bot.Profile{Role: "member", Region: "Tabhera", Language: "en"}
+		if err := openWallet(from); err != nil {
+			return nil, err
 		}
-		sessions[from] = s
-	}
-	mu.Unlock()
-
-	response := ""
-
-	// quick role switch shortcut: "role <name>" still supported, but main UI uses switch role menu
-	if strings.HasPrefix(body, "role ") {
-		role := strings.TrimSpace(strings.TrimPrefix(body, "role "))
-		switch role {
-		case "member", "mufundisi", "elder", "recommender":
-			s.Role = role
-			response = getTextf(s.Language, "role_switched", strings.Title(role), s.Region)
-			respondXML(w, response)
-			return
-		default:
-			response = getText(s.Language, "role_unknown")
-			respondXML(w, response)
-			return
+		if err := profiles.Save(from, p); err != nil {
+			return nil, err
 		}
+	} else if err != nil {
+		return nil, err
+	}
+	c, err := conversations.Get(from)
+	if err == bot.ErrNotFound {
+		c = &bot.Conversation{Stage: stAskPIN}
+	} else if err != nil {
+		return nil, err
 	}
+	return &bot.Session{Profile: *p, Conversation: *c}, nil
+}
 
-	switch s.Stage {
+// saveSession stores the profile and the conversation. A conversation back
+// at the PIN has ended, so it is dropped; the profile and wallet stay.
+func saveSession(from string, s *bot.Session) error {
+	if err := profiles.Save(from, &s.Profile); err != nil {
+		return err
+	}
+	if s.Stage == stAskPIN && !s.TimedOut {
+		return conversations.Delete(from)
+	}
+	return conversations.Save(from, &s.Conversation)
+}
 
-	case "ask_pin":
-		response = getText(s.Language, "welcome")
-		s.Stage = "verify_pin"
+// ---------- CONVERSATION ----------
+
+// Conversation stages. Stages that act on one loan keep its ID in
+// Session.PendingLoan.
+const (
+	stAskPIN            flow.StageID = "ask_pin"
+	stEnrollPIN         flow.StageID = "enroll_pin"
+	stEnrollPINConfirm  flow.StageID = "enroll_pin_confirm"
+	stVerifyPIN         flow.StageID = "verify_pin"
+	stConfirmPIN        flow.StageID = "confirm_pin"
+	stAskName           flow.StageID = "ask_name"
+	stAskHandle         flow.StageID = "ask_handle"
+	stMainMenu          flow.StageID = "main_menu"
+	stSendTo            flow.StageID = "send_to"
+	stSendAmount        flow.StageID = "send_amount"
+	stConfirmSend       flow.StageID = "confirm_send"
+	stAirtime           flow.StageID = "airtime"
+	stSupport           flow.StageID = "support"
+	stPostAction        flow.StageID = "post_action"
+	stLanguageMenu      flow.StageID = "language_menu"
+	stLanguageConfirm   flow.StageID = "language_confirm"
+	stLoanMenu          flow.StageID = "loan_menu"
+	stLoanRequestName   flow.StageID = "loan_request_name"
+	stLoanRequestID     flow.StageID = "loan_request_id"
+	stLoanRequestRegion flow.StageID = "loan_request_region_choice"
+	stLoanRequestAmount flow.StageID = "loan_request_amount"
+	stRecommendList     flow.StageID = "recommend_list"
+	stRecommendAction   flow.StageID = "recommend_action"
+	stRecommendReason   flow.StageID = "recommend_reason"
+	stApproverList      flow.StageID = "approver_list"
+	stApproverAction    flow.StageID = "approver_action"
+	stSwitchRoleMenu    flow.StageID = "switch_role_menu"
+	stSwitchRegionMenu  flow.StageID = "switch_region_menu"
+	stManageMembers     flow.StageID = "manage_members"
+	stRepayList         flow.StageID = "repay_list"
+	stRepayAmount       flow.StageID = "repay_amount"
+	stLoanStatus        flow.StageID = "loan_status"
+	stBorrowList        flow.StageID = "borrow_list"
+	stBorrowAmount      flow.StageID = "borrow_amount"
+)
 
-	case "verify_pin":
-		if len(body) == 4 && isNumeric(body) {
-			s.PIN = body
-			s.Stage = "ask_name"
-			response = getText(s.Language, "pin_accepted")
-		} else {
-			response = getText(s.Language, "pin_invalid")
+// turn is what a stage works on: one incoming message and its sender's session
+type turn struct {
+	from    string
+	channel string // the transport channel the message came in on
+	s       *bot.Session
+}
+
+// conversation is the whole chat flow. A transition to a stage that isn't
+// registered stops the function at startup instead of stranding a user.
+var conversation = newConversation()
+
+func newConversation() *flow.Machine[*turn] {
+	m := flow.New[*turn](stAskPIN)
+	addPINStages(m)
+	addProfileStages(m)
+	addWalletStages(m)
+	addLoanStages(m)
+	if err := m.Build(); err != nil {
+		log.Fatalf("conversation: %v", err)
+	}
+	return m
+}
+
+// migrateStage splits stages stored by earlier versions as
+// "<stage>:<loan id>" into the stage and PendingLoan
+func migrateStage(s *bot.Session) {
+	if stage, loanID, ok := strings.Cut(string(s.Stage), ":"); ok {
+		s.Stage = flow.StageID(stage)
+		s.PendingLoan = loanID
+	}
+}
+
+// prompt is a stage prompt made of a single translation
+func prompt(key string) func(*turn) string {
+	return func(t *turn) string { return getText(t.s.Language, key) }
+}
+
+// reject turns down a reply with a translated message
+func reject(t *turn, key string) error {
+	return flow.Reject(getText(t.s.Language, key))
+}
+
+// amountIn parses a money reply, turning a bad one down with the translation
+// at key
+func amountIn(key string) func(*turn, string) (money.Money, error) {
+	return func(t *turn, input string) (money.Money, error) {
+		amt, err := parseAmount(input)
+		if err != nil {
+			return amt, reject(t, key)
 		}
+		return amt, nil
+	}
+}
 
-	case "ask_name":
-		s.Name = strings.Title(body)
-		s.Stage = "main_menu"
-		response = mainMenuText(s)
-
-	case "main_menu":
-		switch body {
-		case "1":
-			response = getTextf(s.Language, "your_balance", s.Balance)
-			s.Stage = "post_action"
-		case "2":
-			s.Stage = "send_to"
-			response = getText(s.Language, "send_to_who")
-		case "3":
-			s.Stage = "airtime"
-			response = getText(s.Language, "airtime_prompt")
-		case "4":
-			response = getText(s.Language, "bills_demo")
-			s.Stage = "post_action"
-		case "5":
-			txs := getText(s.Language, "no_transactions")
-			if len(s.Transactions) > 0 {
-				txs = strings.Join(s.Transactions, "\n")
+func addPINStages(m *flow.Machine[*turn]) {
+	flow.Add(m, stAskPIN, flow.Stage[*turn, string]{
+		Parse: flow.Parse[*turn],
+		Next: func(t *turn, input string) (flow.Transition, error) {
+			if t.s.PIN.Enrolled() {
+				return flow.Go(stVerifyPIN), nil
 			}
-			response = getTextf(s.Language, "recent_transactions", txs)
-			s.Stage = "post_action"
-		case "6":
-			s.Stage = "support"
-			response = getText(s.Language, "support_menu")
-		case "7":
-			s.Stage = "loan_menu"
-			response = loanMenuText(s)
-		case "8":
-			s.Stage = "language_menu"
-			response = getText(s.Language, "language_menu")
-		default:
-			response = getText(s.Language, "choose_valid_option")
-		}
+			if !t.s.LanguageSet {
+				// a first contact: guess the language from the greeting
+				// and number, and have the member confirm it
+				if language, ok := catalogs.Guess(input, strings.TrimPrefix(t.from, "whatsapp:")); ok {
+					t.s.Language = language
+				}
+				return flow.Go(stLanguageConfirm), nil
+			}
+			return flow.Go(stEnrollPIN), nil
+		},
+		To: []flow.StageID{stVerifyPIN, stLanguageConfirm, stEnrollPIN},
+	})
+
+	flow.Add(m, stEnrollPIN, flow.Stage[*turn, auth.PIN]{
+		Enter: prompt("welcome"),
+		Parse: func(t *turn, input string) (auth.PIN, error) {
+			pin, err := auth.Enroll(input)
+			if err == auth.ErrFormat {
+				return pin, reject(t, "pin_invalid")
+			}
+			return pin, err
+		},
+		Next: func(t *turn, pin auth.PIN) (flow.Transition, error) {
+			t.s.PendingPIN = pin
+			return flow.Go(stEnrollPINConfirm), nil
+		},
+		To: []flow.StageID{stEnrollPINConfirm},
+	})
+
+	flow.Add(m, stEnrollPINConfirm, flow.Stage[*turn, string]{
+		Enter: prompt("pin_confirm_new"),
+		Parse: flow.Parse[*turn],
+		Next: func(t *turn, input string) (flow.Transition, error) {
+			candidate := t.s.PendingPIN
+			t.s.PendingPIN = auth.PIN{}
+			if candidate.Verify(input, time.Now()) != nil {
+				return flow.Say(stEnrollPIN, getText(t.s.Language, "pin_mismatch")), nil
+			}
+			t.s.PIN = candidate
+			return flow.Go(stAskName), nil
+		},
+		To: []flow.StageID{stEnrollPIN, stAskName},
+	})
+
+	flow.Add(m, stVerifyPIN, flow.Stage[*turn, string]{
+		Enter:    prompt("welcome_back"),
+		Parse:    flow.Parse[*turn],
+		Validate: []func(*turn, string) error{pinMatches},
+		Next: func(t *turn, _ string) (flow.Transition, error) {
+			if t.s.Name == "" {
+				return flow.Go(stAskName), nil
+			}
+			return flow.Go(stMainMenu), nil
+		},
+		To: []flow.StageID{stAskName, stMainMenu},
+	})
+
+	// sensitive actions wait here for the PIN to be entered again
+	flow.Add(m, stConfirmPIN, flow.Stage[*turn, string]{
+		Enter: prompt("pin_reconfirm"),
+		Parse: flow.Parse[*turn],
+		Next:  confirmPIN,
+		To:    []flow.StageID{stPostAction, stLoanMenu, stAskPIN},
+	})
+}
 
-	case "send_to":
-		s.PendingName = strings.Title(body)
-		s.Stage = "send_amount"
-		response = getTextf(s.Language, "send_how_much", s.PendingName)
+// pinMatches validates a reply as the session's PIN
+func pinMatches(t *turn, input string) error {
+	if ok, msg := checkPIN(t.s, input); !ok {
+		return flow.Reject(msg)
+	}
+	return nil
+}
 
-	case "send_amount":
-		amt, err := parseAmount(body)
-		if err != nil {
-			response = getText(s.Language, "invalid_amount")
-			break
+// confirmPIN runs the pending action once the PIN has been entered again
+func confirmPIN(t *turn, input string) (flow.Transition, error) {
+	s := t.s
+	if input == "0" {
+		clearPending(s)
+		return flow.Say(stPostAction, getText(s.Language, "transaction_cancelled")), nil
+	}
+	if ok, msg := checkPIN(s, input); !ok {
+		if s.PIN.Locked(time.Now()) {
+			// a lockout mid-transaction cancels it and signs the user out
+			signOut(s)
+			return flow.Say(stAskPIN, msg), nil
 		}
-		s.PendingAmt = amt
-		s.Stage = "confirm_send"
-		response = getTextf(s.Language, "confirm_send", s.PendingAmt, s.PendingName)
-
-	case "confirm_send":
-		if strings.Contains(body, "yes") || body == "✅" {
-			if s.Balance >= s.PendingAmt {
-				s.Balance -= s.PendingAmt
-				tx := getTextf(s.Language, "sent_to", s.PendingAmt, s.PendingName)
-				s.Transactions = append([]string{tx}, s.Transactions...)
-				response = getTextf(s.Language, "transaction_success", s.Balance)
-			} else {
-				response = getText(s.Language, "insufficient_funds")
+		return flow.Stay(msg), nil
+	}
+	defer clearPending(s)
+	switch s.PendingAction {
+	case "send":
+		msg, err := sendMoney(s, t.from)
+		return flow.Say(stPostAction, msg), err
+	case "airtime":
+		msg, err := buyAirtime(s, t.from)
+		return flow.Say(stPostAction, msg), err
+	case "borrow":
+		msg, err := drawLoan(t)
+		return flow.Say(stLoanMenu, msg), err
+	case "repay":
+		msg, err := repayLoan(t)
+		return flow.Say(stLoanMenu, msg), err
+	}
+	return flow.Go(stPostAction), nil
+}
+
+func addProfileStages(m *flow.Machine[*turn]) {
+	flow.Add(m, stAskName, flow.Stage[*turn, string]{
+		Enter: prompt("pin_accepted"),
+		Parse: flow.Parse[*turn],
+		Next: func(t *turn, name string) (flow.Transition, error) {
+			t.s.Name = strings.Title(name)
+			return flow.Go(stAskHandle), nil
+		},
+		To: []flow.StageID{stAskHandle},
+	})
+
+	flow.Add(m, stAskHandle, flow.Stage[*turn, string]{
+		Enter: prompt("ask_handle"),
+		// "0" skips choosing a handle and parses as ""
+		Parse: func(t *turn, input string) (string, error) {
+			if input == "0" {
+				return "", nil
 			}
-			s.Stage = "post_action"
+			handle, ok := bot.NormalizeHandle(input)
+			if !ok {
+				return "", reject(t, "handle_invalid")
+			}
+			return handle, nil
+		},
+		Next: claimHandle,
+		To:   []flow.StageID{stMainMenu},
+	})
+
+	flow.Add(m, stLanguageMenu, flow.Stage[*turn, string]{
+		Enter: languageMenuText,
+		Parse: flow.Parse[*turn],
+		Next: func(t *turn, choice string) (flow.Transition, error) {
+			if choice == "0" {
+				return flow.Go(stMainMenu), nil
+			}
+			lang, ok := pickLanguage(catalogs.Languages(), choice)
+			if !ok {
+				return flow.Stay(""), nil
+			}
+			t.s.Language, t.s.LanguageSet = lang.Code, true
+			return flow.Say(stMainMenu, getTextf(lang.Code, "language_changed", i18n.Args{"language": lang.Name})+"\n\n"+mainMenuText(t.s)), nil
+		},
+		To: []flow.StageID{stMainMenu},
+	})
+
+	// first contact: one tap keeps the guessed language, or picks another
+	flow.Add(m, stLanguageConfirm, flow.Stage[*turn, string]{
+		Enter: languageConfirmText,
+		Parse: flow.Parse[*turn],
+		Next: func(t *turn, choice string) (flow.Transition, error) {
+			lang, ok := pickLanguage(languageOptions(t.s.Language), choice)
+			if !ok {
+				return flow.Stay(""), nil
+			}
+			t.s.Language, t.s.LanguageSet = lang.Code, true
+			return flow.Go(stEnrollPIN), nil
+		},
+		To: []flow.StageID{stEnrollPIN},
+	})
+}
+
+// pickLanguage reads a numbered choice from options
+func pickLanguage(options []i18n.Language, choice string) (i18n.Language, bool) {
+	i, err := strconv.Atoi(choice)
+	if err != nil || i < 1 || i > len(options) {
+		return i18n.Language{}, false
+	}
+	return options[i-1], true
+}
+
+// languageList numbers options one per line, as the menus do
+func languageList(options []i18n.Language) string {
+	out := ""
+	for i, lang := range options {
+		out += fmt.Sprintf("%d️⃣ %s\n", i+1, lang.Name)
+	}
+	return out
+}
+
+// languageMenuText offers every language in the registry, headed in each of
+// them since the member may not read the one they are set to
+func languageMenuText(t *turn) string {
+	var titles, backs []string
+	for _, lang := range catalogs.Languages() {
+		titles = append(titles, getText(lang.Code, "choose_language"))
+		backs = append(backs, getText(lang.Code, "back"))
+	}
+	return "🌍 " + strings.Join(titles, " / ") + ":\n\n" +
+		languageList(catalogs.Languages()) + "0️⃣ " + strings.Join(backs, " / ")
+}
+
+// languageOptions lists the registry's languages in menu order with current
+// first
+func languageOptions(current string) []i18n.Language {
+	var options []i18n.Language
+	for _, lang := range catalogs.Languages() {
+		if lang.Code == current {
+			options = append([]i18n.Language{lang}, options...)
 		} else {
-			response = getText(s.Language, "transaction_cancelled")
-			s.Stage = "post_action"
+			options = append(options, lang)
 		}
+	}
+	return options
+}
 
-	case "airtime":
-		amt, err := parseAmount(body)
+// languageConfirmText asks, in the guessed language, whether to keep it
+func languageConfirmText(t *turn) string {
+	options := languageOptions(t.s.Language)
+	return getTextf(t.s.Language, "language_detected", i18n.Args{"language": options[0].Name}) + "\n\n" + strings.TrimSuffix(languageList(options), "\n")
+}
+
+// claimHandle gives the session the chosen handle unless another user has it
+func claimHandle(t *turn, handle string) (flow.Transition, error) {
+	if handle == "" {
+		return flow.Go(stMainMenu), nil
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	owner, err := profiles.FindHandle(handle)
+	if err == nil && owner != t.from {
+		return flow.Transition{}, flow.Reject(getTextf(t.s.Language, "handle_taken", i18n.Args{"handle": handle}))
+	} else if err != nil && err != bot.ErrNotFound {
+		return flow.Transition{}, err
+	}
+	// saved while mu is held so no one else can take the handle meanwhile
+	t.s.Handle = handle
+	if err := profiles.Save(t.from, &t.s.Profile); err != nil {
+		return flow.Transition{}, err
+	}
+	return flow.Go(stMainMenu), nil
+}
+
+// recipient is who a send-money reply resolved to
+type recipient struct {
+	id, name string
+}
+
+func addWalletStages(m *flow.Machine[*turn]) {
+	flow.Add(m, stMainMenu, flow.Stage[*turn, string]{
+		Enter: func(t *turn) string { return mainMenuText(t.s) },
+		Parse: flow.Parse[*turn],
+		Next:  mainMenu,
+		To: []flow.StageID{stPostAction, stSendTo, stAirtime, stSupport,
+			stLoanMenu, stLanguageMenu},
+	})
+
+	flow.Add(m, stSendTo, flow.Stage[*turn, recipient]{
+		Enter: prompt("send_to_who"),
+		Parse: func(t *turn, input string) (recipient, error) {
+			to, rs, err := resolveRecipient(input)
+			if err == bot.ErrNotFound {
+				return recipient{}, flow.Reject(getTextf(t.s.Language, "recipient_unknown", i18n.Args{"recipient": input}))
+			} else if err != nil {
+				return recipient{}, err
+			}
+			return recipient{id: to, name: displayName(to, rs)}, nil
+		},
+		Validate: []func(*turn, recipient) error{
+			func(t *turn, r recipient) error {
+				if r.id == t.from {
+					return reject(t, "recipient_self")
+				}
+				return nil
+			},
+		},
+		Next: func(t *turn, r recipient) (flow.Transition, error) {
+			t.s.PendingTo = r.id
+			t.s.PendingName = r.name
+			return flow.Go(stSendAmount), nil
+		},
+		To: []flow.StageID{stSendAmount},
+	})
+
+	flow.Add(m, stSendAmount, flow.Stage[*turn, money.Money]{
+		Enter: func(t *turn) string {
+			return getTextf(t.s.Language, "send_how_much", i18n.Args{"recipient": t.s.PendingName})
+		},
+		Parse: amountIn("invalid_amount"),
+		Next: func(t *turn, amt money.Money) (flow.Transition, error) {
+			t.s.PendingAmt = amt
+			return flow.Go(stConfirmSend), nil
+		},
+		To: []flow.StageID{stConfirmSend},
+	})
+
+	flow.Add(m, stConfirmSend, flow.Stage[*turn, string]{
+		Enter: func(t *turn) string {
+			return getTextf(t.s.Language, "confirm_send", i18n.Args{"amount": t.s.PendingAmt.Format(t.s.Language), "recipient": t.s.PendingName})
+		},
+		Parse: flow.Parse[*turn],
+		Next: func(t *turn, answer string) (flow.Transition, error) {
+			switch {
+			case answer == "1" || isWord(t.s.Language, answer, "yes", "yes_word"):
+				t.s.PendingAction = "send"
+				return flow.Go(stConfirmPIN), nil
+			case answer == "2" || answer == "0" || isWord(t.s.Language, answer, "no", "no_word"):
+				return flow.Say(stPostAction, getText(t.s.Language, "transaction_cancelled")), nil
+			}
+			return flow.Stay(""), nil
+		},
+		To: []flow.StageID{stConfirmPIN, stPostAction},
+	})
+
+	flow.Add(m, stAirtime, flow.Stage[*turn, money.Money]{
+		Enter: prompt("airtime_prompt"),
+		Parse: amountIn("airtime_invalid"),
+		Next: func(t *turn, amt money.Money) (flow.Transition, error) {
+			t.s.PendingAmt = amt
+			t.s.PendingAction = "airtime"
+			return flow.Go(stConfirmPIN), nil
+		},
+		To: []flow.StageID{stConfirmPIN},
+	})
+
+	flow.Add(m, stSupport, flow.Stage[*turn, string]{
+		Enter: prompt("support_menu"),
+		Parse: flow.Parse[*turn],
+		Next: func(t *turn, choice string) (flow.Transition, error) {
+			keys := map[string]string{"1": "support_lost_card", "2": "support_issue_logged", "3": "support_agent"}
+			key, ok := keys[choice]
+			if !ok {
+				return flow.Stay(getText(t.s.Language, "choose_valid_support")), nil
+			}
+			return flow.Say(stPostAction, getText(t.s.Language, key)+"\n\n"+getText(t.s.Language, "post_action_menu")), nil
+		},
+		To: []flow.StageID{stPostAction},
+	})
+
+	flow.Add(m, stPostAction, flow.Stage[*turn, string]{
+		Enter: prompt("post_action_menu"),
+		Parse: flow.Parse[*turn],
+		Next: func(t *turn, choice string) (flow.Transition, error) {
+			if choice == "1" {
+				return flow.Go(stMainMenu), nil
+			} else if choice == "0" || isWord(t.s.Language, choice, "no", "no_word") {
+				signOut(t.s)
+				return flow.Say(stAskPIN, getText(t.s.Language, "goodbye")), nil
+			}
+			return flow.Stay(""), nil
+		},
+		To: []flow.StageID{stMainMenu, stAskPIN},
+	})
+}
+
+func mainMenu(t *turn, choice string) (flow.Transition, error) {
+	s := t.s
+	wallet := ledger.Wallet(t.from)
+	switch choice {
+	case "1":
+		bal, err := books.Balance(wallet)
 		if err != nil {
-			response = getText(s.Language, "airtime_invalid")
-			break
+			return flow.Transition{}, err
 		}
-		if s.Balance >= amt {
-			s.Balance -= amt
-			tx := getTextf(s.Language, "bought_airtime", amt)
-			s.Transactions = append([]string{tx}, s.Transactions...)
-			response = getTextf(s.Language, "airtime_success", s.Balance)
-		} else {
-			response = getText(s.Language, "not_enough_balance")
+		return flow.Say(stPostAction, getTextf(s.Language, "your_balance", i18n.Args{"balance": bal.Format(s.Language)})), nil
+	case "2":
+		return flow.Go(stSendTo), nil
+	case "3":
+		return flow.Go(stAirtime), nil
+	case "4":
+		return flow.Say(stPostAction, getText(s.Language, "bills_demo")), nil
+	case "5":
+		history, err := books.History(wallet)
+		if err != nil {
+			return flow.Transition{}, err
 		}
-		s.Stage = "post_action"
-
-	case "support":
-		switch body {
-		case "1":
-			response = getText(s.Language, "support_lost_card")
-		case "2":
-			response = getText(s.Language, "support_issue_logged")
-		case "3":
-			response = getText(s.Language, "support_agent")
-		default:
-			response = getText(s.Language, "choose_valid_support")
-			respondXML(w, response)
-			return
+		var lines []string
+		for _, e := range history {
+			if line := transactionText(s.Language, e, wallet); line != "" {
+				lines = append(lines, line)
+			}
 		}
-		response += "\n\n" + getText(s.Language, "post_action_menu")
-		s.Stage = "post_action"
-
-	case "post_action":
-		if body == "1" {
-			s.Stage = "main_menu"
-			response = mainMenuText(s)
-		} else if body == "0" || strings.Contains(body, "no") {
-			delete(sessions, from)
-			response = getText(s.Language, "goodbye")
-		} else {
-			response = getText(s.Language, "post_action_menu")
+		txs := getText(s.Language, "no_transactions")
+		if len(lines) > 0 {
+			txs = strings.Join(lines, "\n")
 		}
+		return flow.Say(stPostAction, getTextf(s.Language, "recent_transactions", i18n.Args{"transactions": txs})), nil
+	case "6":
+		return flow.Go(stSupport), nil
+	case "7":
+		return flow.Go(stLoanMenu), nil
+	case "8":
+		return flow.Go(stLanguageMenu), nil
+	}
+	return flow.Stay(getText(s.Language, "choose_valid_option")), nil
+}
 
-	// ---------- LANGUAGE MENU ----------
-	case "language_menu":
-		switch body {
-		case "1":
-			s.Language = "en"
-			response = getTextf(s.Language, "language_changed", "English")
-			s.Stage = "main_menu"
-			response += "\n\n" + mainMenuText(s)
-		case "2":
-			s.Language = "sn"
-			response = getTextf(s.Language, "language_changed", "Shona")
-			s.Stage = "main_menu"
-			response += "\n\n" + mainMenuText(s)
-		case "3":
-			s.Language = "nd"
-			response = getTextf(s.Language, "language_changed", "Ndebele")
-			s.Stage = "main_menu"
-			response += "\n\n" + mainMenuText(s)
-		case "0":
-			s.Stage = "main_menu"
-			response = mainMenuText(s)
-		default:
-			response = getText(s.Language, "language_menu")
-		}
+// regionChoices maps region menu options to regions
+var regionChoices = map[string]string{"1": "Tabhera", "2": "Nyika"}
+
+func addLoanStages(m *flow.Machine[*turn]) {
+	flow.Add(m, stLoanMenu, flow.Stage[*turn, string]{
+		Enter: func(t *turn) string { return loanMenuText(t.from, t.s) },
+		Parse: flow.Parse[*turn],
+		Next:  loanMenu,
+		To: []flow.StageID{stLoanRequestName, stRecommendList, stSwitchRoleMenu,
+			stBorrowList, stApproverList, stManageMembers, stRepayList, stLoanStatus, stMainMenu},
+	})
+
+	// Loan status: the user's loans, then the history of the one they pick
+	flow.Add(m, stLoanStatus, flow.Stage[*turn, string]{
+		Enter: func(t *turn) string {
+			return viewLoansForApplicant(t.from, t.s) + getText(t.s.Language, "status_footer")
+		},
+		Parse: flow.Parse[*turn],
+		Next:  showLoanHistory,
+		To:    []flow.StageID{stLoanMenu},
+	})
+
+	// Repay: choose one of your loans, then how much to pay from the wallet
+	flow.Add(m, stRepayList, flow.Stage[*turn, string]{
+		Enter: func(t *turn) string { return repayListPrompt(t.from, t.s) },
+		Parse: flow.Parse[*turn],
+		Next:  chooseLoanToRepay,
+		To:    []flow.StageID{stLoanMenu, stRepayAmount},
+	})
+
+	flow.Add(m, stRepayAmount, flow.Stage[*turn, money.Money]{
+		Parse: amountIn("invalid_amount"),
+		Next:  repayAmount,
+		To:    []flow.StageID{stLoanMenu, stConfirmPIN},
+	})
+
+	flow.Add(m, stManageMembers, flow.Stage[*turn, memberCommand]{
+		Enter: prompt("members_menu"),
+		Parse: parseMemberCommand,
+		Next:  manageMembers,
+		To:    []flow.StageID{stLoanMenu},
+	})
 
-	// ---------- LOAN MENU ----------
-	case "loan_menu":
-		switch strings.TrimSpace(body) {
-		case "1": // Request Loan
-			s.Stage = "loan_request_name"
-			response = getText(s.Language, "loan_request_name")
-		case "2": // View Loan Status
-			response = viewLoansForApplicant(s.Name)
-		case "3": // Recommend Borrower
-			// show list of pending loans in same region
-			s.Stage = "recommend_list"
-			response = recommendListPrompt(s)
-		case "4": // Switch Role
-			s.Stage = "switch_role_menu"
-			response = switchRoleMenuText(s)
-		case "5": // Borrow Funds
-			s.Stage = "borrow_list"
-			response = borrowListPrompt(s)
-		case "6": // Approve Loans (for approvers)
-			if s.Role != "mufundisi" && s.Role != "elder" {
-				response = getText(s.Language, "approver_switch")
-			} else {
-				s.Stage = "approver_list"
-				response = approverListPrompt(s)
+	// Loan request sub-steps
+	flow.Add(m, stLoanRequestName, flow.Stage[*turn, string]{
+		Enter: prompt("loan_request_name"),
+		Parse: flow.Parse[*turn],
+		Next: func(t *turn, name string) (flow.Transition, error) {
+			t.s.PendingName = strings.Title(name)
+			return flow.Go(stLoanRequestID), nil
+		},
+		To: []flow.StageID{stLoanRequestID},
+	})
+
+	flow.Add(m, stLoanRequestID, flow.Stage[*turn, string]{
+		Enter: prompt("loan_request_id"),
+		Parse: flow.Parse[*turn],
+		Next: func(t *turn, id string) (flow.Transition, error) {
+			t.s.PendingApplicantID = strings.ToUpper(strings.TrimSpace(id))
+			return flow.Go(stLoanRequestRegion), nil
+		},
+		To: []flow.StageID{stLoanRequestRegion},
+	})
+
+	flow.Add(m, stLoanRequestRegion, flow.Stage[*turn, string]{
+		Enter: prompt("loan_request_region"),
+		Parse: func(t *turn, choice string) (string, error) {
+			region, ok := regionChoices[choice]
+			if !ok {
+				return "", reject(t, "choose_region")
 			}
-		case "0":
-			s.Stage = "main_menu"
-			response = mainMenuText(s)
-		default:
-			response = loanMenuText(s)
-		}
+			return region, nil
+		},
+		Next: func(t *turn, region string) (flow.Transition, error) {
+			t.s.PendingRegion = region
+			return flow.Go(stLoanRequestAmount), nil
+		},
+		To: []flow.StageID{stLoanRequestAmount},
+	})
+
+	flow.Add(m, stLoanRequestAmount, flow.Stage[*turn, money.Money]{
+		Enter: prompt("loan_request_amount"),
+		Parse: amountIn("invalid_amount"),
+		Next: func(t *turn, amt money.Money) (flow.Transition, error) {
+			s := t.s
+			loan, err := createLoan(s.PendingName, s.PendingApplicantID, s.PendingRegion, amt, t.from)
+			if err != nil {
+				return flow.Transition{}, err
+			}
+			logLoanEvent(t, loan, bot.EventSubmitted, eventDetail{
+				note: "requested " + amt.String(), key: "event_detail_requested", amounts: map[string]money.Money{"amount": amt},
+			})
+			notifyApprovers(t, loan)
+			return flow.Say(stPostAction, getTextf(s.Language, "loan_submitted", i18n.Args{"loan": loan.ID})), nil
+		},
+		To: []flow.StageID{stPostAction},
+	})
+
+	// Recommend list: user chooses number; "0" parses as ""
+	flow.Add(m, stRecommendList, flow.Stage[*turn, string]{
+		Enter: func(t *turn) string { return recommendListPrompt(t.from, t.s) },
+		Parse: func(t *turn, choice string) (string, error) {
+			if choice == "0" {
+				return "", nil
+			}
+			loanID, ok := t.s.TempLoanList[choice]
+			if !ok {
+				return "", reject(t, "recommend_invalid")
+			}
+			return loanID, nil
+		},
+		Next: func(t *turn, loanID string) (flow.Transition, error) {
+			if loanID == "" {
+				return flow.Go(stLoanMenu), nil
+			}
+			loan, err := loans.Get(loanID)
+			if err != nil {
+				return flow.Stay(getText(t.s.Language, "recommend_not_found")), nil
+			}
+			if _, tr, err := authorize(t, bot.RecommendLoan, loan); tr != nil {
+				return *tr, err
+			}
+			t.s.PendingLoan = loanID
+			return flow.Say(stRecommendAction, getTextf(t.s.Language, "recommend_question", i18n.Args{"applicant": loan.ApplicantName})), nil
+		},
+		To: []flow.StageID{stLoanMenu, stRecommendAction},
+	})
+
+	// recommend action: yes/no
+	flow.Add(m, stRecommendAction, flow.Stage[*turn, string]{
+		Parse: flow.Parse[*turn],
+		Next: func(t *turn, answer string) (flow.Transition, error) {
+			switch answer {
+			case "1":
+				return recommendLoan(t)
+			case "2":
+				return flow.Go(stRecommendReason), nil
+			}
+			return flow.Stay(getText(t.s.Language, "recommend_yes_no")), nil
+		},
+		To: []flow.StageID{stLoanMenu, stRecommendReason},
+	})
+
+	flow.Add(m, stRecommendReason, flow.Stage[*turn, string]{
+		Enter: prompt("recommend_reason"),
+		Parse: flow.Parse[*turn],
+		Next:  declineRecommendation,
+		To:    []flow.StageID{stLoanMenu},
+	})
 
-	// Loan request sub-steps
-	case "loan_request_name":
-		s.PendingName = strings.Title(body)
-		s.Stage = "loan_request_id"
-		response = getText(s.Language, "loan_request_id")
-
-	case "loan_request_id":
-		s.PIN = strings.ToUpper(strings.TrimSpace(body)) // temporarily store applicant ID in PIN
-		s.Stage = "loan_request_region_choice"
-		response = getText(s.Language, "loan_request_region")
-
-	case "loan_request_region_choice":
-		if body == "1" {
-			s.Region = "Tabhera"
-		} else if body == "2" {
-			s.Region = "Nyika"
-		} else {
-			response = getText(s.Language, "choose_region")
-			respondXML(w, response)
-			return
-		}
-		s.Stage = "loan_request_amount"
-		response = getText(s.Language, "loan_request_amount")
+	// Approver list stage: approver chooses loan ID to act on
+	flow.Add(m, stApproverList, flow.Stage[*turn, string]{
+		Enter: func(t *turn) string { return approverListPrompt(t.from, t.s) },
+		Parse: flow.Parse[*turn],
+		Next:  chooseLoanToApprove,
+		To:    []flow.StageID{stLoanMenu, stApproverAction},
+	})
+
+	flow.Add(m, stApproverAction, flow.Stage[*turn, string]{
+		Parse: flow.Parse[*turn],
+		Next:  approverAction,
+		To:    []flow.StageID{stLoanMenu},
+	})
+
+	// Switch role: pick a role, then the region to act in; "0" goes back and
+	// parses as ""
+	flow.Add(m, stSwitchRoleMenu, flow.Stage[*turn, string]{
+		Enter: func(t *turn) string { return switchRoleMenuText(t.s) },
+		Parse: func(t *turn, choice string) (string, error) {
+			if choice == "0" {
+				return "", nil
+			}
+			role, ok := roleChoices[choice]
+			if !ok {
+				return "", flow.Reject(switchRoleMenuText(t.s))
+			}
+			return role, nil
+		},
+		Next: chooseRole,
+		To:   []flow.StageID{stLoanMenu, stSwitchRegionMenu},
+	})
+
+	flow.Add(m, stSwitchRegionMenu, flow.Stage[*turn, string]{
+		Enter: func(t *turn) string {
+			return getTextf(t.s.Language, "switch_region_menu", i18n.Args{"role": roleName(t.s.Language, t.s.PendingRole)})
+		},
+		Parse: func(t *turn, choice string) (string, error) {
+			if choice == "0" {
+				return "", nil
+			}
+			region, ok := regionChoices[choice]
+			if !ok {
+				return "", reject(t, "choose_region")
+			}
+			return region, nil
+		},
+		Next: chooseRoleRegion,
+		To:   []flow.StageID{stSwitchRoleMenu, stLoanMenu},
+	})
+
+	// Borrow list stage: user chooses which approved loan to borrow from (if they are the applicant)
+	flow.Add(m, stBorrowList, flow.Stage[*turn, string]{
+		Enter: func(t *turn) string { return borrowListPrompt(t.from, t.s) },
+		Parse: flow.Parse[*turn],
+		Next:  chooseLoanToBorrow,
+		To:    []flow.StageID{stLoanMenu, stBorrowAmount},
+	})
+
+	flow.Add(m, stBorrowAmount, flow.Stage[*turn, money.Money]{
+		Parse: amountIn("borrow_invalid_amount"),
+		Next:  borrowAmount,
+		To:    []flow.StageID{stLoanMenu, stConfirmPIN},
+	})
+}
 
-	case "loan_request_amount":
-		amt, err := parseAmount(body)
+// roleChoices maps switch role menu options to roles
+var roleChoices = map[string]string{"1": "member", "2": "mufundisi", "3": "elder", "4": "recommender"}
+
+// chooseRole moves on to picking a region if the user holds role anywhere
+func chooseRole(t *turn, role string) (flow.Transition, error) {
+	s := t.s
+	if role == "" {
+		return flow.Go(stLoanMenu), nil
+	}
+	held := false
+	for _, region := range bot.Regions {
+		ok, err := bot.Permits(access, t.from, role, region)
 		if err != nil {
-			response = getText(s.Language, "invalid_amount")
-			break
+			return flow.Transition{}, err
 		}
-		loan := createLoan(s.PendingName, s.PIN, s.Region, amt, s.Name)
-		response = getTextf(s.Language, "loan_submitted", loan.ID)
-		s.Stage = "post_action"
-
-	// Recommend list: user chooses number
-	case "recommend_list":
-		choice := strings.TrimSpace(body)
-		if choice == "0" {
-			s.Stage = "loan_menu"
-			response = loanMenuText(s)
-			respondXML(w, response)
-			return
+		held = held || ok
+	}
+	if !held {
+		return flow.Stay(getTextf(s.Language, "role_not_granted", i18n.Args{"role": roleName(s.Language, role)})), nil
+	}
+	s.PendingRole = role
+	return flow.Go(stSwitchRegionMenu), nil
+}
+
+// chooseRoleRegion switches the session to s.PendingRole in region
+func chooseRoleRegion(t *turn, region string) (flow.Transition, error) {
+	s := t.s
+	if region == "" {
+		s.PendingRole = ""
+		return flow.Go(stSwitchRoleMenu), nil
+	}
+	role := s.PendingRole
+	ok, err := bot.Permits(access, t.from, role, region)
+	if err != nil {
+		return flow.Transition{}, err
+	} else if !ok {
+		return flow.Stay(getTextf(s.Language, "role_region_not_granted", i18n.Args{"role": roleName(s.Language, role), "region": regionName(s.Language, region)})), nil
+	}
+	s.Role, s.Region, s.PendingRole = role, region, ""
+	return flow.Say(stLoanMenu, getTextf(s.Language, "role_switched", i18n.Args{"role": roleName(s.Language, role), "region": regionName(s.Language, region)})+"\n\n"+loanMenuText(t.from, s)), nil
+}
+
+// authorize asks the registry whether the user may take act on loan, or
+// hold the role for it when loan is nil. When they may not, the returned
+// transition says why and the member is nil. A session role the registry no
+// longer grants falls back to member.
+func authorize(t *turn, act bot.Action, loan *bot.Loan) (*bot.Member, *flow.Transition, error) {
+	s := t.s
+	m, err := access.Check(t.from, s.Name, act, loan)
+	if err == nil {
+		return m, nil, nil
+	} else if err != bot.ErrForbidden && err != bot.ErrConflict {
+		return nil, &flow.Transition{}, err
+	}
+	msg := ""
+	if err == bot.ErrConflict {
+		msg = getTextf(s.Language, "conflict_of_interest", i18n.Args{"loan": loan.ID})
+	} else {
+		ok, err := bot.Permits(access, t.from, s.Role, s.Region)
+		if err != nil {
+			return nil, &flow.Transition{}, err
+		} else if !ok {
+			s.Role = "member"
 		}
+		msg = getText(s.Language, notAuthorized[act])
+	}
+	s.PendingLoan = ""
+	tr := flow.Say(stLoanMenu, msg)
+	return nil, &tr, nil
+}
+
+// notAuthorized is the refusal shown for each action the registry gates
+var notAuthorized = map[bot.Action]string{
+	bot.ApproveLoan:   "not_authorized_approve",
+	bot.RecommendLoan: "not_authorized_recommend",
+}
+
+// memberCommand is a registry command typed in the manage members stage; an
+// empty verb goes back
+type memberCommand struct {
+	verb   string // "list", "set" or "remove"
+	member bot.Member
+}
 
-		loanID, ok := s.TempLoanList[choice]
-		if !ok {
-			response = getText(s.Language, "recommend_invalid")
-			respondXML(w, response)
-			return
+func parseMemberCommand(t *turn, input string) (memberCommand, error) {
+	f := strings.Fields(input)
+	switch {
+	case len(f) == 1 && f[0] == "0":
+		return memberCommand{}, nil
+	case len(f) == 1 && f[0] == "list":
+		return memberCommand{verb: "list"}, nil
+	case len(f) == 2 && f[0] == "remove":
+		if from, ok := bot.WhatsAppID(f[1]); ok {
+			return memberCommand{verb: "remove", member: bot.Member{From: from}}, nil
 		}
+	case len(f) >= 5 && f[0] == "set":
+		from, okFrom := bot.WhatsAppID(f[1])
+		region, okRegion := bot.NormalizeRegion(f[3])
+		if okFrom && okRegion && bot.ValidRole(f[2]) {
+			return memberCommand{verb: "set", member: bot.Member{
+				From:   from,
+				Name:   strings.Title(strings.Join(f[4:], " ")),
+				Role:   f[2],
+				Region: region,
+			}}, nil
+		}
+	}
+	return memberCommand{}, reject(t, "member_usage")
+}
 
-		loanMu.Lock()
-		loan, exists := loans[loanID]
-		loanMu.Unlock()
-		if !exists {
-			response = getText(s.Language, "recommend_not_found")
-			respondXML(w, response)
-			return
+// manageMembers runs a registry command for an administrator
+func manageMembers(t *turn, cmd memberCommand) (flow.Transition, error) {
+	s := t.s
+	if !access.IsAdmin(t.from) {
+		return flow.Say(stLoanMenu, getText(s.Language, "not_admin")), nil
+	}
+	m := cmd.member
+	number := strings.TrimPrefix(m.From, "whatsapp:")
+	switch cmd.verb {
+	case "list":
+		members, err := stores.Members.List()
+		if err != nil {
+			return flow.Transition{}, err
+		}
+		if len(members) == 0 {
+			return flow.Stay(getText(s.Language, "members_none")), nil
+		}
+		var lines []string
+		for _, m := range members {
+			lines = append(lines, fmt.Sprintf("• %s (%s) — %s, %s", m.Name, strings.TrimPrefix(m.From, "whatsapp:"), roleName(s.Language, m.Role), regionName(s.Language, m.Region)))
 		}
+		return flow.Stay(strings.Join(lines, "\n")), nil
+	case "set":
+		m.UpdatedBy, m.UpdatedAt = t.from, time.Now()
+		if err := stores.Members.Save(&m); err != nil {
+			return flow.Transition{}, err
+		}
+		return flow.Stay(getTextf(s.Language, "member_saved", i18n.Args{"member": m.Name + " (" + number + ")", "role": roleName(s.Language, m.Role), "region": regionName(s.Language, m.Region)})), nil
+	case "remove":
+		err := stores.Members.Delete(m.From)
+		if err == bot.ErrNotFound {
+			return flow.Stay(getTextf(s.Language, "member_unknown", i18n.Args{"member": number})), nil
+		} else if err != nil {
+			return flow.Transition{}, err
+		}
+		return flow.Stay(getTextf(s.Language, "member_removed", i18n.Args{"member": number})), nil
+	}
+	return flow.Go(stLoanMenu), nil
+}
 
-		s.Stage = "recommend_action:" + loanID
-		response = getTextf(s.Language, "recommend_question", loan.ApplicantName)
-		respondXML(w, response)
-		return
+// ownsLoan reports whether from filed loan. Names are whatever members type,
+// so a loan is only ever theirs by the WhatsApp ID that submitted it.
+func ownsLoan(from string, loan *bot.Loan) bool {
+	return loan.SubmittedBy != "" && loan.SubmittedBy == from
+}
 
-	// Approver list stage: approver chooses loan ID to act on
-	case "approver_list":
-		if s.Role != "mufundisi" && s.Role != "elder" {
-			s.Stage = "loan_menu"
-			response = "Switch to approver role first."
-			respondXML(w, response)
-			return
+// repayable reports whether loan is from's and has money owed on it
+func repayable(from string, loan *bot.Loan) bool {
+	return ownsLoan(from, loan) && repay.Owed(loan.Installments).IsPositive()
+}
+
+func chooseLoanToRepay(t *turn, input string) (flow.Transition, error) {
+	s := t.s
+	lid := strings.ToUpper(strings.TrimSpace(input))
+	if lid == "BACK" || lid == "0" {
+		return flow.Go(stLoanMenu), nil
+	}
+	ln, err := loans.Get(lid)
+	if err == bot.ErrNotFound || (err == nil && !repayable(t.from, ln)) {
+		return flow.Stay(getText(s.Language, "repay_unknown")), nil
+	} else if err != nil {
+		return flow.Transition{}, err
+	}
+	next, _ := repay.NextDue(ln.Installments)
+	s.PendingLoan = ln.ID
+	return flow.Say(stRepayAmount, getTextf(s.Language, "repay_how_much", i18n.Args{
+		"loan": ln.ID, "owed": repay.Owed(ln.Installments).Format(s.Language),
+		"due": next.Owed().Format(s.Language), "date": formatDate(s.Language, next.Due),
+	})), nil
+}
+
+// repayAmount checks a repayment of s.PendingLoan before asking for the PIN
+func repayAmount(t *turn, amt money.Money) (flow.Transition, error) {
+	s := t.s
+	loanMu.Lock()
+	ln, err := loans.Get(s.PendingLoan)
+	loanMu.Unlock()
+	if err == bot.ErrNotFound || (err == nil && !repayable(t.from, ln)) {
+		s.PendingLoan = ""
+		return flow.Say(stLoanMenu, getText(s.Language, "repay_unknown")), nil
+	} else if err != nil {
+		return flow.Transition{}, err
+	}
+	if owed := repay.Owed(ln.Installments); amt.Cmp(owed) > 0 {
+		return flow.Stay(getTextf(s.Language, "repay_too_much", i18n.Args{"owed": owed.Format(s.Language)})), nil
+	}
+	s.PendingAmt = amt
+	s.PendingAction = "repay"
+	return flow.Go(stConfirmPIN), nil
+}
+
+func loanMenu(t *turn, choice string) (flow.Transition, error) {
+	s := t.s
+	switch choice {
+	case "1": // Request Loan
+		return flow.Go(stLoanRequestName), nil
+	case "2": // View Loan Status
+		return flow.Go(stLoanStatus), nil
+	case "3": // Recommend Borrower
+		if _, tr, err := authorize(t, bot.RecommendLoan, nil); tr != nil {
+			return *tr, err
 		}
-		lid := strings.ToUpper(strings.TrimSpace(body))
-		if lid == "BACK" || lid == "0" {
-			s.Stage = "loan_menu"
-			response = loanMenuText(s)
-			respondXML(w, response)
-			return
+		return flow.Go(stRecommendList), nil
+	case "4": // Switch Role
+		return flow.Go(stSwitchRoleMenu), nil
+	case "5": // Borrow Funds
+		return flow.Go(stBorrowList), nil
+	case "6": // Approve Loans (for approvers)
+		if s.Role != "mufundisi" && s.Role != "elder" {
+			return flow.Stay(getText(s.Language, "approver_switch")), nil
 		}
-		loanMu.Lock()
-		loan, exists := loans[lid]
-		if !exists {
-			loanMu.Unlock()
-			response = "Loan ID not found. Type the Loan ID shown in the list or 'back'."
-			respondXML(w, response)
-			return
+		if _, tr, err := authorize(t, bot.ApproveLoan, nil); tr != nil {
+			return *tr, err
 		}
-		if !strings.EqualFold(loan.Region, s.Region) {
-			loanMu.Unlock()
-			response = "You can only act on loans in your region."
-			respondXML(w, response)
-			return
+		return flow.Go(stApproverList), nil
+	case "7": // Manage Members (for administrators)
+		if access.IsAdmin(t.from) {
+			return flow.Go(stManageMembers), nil
 		}
-		loanMu.Unlock()
-		// move to action stage
-		s.Stage = "approver_action:" + lid
-		response = fmt.Sprintf("You selected loan %s for %s. Type 'approve' to approve or 'decline <reason>' to decline.", lid, loan.ApplicantName)
+		return flow.Stay(""), nil
+	case "8": // Repay Loan
+		return flow.Go(stRepayList), nil
+	case "0":
+		return flow.Go(stMainMenu), nil
+	}
+	return flow.Stay(""), nil
+}
 
-	// Approver action stage
-	default:
-		// recommend action: yes/no
-		if strings.HasPrefix(s.Stage, "recommend_action:") {
-			loanID := strings.SplitN(s.Stage, ":", 2)[1]
-
-			if body == "1" {
-				loanMu.Lock()
-				loan, exists := loans[loanID]
-				if !exists {
-					loanMu.Unlock()
-					response = getText(s.Language, "recommend_not_found")
-					respondXML(w, response)
-					return
-				}
-				for _, r := range loan.Recommendations {
-					if strings.EqualFold(r, s.Name) {
-						loanMu.Unlock()
-						response = getText(s.Language, "recommend_already")
-						respondXML(w, response)
-						return
-					}
-				}
-				loan.Recommendations = append(loan.Recommendations, s.Name)
-				computeLoanLimits(loan)
-				loanMu.Unlock()
-
-				response = getTextf(s.Language, "recommend_success", loan.ApplicantName)
-				s.Stage = "loan_menu"
-				respondXML(w, response)
-				return
-
-			} else if body == "2" {
-				s.Stage = "recommend_reason:" + loanID
-				response = getText(s.Language, "recommend_reason")
-				respondXML(w, response)
-				return
-			} else {
-				response = getText(s.Language, "recommend_yes_no")
-				respondXML(w, response)
-				return
-			}
+// recommendLoan adds the user's recommendation to loan s.PendingLoan
+func recommendLoan(t *turn) (flow.Transition, error) {
+	s := t.s
+	loanMu.Lock()
+	defer loanMu.Unlock()
+	loan, err := loans.Get(s.PendingLoan)
+	if err != nil {
+		return flow.Stay(getText(s.Language, "recommend_not_found")), nil
+	}
+	_, tr, err := authorize(t, bot.RecommendLoan, loan)
+	if tr != nil {
+		return *tr, err
+	}
+	for _, r := range loan.Recommendations {
+		if strings.EqualFold(r, s.Name) {
+			return flow.Stay(getText(s.Language, "recommend_already")), nil
 		}
+	}
+	if err := loan.Recommend(s.Name); err == bot.ErrDecided {
+		s.PendingLoan = ""
+		return flow.Say(stLoanMenu, getTextf(s.Language, "loan_decided", i18n.Args{"loan": loan.ID, "status": statusName(s.Language, loan.State())})), nil
+	} else if err != nil {
+		return flow.Transition{}, err
+	}
+	computeLoanLimits(loan)
+	if err := loans.Save(loan); err != nil {
+		return flow.Transition{}, err
+	}
+	logLoanEvent(t, loan, bot.EventRecommended, eventDetail{})
+	s.PendingLoan = ""
+	return flow.Say(stLoanMenu, getTextf(s.Language, "recommend_success", i18n.Args{"applicant": loan.ApplicantName})), nil
+}
 
-		// recommend reason
-		if strings.HasPrefix(s.Stage, "recommend_reason:") {
-			loanID := strings.SplitN(s.Stage, ":", 2)[1]
-			reason := strings.TrimSpace(body)
-			loanMu.Lock()
-			loan, exists := loans[loanID]
-			if !exists {
-				loanMu.Unlock()
-				response = getText(s.Language, "recommend_not_found")
-				respondXML(w, response)
-				return
-			}
-			if loan.ApprovalReasons == nil {
-				loan.ApprovalReasons = map[string]string{}
-			}
-			loan.ApprovalReasons[s.Name] = "not recommended: " + reason
-			loanMu.Unlock()
+// declineRecommendation records why the user won't recommend s.PendingLoan
+func declineRecommendation(t *turn, reason string) (flow.Transition, error) {
+	s := t.s
+	reason = strings.TrimSpace(reason)
+	loanMu.Lock()
+	defer loanMu.Unlock()
+	loan, err := loans.Get(s.PendingLoan)
+	if err != nil {
+		return flow.Stay(getText(s.Language, "recommend_not_found")), nil
+	}
+	_, tr, err := authorize(t, bot.RecommendLoan, loan)
+	if tr != nil {
+		return *tr, err
+	}
+	if !loan.Open() {
+		s.PendingLoan = ""
+		return flow.Say(stLoanMenu, getTextf(s.Language, "loan_decided", i18n.Args{"loan": loan.ID, "status": statusName(s.Language, loan.State())})), nil
+	}
+	loan.NoteApprover(t.from, s.Name, "not recommended: "+reason)
+	if err := loans.Save(loan); err != nil {
+		return flow.Transition{}, err
+	}
+	logLoanEvent(t, loan, bot.EventNotRecommended, reasonDetail(reason))
+	s.PendingLoan = ""
+	return flow.Say(stLoanMenu, getTextf(s.Language, "not_recommended", i18n.Args{"reason": reason})), nil
+}
 
-			response = getTextf(s.Language, "not_recommended", reason)
-			s.Stage = "loan_menu"
-			respondXML(w, response)
-			return
-		}
+func chooseLoanToApprove(t *turn, input string) (flow.Transition, error) {
+	s := t.s
+	if s.Role != "mufundisi" && s.Role != "elder" {
+		return flow.Say(stLoanMenu, getText(s.Language, "approver_switch")), nil
+	}
+	lid := strings.ToUpper(strings.TrimSpace(input))
+	if lid == "BACK" || lid == "0" {
+		return flow.Go(stLoanMenu), nil
+	}
+	loan, err := loans.Get(lid)
+	if err != nil {
+		return flow.Stay(getText(s.Language, "approve_unknown")), nil
+	}
+	if !strings.EqualFold(loan.Region, s.Region) {
+		return flow.Stay(getText(s.Language, "approve_other_region")), nil
+	}
+	if !loan.Open() && !loan.Drawable() {
+		return flow.Say(stLoanMenu, getTextf(s.Language, "loan_decided", i18n.Args{"loan": loan.ID, "status": statusName(s.Language, loan.State())})), nil
+	}
+	if _, tr, err := authorize(t, bot.ApproveLoan, loan); tr != nil {
+		return *tr, err
+	}
+	s.PendingLoan = lid
+	msg := getTextf(s.Language, "approve_selected", i18n.Args{"loan": lid, "applicant": loan.ApplicantName, "amount": loan.RequestedAmount.Format(s.Language)})
+	if loan.Underwriting != nil {
+		msg += getTextf(s.Language, "approve_underwriting", i18n.Args{"steps": underwritingText(s.Language, loan.Underwriting)})
+	}
+	msg += getText(s.Language, "approve_how")
+	return flow.Say(stApproverAction, msg), nil
+}
 
-		// approver action
-		if strings.HasPrefix(s.Stage, "approver_action:") {
-			loanID := strings.SplitN(s.Stage, ":", 2)[1]
-			cmd := strings.TrimSpace(body)
-			loanMu.Lock()
-			loan, exists := loans[loanID]
-			if !exists {
-				loanMu.Unlock()
-				response = "Loan not found. Returning to loan menu."
-				s.Stage = "loan_menu"
-				respondXML(w, response)
-				return
-			}
-			if strings.HasPrefix(cmd, "approve") {
-				// record approval
-				if s.Role == "mufundisi" {
-					loan.MufundisiApproved = true
-					if loan.ApprovalReasons == nil {
-						loan.ApprovalReasons = map[string]string{}
-					}
-					loan.ApprovalReasons[s.Name] = "approved"
-					computeLoanLimits(loan)
-					loan.Status = "approved"
-					response = fmt.Sprintf("✅ Mufundisi approved loan %s. Approved limit: $%.2f. Term: %d months.", loan.ID, loan.ApprovedLimit, loan.TermMonths)
-				} else if s.Role == "elder" {
-					if loan.ElderApprovals == nil {
-						loan.ElderApprovals = map[string]bool{}
-					}
-					loan.ElderApprovals[s.Name] = true
-					if loan.ApprovalReasons == nil {
-						loan.ApprovalReasons = map[string]string{}
-					}
-					loan.ApprovalReasons[s.Name] = "approved"
-					// If no mufundisi yet, elders shouldn't set status to approved by themselves
-					computeLoanLimits(loan)
-					if loan.MufundisiApproved {
-						loan.Status = "approved"
-					}
-					response = fmt.Sprintf("✅ Elder approved loan %s. Approved limit: $%.2f. Term: %d months.", loan.ID, loan.ApprovedLimit, loan.TermMonths)
-				} else {
-					response = "Only Mufundisi or Elder can approve loans."
-				}
-				loanMu.Unlock()
-				s.Stage = "loan_menu"
-				respondXML(w, response)
-				return
-			} else if strings.HasPrefix(cmd, "decline") {
-				reason := strings.TrimSpace(strings.TrimPrefix(cmd, "decline"))
-				if reason == "" {
-					reason = "no reason provided"
-				}
-				if loan.ApprovalReasons == nil {
-					loan.ApprovalReasons = map[string]string{}
-				}
-				loan.ApprovalReasons[s.Name] = "declined: " + reason
-				loan.Status = "declined"
-				loan.DeclineReason = reason
-				loanMu.Unlock()
-				s.Stage = "loan_menu"
-				response = fmt.Sprintf("❌ You declined loan %s. Reason: %s", loanID, reason)
-				respondXML(w, response)
-				return
-			} else {
-				loanMu.Unlock()
-				response = "Unknown command. Type 'approve' or 'decline <reason>'."
-				respondXML(w, response)
-				return
-			}
+// approverAction approves or declines loan s.PendingLoan
+func approverAction(t *turn, input string) (flow.Transition, error) {
+	s := t.s
+	cmd := strings.TrimSpace(input)
+	loanMu.Lock()
+	defer loanMu.Unlock()
+	loan, err := loans.Get(s.PendingLoan)
+	if err != nil {
+		s.PendingLoan = ""
+		return flow.Say(stLoanMenu, getText(s.Language, "approve_gone")), nil
+	}
+	member, tr, err := authorize(t, bot.ApproveLoan, loan)
+	if tr != nil {
+		return *tr, err
+	}
+	_, approve := command(s.Language, cmd, "approve", "approve_word")
+	reason, decline := command(s.Language, cmd, "decline", "decline_word")
+	switch {
+	case approve:
+	case decline:
+		if reason == "" {
+			reason = getText(s.Language, "decline_no_reason")
 		}
-
-		// Borrow list stage: user chooses which approved loan to borrow from (if they are the applicant)
-		if s.Stage == "borrow_list" {
-			lid := strings.ToUpper(strings.TrimSpace(body))
-			if lid == "BACK" || lid == "0" {
-				s.Stage = "loan_menu"
-				response = loanMenuText(s)
-				respondXML(w, response)
-				return
-			}
-			loanMu.Lock()
-			ln, exists := loans[lid]
-			if !exists {
-				loanMu.Unlock()
-				response = "Loan ID not found. Type the Loan ID or 'back'."
-				respondXML(w, response)
-				return
-			}
-			if !strings.EqualFold(ln.ApplicantName, s.Name) {
-				loanMu.Unlock()
-				response = "You can only borrow from your own approved loans."
-				respondXML(w, response)
-				return
-			}
-			if ln.Status != "approved" {
-				loanMu.Unlock()
-				response = "Loan is not approved yet."
-				respondXML(w, response)
-				return
-			}
-			maxAvailable := ln.ApprovedLimit - ln.Borrowed
-			if maxAvailable <= 0 {
-				loanMu.Unlock()
-				response = "No funds available to borrow (limit fully used)."
-				respondXML(w, response)
-				return
-			}
-			loanMu.Unlock()
-			s.Stage = "borrow_amount:" + lid
-			response = fmt.Sprintf("Loan %s approved. Enter amount to borrow (max $%.2f):", lid, maxAvailable)
-			respondXML(w, response)
-			return
+	default:
+		return flow.Stay(getText(s.Language, "approve_unknown_command")), nil
+	}
+	// record the vote in the role the registry gives the approver; the
+	// workflow decides the loan once the quorum is met or a decline is final
+	wasOpen := loan.Open()
+	if err := loan.Vote(loanQuorum(loan), t.from, s.Name, member.Role, approve, reason); err == bot.ErrDecided {
+		s.PendingLoan = ""
+		return flow.Say(stLoanMenu, getTextf(s.Language, "loan_decided", i18n.Args{"loan": loan.ID, "status": statusName(s.Language, loan.State())})), nil
+	} else if err != nil {
+		return flow.Transition{}, err
+	}
+	computeLoanLimits(loan)
+	var response string
+	action, detail := bot.EventApproved, eventDetail{
+		note: "limit " + loan.ApprovedLimit.String(), key: "event_detail_limit", amounts: map[string]money.Money{"limit": loan.ApprovedLimit},
+	}
+	if !approve {
+		action, detail = bot.EventDeclined, reasonDetail(reason)
+	}
+	if approve {
+		response = getTextf(s.Language, "approve_done", i18n.Args{
+			"role": member.Role, "loan": loan.ID, "status": statusName(s.Language, loan.State()),
+			"limit": loan.ApprovedLimit.Format(s.Language), "months": loan.TermMonths,
+		})
+	} else {
+		response = getTextf(s.Language, "approve_declined", i18n.Args{"loan": loan.ID, "reason": reason, "status": statusName(s.Language, loan.State())})
+	}
+	if err := loans.Save(loan); err != nil {
+		return flow.Transition{}, err
+	}
+	logLoanEvent(t, loan, action, detail)
+	if wasOpen {
+		switch loan.State() {
+		case bot.Approved:
+			notifyApplicant(loan, "notify_approved", i18n.Args{"loan": loan.ID, "limit": loan.ApprovedLimit, "months": loan.TermMonths})
+		case bot.Declined:
+			notifyApplicant(loan, "notify_declined", i18n.Args{"loan": loan.ID, "reason": loan.DeclineReason})
 		}
+	}
+	s.PendingLoan = ""
+	return flow.Say(stLoanMenu, response), nil
+}
 
-		// borrow amount stage
-		if strings.HasPrefix(s.Stage, "borrow_amount:") {
-			lid := strings.SplitN(s.Stage, ":", 2)[1]
-			amt, err := parseAmount(body)
-			if err != nil {
-				response = "Invalid amount. Try again."
-				respondXML(w, response)
-				return
-			}
-			loanMu.Lock()
-			ln, exists := loans[lid]
-			if !exists {
-				loanMu.Unlock()
-				response = "Loan not found."
-				loanMu.Unlock()
-				s.Stage = "loan_menu"
-				respondXML(w, response)
-				return
-			}
-			if !strings.EqualFold(ln.ApplicantName, s.Name) {
-				loanMu.Unlock()
-				response = "You can only borrow from your own loan."
-				respondXML(w, response)
-				return
-			}
-			if ln.Status != "approved" {
-				loanMu.Unlock()
-				response = "Loan is not approved."
-				respondXML(w, response)
-				return
-			}
-			maxAvailable := ln.ApprovedLimit - ln.Borrowed
-			if amt <= 0 || amt > maxAvailable {
-				loanMu.Unlock()
-				response = fmt.Sprintf("Invalid amount. Enter an amount up to $%.2f.", maxAvailable)
-				respondXML(w, response)
-				return
-			}
-			// disburse
-			ln.Borrowed += amt
-			lnStr := fmt.Sprintf("Loan disbursed: $%.2f (Loan ID: %s)", amt, ln.ID)
-			s.Balance += amt
-			s.Transactions = append([]string{lnStr}, s.Transactions...)
-			loanMu.Unlock()
-			s.Stage = "loan_menu"
-			response = fmt.Sprintf("✅ $%.2f disbursed to your wallet. New balance: $%.2f", amt, s.Balance)
-			respondXML(w, response)
-			return
+// command matches input starting with the English word or its translation
+// at key, in any case, and returns the rest of the input
+func command(language, input, english, key string) (string, bool) {
+	for _, w := range []string{english, getText(language, key)} {
+		if len(input) >= len(w) && strings.EqualFold(input[:len(w)], w) {
+			return strings.TrimSpace(input[len(w):]), true
 		}
+	}
+	return "", false
+}
+
+// isWord reports whether input is the English word or its translation at
+// key and nothing more, in any case
+func isWord(language, input, english, key string) bool {
+	rest, ok := command(language, input, english, key)
+	return ok && rest == ""
+}
 
-		// fallback for unknown states
-		response = "Session expired or unknown state. Say 'Hi' to start again."
-		delete(sessions, from)
+func chooseLoanToBorrow(t *turn, input string) (flow.Transition, error) {
+	s := t.s
+	lid := strings.ToUpper(strings.TrimSpace(input))
+	if lid == "BACK" || lid == "0" {
+		return flow.Go(stLoanMenu), nil
+	}
+	ln, err := loans.Get(lid)
+	if err != nil {
+		return flow.Stay(getText(s.Language, "borrow_unknown")), nil
+	}
+	if !ownsLoan(t.from, ln) {
+		return flow.Stay(getText(s.Language, "borrow_not_yours")), nil
+	}
+	if !ln.Drawable() {
+		return flow.Stay(getText(s.Language, "borrow_not_approved")), nil
 	}
+	maxAvailable := ln.ApprovedLimit.Sub(ln.Borrowed)
+	if !maxAvailable.IsPositive() {
+		return flow.Stay(getText(s.Language, "borrow_limit_used")), nil
+	}
+	s.PendingLoan = lid
+	return flow.Say(stBorrowAmount, getTextf(s.Language, "borrow_how_much", i18n.Args{"loan": lid, "max": maxAvailable.Format(s.Language)})), nil
+}
 
-	respondXML(w, response)
+// borrowAmount checks a draw on s.PendingLoan before asking for the PIN
+func borrowAmount(t *turn, amt money.Money) (flow.Transition, error) {
+	s := t.s
+	loanMu.Lock()
+	ln, err := loans.Get(s.PendingLoan)
+	loanMu.Unlock()
+	if err != nil {
+		s.PendingLoan = ""
+		return flow.Say(stLoanMenu, getText(s.Language, "borrow_gone")), nil
+	}
+	if !ownsLoan(t.from, ln) {
+		return flow.Stay(getText(s.Language, "borrow_not_yours")), nil
+	}
+	if !ln.Drawable() {
+		return flow.Stay(getText(s.Language, "borrow_not_approved")), nil
+	}
+	if maxAvailable := ln.ApprovedLimit.Sub(ln.Borrowed); amt.Cmp(maxAvailable) > 0 {
+		return flow.Stay(getTextf(s.Language, "borrow_too_much", i18n.Args{"max": maxAvailable.Format(s.Language)})), nil
+	}
+	s.PendingAmt = amt
+	s.PendingAction = "borrow"
+	return flow.Go(stConfirmPIN), nil
 }
 
 // ------- Helper UI / logic functions -------
 
 // getText retrieves translated text for the given language and key
 func getText(language, key string) string {
-	if langMap, ok := translations[language]; ok {
-		if text, ok := langMap[key]; ok {
-			return text
-		}
-	}
-	// Fallback to English if translation not found
-	if langMap, ok := translations["en"]; ok {
-		if text, ok := langMap[key]; ok {
-			return text
-		}
-	}
-	// Last resort: return the key itself
-	return key
+	return catalogs.Text(language, key)
 }
 
-// getTextf retrieves translated text and formats it with the provided arguments
-func getTextf(language, key string, args ...interface{}) string {
-	text := getText(language, key)
-	return fmt.Sprintf(text, args...)
+// getTextf retrieves translated text and fills in its named arguments
+func getTextf(language, key string, args i18n.Args) string {
+	return catalogs.Textf(language, key, args)
 }
 
-func mainMenuText(s *Session) string {
-	menu := getTextf(s.Language, "good_day", s.Name)
+func mainMenuText(s *bot.Session) string {
+	menu := getTextf(s.Language, "good_day", i18n.Args{"name": s.Name})
 	menu += "\n\n"
 	menu += getText(s.Language, "menu_1_balance") + "\n"
 	menu += getText(s.Language, "menu_2_send") + "\n"
@@ -883,8 +1470,8 @@ func mainMenuText(s *Session) string {
 	return menu
 }
 
-func loanMenuText(s *Session) string {
-	menu := getTextf(s.Language, "loan_menu_title", strings.Title(s.Role), s.Region)
+func loanMenuText(from string, s *bot.Session) string {
+	menu := getTextf(s.Language, "loan_menu_title", i18n.Args{"role": roleName(s.Language, s.Role), "region": regionName(s.Language, s.Region)})
 	menu += getText(s.Language, "loan_menu_1") + "\n"
 	menu += getText(s.Language, "loan_menu_2") + "\n"
 	menu += getText(s.Language, "loan_menu_3") + "\n"
@@ -893,131 +1480,605 @@ func loanMenuText(s *Session) string {
 	if s.Role == "mufundisi" || s.Role == "elder" {
 		menu += getText(s.Language, "loan_menu_6") + "\n"
 	}
+	if access.IsAdmin(from) {
+		menu += getText(s.Language, "loan_menu_7") + "\n"
+	}
+	menu += getText(s.Language, "loan_menu_8") + "\n"
 	menu += getText(s.Language, "loan_menu_0")
 	menu += getText(s.Language, "loan_menu_note")
 	return menu
 }
 
-func switchRoleMenuText(s *Session) string {
+func switchRoleMenuText(s *bot.Session) string {
 	return getText(s.Language, "switch_role_menu")
 }
 
-// respondXML encodes TwiML response
-func respondXML(w http.ResponseWriter, msg string) {
-	w.Header().Set("Content-Type", "application/xml")
-	xml.NewEncoder(w).Encode(MessageResponse{Message: msg})
-}
-
-func parseAmount(s string) (float64, error) {
-	s = strings.ReplaceAll(s, "$", "")
+// parseAmount reads the amount at the start of a reply ("$2 to 0772123456")
+// strictly: see money.Parse
+func parseAmount(s string) (money.Money, error) {
 	fields := strings.Fields(s)
 	if len(fields) > 0 {
 		s = fields[0]
 	}
-	return strconv.ParseFloat(s, 64)
+	return money.Parse(s, money.USD)
 }
 
-func isNumeric(s string) bool {
-	_, err := strconv.Atoi(s)
-	return err == nil
+// checkPIN verifies a typed PIN against the session's, returning the reply
+// for a failed attempt. Malformed input isn't counted as an attempt.
+func checkPIN(s *bot.Session, body string) (bool, string) {
+	now := time.Now()
+	if !s.PIN.Locked(now) && !auth.ValidFormat(body) {
+		return false, getText(s.Language, "pin_invalid")
+	}
+	switch err := s.PIN.Verify(body, now); err {
+	case nil:
+		return true, ""
+	case auth.ErrLocked:
+		mins := int(math.Ceil(s.PIN.LockedFor(now).Minutes()))
+		return false, getTextf(s.Language, "pin_locked", i18n.Args{"minutes": mins})
+	default:
+		return false, getTextf(s.Language, "pin_wrong", i18n.Args{"attempts": s.PIN.Remaining()})
+	}
+}
+
+// clearPending drops the data of an unfinished action
+func clearPending(s *bot.Session) {
+	s.PendingName = ""
+	s.PendingTo = ""
+	s.PendingAmt = money.Money{}
+	s.PendingLoan = ""
+	s.PendingAction = ""
+	s.PendingRole = ""
+	s.PendingApplicantID = ""
+	s.PendingRegion = ""
+}
+
+// signOut ends the conversation but keeps the profile: the next message
+// asks for their PIN again
+func signOut(s *bot.Session) {
+	s.Conversation = bot.Conversation{Stage: stAskPIN}
+}
+
+// expired reports whether a conversation still in progress has timed out
+func expired(c *bot.Conversation, now time.Time) bool {
+	return c.Stage != stAskPIN && sessionExpiry.Expired(c, now)
+}
+
+// expire signs out a timed-out conversation; the next reply says why
+func expire(s *bot.Session) {
+	signOut(s)
+	s.TimedOut = true
+}
+
+// sendMoney posts the confirmed transfer to s.PendingTo. One entry debits the
+// sender and credits the recipient, so either both wallets move or neither.
+func sendMoney(s *bot.Session, from string) (string, error) {
+	wallet := ledger.Wallet(from)
+	entry := ledger.Transfer(ledger.KindTransfer, wallet, ledger.Wallet(s.PendingTo), s.PendingAmt,
+		map[string]string{"to": s.PendingName, "from": displayName(from, &s.Profile)})
+	if _, err := books.Post(entry); err == ledger.ErrInsufficientFunds {
+		return getText(s.Language, "insufficient_funds"), nil
+	} else if err != nil {
+		return "", err
+	}
+	bal, err := books.Balance(wallet)
+	if err != nil {
+		return "", err
+	}
+	return getTextf(s.Language, "transaction_success", i18n.Args{"balance": bal.Format(s.Language)}), nil
 }
 
-// createLoan creates a loan with a unique ID
-func createLoan(name, appid, region string, amount float64, submittedBy string) *Loan {
+// buyAirtime posts the confirmed airtime purchase of s.PendingAmt
+func buyAirtime(s *bot.Session, from string) (string, error) {
+	wallet := ledger.Wallet(from)
+	entry := ledger.Transfer(ledger.KindAirtime, wallet, ledger.AirtimePayable, s.PendingAmt, nil)
+	if _, err := books.Post(entry); err == ledger.ErrInsufficientFunds {
+		return getText(s.Language, "not_enough_balance"), nil
+	} else if err != nil {
+		return "", err
+	}
+	bal, err := books.Balance(wallet)
+	if err != nil {
+		return "", err
+	}
+	return getTextf(s.Language, "airtime_success", i18n.Args{"balance": bal.Format(s.Language)}), nil
+}
+
+// drawLoan disburses s.PendingAmt from loan s.PendingLoan into the wallet.
+// The loan is checked again since it may have changed while the PIN was asked.
+func drawLoan(t *turn) (string, error) {
+	s := t.s
+	loanMu.Lock()
+	defer loanMu.Unlock()
+	ln, err := loans.Get(s.PendingLoan)
+	if err == bot.ErrNotFound {
+		return getText(s.Language, "borrow_gone"), nil
+	} else if err != nil {
+		return "", err
+	}
+	if !ownsLoan(t.from, ln) {
+		return getText(s.Language, "borrow_not_yours"), nil
+	}
+	if !ln.Drawable() {
+		return getText(s.Language, "borrow_not_approved"), nil
+	}
+	amt := s.PendingAmt
+	if maxAvailable := ln.ApprovedLimit.Sub(ln.Borrowed); amt.Cmp(maxAvailable) > 0 {
+		return getTextf(s.Language, "borrow_too_much", i18n.Args{"max": maxAvailable.Format(s.Language)}), nil
+	}
+	// the first draw fixes the loan's pricing; each draw is repaid over the
+	// loan's term
+	if !ln.Terms.Valid() {
+		ln.Terms = loanTerms
+	}
+	sched, err := repay.Schedule(ln.Terms, amt, ln.TermMonths, time.Now())
+	if err != nil {
+		return "", err
+	}
+	// record the draw on the loan first so a failed posting can be undone
+	// without money having moved
+	before, status := ln.Installments, ln.Status
+	ln.Borrowed = ln.Borrowed.Add(amt)
+	ln.Installments = repay.Merge(before, sched)
+	if ln.State() == bot.Approved {
+		if err := ln.MoveTo(bot.Disbursed); err != nil {
+			return "", err
+		}
+	}
+	if err := loans.Save(ln); err != nil {
+		return "", err
+	}
+	wallet := ledger.Wallet(t.from)
+	entry := ledger.Transfer(ledger.KindDisbursement, ledger.LoanReceivable(ln.ID), wallet, amt,
+		map[string]string{"loan_id": ln.ID})
+	if _, err := books.Post(entry); err != nil {
+		ln.Borrowed = ln.Borrowed.Sub(amt)
+		ln.Installments, ln.Status = before, status
+		if err := loans.Save(ln); err != nil {
+			log.Printf("undo draw on %s: %v", ln.ID, err)
+		}
+		return "", err
+	}
+	logLoanEvent(t, ln, bot.EventDisbursed, eventDetail{
+		note: amt.String(), key: "event_detail_disbursed", amounts: map[string]money.Money{"amount": amt},
+	})
+	notifyApplicant(ln, "notify_disbursed", i18n.Args{"amount": amt, "loan": ln.ID})
+	bal, err := books.Balance(wallet)
+	if err != nil {
+		return "", err
+	}
+	return getTextf(s.Language, "borrow_success", i18n.Args{"amount": amt.Format(s.Language), "balance": bal.Format(s.Language)}), nil
+}
+
+// repayLoan pays s.PendingAmt from the wallet into loan s.PendingLoan. The
+// wallet is debited once; the loan receivable is credited with the principal
+// part and interest income with the interest part.
+func repayLoan(t *turn) (string, error) {
+	s := t.s
 	loanMu.Lock()
 	defer loanMu.Unlock()
-	loanCounter++
-	id := fmt.Sprintf("L%04d", loanCounter)
-	ln := &Loan{
-		ID:               id,
-		ApplicantName:    name,
-		ApplicantID:      appid,
-		Region:           region,
-		RequestedAmount:  amount,
-		Status:           "pending",
-		ElderApprovals:   map[string]bool{},
-		ApprovalReasons:  map[string]string{},
-		Recommendations:  []string{},
-		ApprovedLimit:    0,
-		TermMonths:       0,
-		Borrowed:         0,
+	ln, err := loans.Get(s.PendingLoan)
+	if err == bot.ErrNotFound || (err == nil && !repayable(t.from, ln)) {
+		return getText(s.Language, "repay_unknown"), nil
+	} else if err != nil {
+		return "", err
+	}
+	amt := s.PendingAmt
+	if owed := repay.Owed(ln.Installments); amt.Cmp(owed) > 0 {
+		return getTextf(s.Language, "repay_too_much", i18n.Args{"owed": owed.Format(s.Language)}), nil
+	}
+	// as with draws, the loan is saved first and restored if posting fails
+	before, status := ln.Installments, ln.Status
+	ln.Installments = append([]repay.Installment(nil), before...)
+	principal, interest := repay.Apply(ln.Installments, amt)
+	if !repay.Owed(ln.Installments).IsPositive() {
+		if err := ln.MoveTo(bot.Closed); err != nil {
+			return "", err
+		}
+	}
+	if err := loans.Save(ln); err != nil {
+		return "", err
+	}
+	wallet := ledger.Wallet(t.from)
+	entry := ledger.Entry{
+		Kind: ledger.KindRepayment,
+		Meta: map[string]string{"loan_id": ln.ID},
+		Legs: []ledger.Leg{{Account: wallet, Side: ledger.Debit, Amount: amt}},
+	}
+	if principal.IsPositive() {
+		entry.Legs = append(entry.Legs, ledger.Leg{Account: ledger.LoanReceivable(ln.ID), Side: ledger.Credit, Amount: principal})
+	}
+	if interest.IsPositive() {
+		entry.Legs = append(entry.Legs, ledger.Leg{Account: ledger.InterestIncome, Side: ledger.Credit, Amount: interest})
+	}
+	if _, err := books.Post(entry); err != nil {
+		ln.Installments, ln.Status = before, status
+		if err := loans.Save(ln); err != nil {
+			log.Printf("undo repayment on %s: %v", ln.ID, err)
+		}
+		if err == ledger.ErrInsufficientFunds {
+			return getText(s.Language, "insufficient_funds"), nil
+		}
+		return "", err
+	}
+	logLoanEvent(t, ln, bot.EventRepaid, eventDetail{
+		note: fmt.Sprintf("%s (principal %s, interest %s)", amt, principal, interest), key: "event_detail_repaid",
+		amounts: map[string]money.Money{"amount": amt, "principal": principal, "interest": interest},
+	})
+	bal, err := books.Balance(wallet)
+	if err != nil {
+		return "", err
+	}
+	if owed := repay.Owed(ln.Installments); owed.IsPositive() {
+		return getTextf(s.Language, "repay_success", i18n.Args{"amount": amt.Format(s.Language), "loan": ln.ID, "owed": owed.Format(s.Language), "balance": bal.Format(s.Language)}), nil
+	}
+	return getTextf(s.Language, "repay_done", i18n.Args{"loan": ln.ID, "balance": bal.Format(s.Language)}), nil
+}
+
+// createLoan stores a new loan; the store assigns its unique ID
+func createLoan(name, appid, region string, amount money.Money, submittedBy string) (*bot.Loan, error) {
+	ln := &bot.Loan{
+		ApplicantName:   name,
+		ApplicantID:     appid,
+		Region:          region,
+		RequestedAmount: amount,
+		Status:          bot.Submitted,
+		ElderApprovals:  map[string]bool{},
+		ApprovalReasons: map[string]string{},
+		ApproverNames:   map[string]string{},
+		Recommendations: []string{},
+		ApprovedLimit:   money.Zero(money.USD),
+		TermMonths:      0,
+		Borrowed:        money.Zero(money.USD),
+		SubmittedBy:     submittedBy,
 	}
 	// keep initial compute (none approved yet)
 	computeLoanLimits(ln)
-	loans[id] = ln
-	// log creation for debugging in global space (not user facing)
-	_ = submittedBy
-	return ln
-}
-
-// computeLoanLimits calculates ApprovedLimit and TermMonths based on approvals and recommendations
-func computeLoanLimits(loan *Loan) {
-	base := 0.0
-	term := 0
-	if loan.MufundisiApproved {
-		elderCount := countTrue(loan.ElderApprovals)
-		if elderCount >= 2 {
-			base = 800
-			term = 9
-		} else if elderCount == 1 {
-			base = 500
-			term = 6
-		} else {
-			base = 300
-			term = 6
+	if err := loans.Create(ln); err != nil {
+		return nil, err
+	}
+	return ln, nil
+}
+
+// computeLoanLimits sets ApprovedLimit and TermMonths from the approvals and
+// recommendations, by the loan policy in force, then underwrites the limit
+// against the applicant and keeps the decision on the loan
+func computeLoanLimits(loan *bot.Loan) {
+	p, err := limits.Current()
+	if err != nil {
+		log.Printf("loan policy: %v; still using version %s", err, p.Version)
+	}
+	res := p.Evaluate(loanApprovals(loan))
+	d := underwrite.Decide(p.Rules(loan.Region), res, underwritingFacts(loan), time.Now())
+	loan.ApprovedLimit = d.Limit
+	loan.TermMonths = d.TermMonths
+	loan.PolicyVersion = d.PolicyVersion
+	loan.Underwriting = &d
+}
+
+// loanQuorum is the approval quorum for loan's region under the policy in
+// force
+func loanQuorum(loan *bot.Loan) policy.Quorum {
+	p, err := limits.Current()
+	if err != nil {
+		log.Printf("loan policy: %v; still using version %s", err, p.Version)
+	}
+	return p.Rules(loan.Region).Quorum
+}
+
+// underwritingFacts gathers what the underwriting rules check about loan's
+// applicant: the filer's own wallet activity, and how the other loans they
+// filed stand. Loans are the applicant's by the WhatsApp ID that filed them,
+// as with ownsLoan, never by the name typed on them.
+func underwritingFacts(loan *bot.Loan) underwrite.Facts {
+	f := underwrite.Facts{Requested: loan.RequestedAmount, Exposure: money.Zero(money.USD)}
+	if loan.SubmittedBy != "" {
+		history, err := books.History(ledger.Wallet(loan.SubmittedBy))
+		if err != nil {
+			log.Printf("wallet history for %s: %v", loan.ID, err)
+		}
+		for _, e := range history {
+			switch e.Kind {
+			case ledger.KindTransfer, ledger.KindAirtime, ledger.KindRepayment:
+				f.Activity++
+			}
 		}
-	} else {
-		base = 0
-		term = 0
 	}
-	// count unique recommendations, max 2
-	recCount := 0
+	now := time.Now()
+	for _, l := range listLoans() {
+		if l.ID == loan.ID || !ownsLoan(loan.SubmittedBy, l) {
+			continue
+		}
+		if repay.Arrears(l.Installments, now).IsPositive() {
+			f.InArrears = append(f.InArrears, l.ID)
+		}
+		owed := repay.Owed(l.Installments)
+		if len(l.Installments) > 0 && !owed.IsPositive() {
+			f.Repaid++
+		}
+		f.Exposure = f.Exposure.Add(owed)
+		if l.Drawable() {
+			if undrawn := l.ApprovedLimit.Sub(l.Borrowed); undrawn.IsPositive() {
+				f.Exposure = f.Exposure.Add(undrawn)
+			}
+		}
+	}
+	return f
+}
+
+// loanApprovals summarizes what loan has gathered for the limit policy,
+// counting each recommender once
+func loanApprovals(loan *bot.Loan) policy.Approvals {
 	seen := map[string]bool{}
 	for _, r := range loan.Recommendations {
-		n := strings.ToLower(strings.TrimSpace(r))
-		if n == "" {
+		if n := strings.ToLower(strings.TrimSpace(r)); n != "" {
+			seen[n] = true
+		}
+	}
+	return policy.Approvals{
+		Region:          loan.Region,
+		Mufundisi:       loan.MufundisiApproved,
+		Elders:          countTrue(loan.ElderApprovals),
+		Recommendations: len(seen),
+	}
+}
+
+// eventDetail is what an audit event says beyond its action: note in
+// English, and key with its arguments for the catalogs. The zero value says
+// nothing more.
+type eventDetail struct {
+	note    string
+	key     string
+	amounts map[string]money.Money
+	texts   map[string]string
+}
+
+func reasonDetail(reason string) eventDetail {
+	return eventDetail{note: reason, key: "event_detail_reason", texts: map[string]string{"reason": reason}}
+}
+
+// logLoanEvent appends an action on loan to its audit log, with the role and
+// region the registry gives the actor rather than the ones they switched to
+// in chat. The action has already happened by now, so a failed append is
+// logged rather than undone.
+func logLoanEvent(t *turn, loan *bot.Loan, action bot.EventAction, d eventDetail) {
+	role, region := "member", ""
+	if m, err := stores.Members.Get(t.from); err == nil {
+		role, region = m.Role, m.Region
+	} else if err != bot.ErrNotFound {
+		log.Printf("audit %s on %s: registry: %v", action, loan.ID, err)
+	}
+	e := &bot.LoanEvent{
+		LoanID:    loan.ID,
+		Action:    action,
+		Actor:     t.from,
+		ActorName: t.s.Name,
+		Role:      role,
+		Region:    region,
+		Channel:   t.channel,
+		At:        time.Now(),
+		Detail:    d.note,
+		Key:       d.key,
+		Amounts:   d.amounts,
+		Texts:     d.texts,
+		Status:    loan.State(),
+	}
+	if err := events.Append(e); err != nil {
+		log.Printf("audit %s on %s: %v", action, loan.ID, err)
+	}
+}
+
+// notifyApplicant tells whoever filed loan about it, in their language. As
+// with the audit log, a notification that can't be queued is only logged.
+// Money and localized arguments are rendered for the recipient.
+func notifyApplicant(loan *bot.Loan, key string, args i18n.Args) {
+	if loan.SubmittedBy == "" {
+		return
+	}
+	notifyMember(loan.SubmittedBy, loan.ID, key, args)
+}
+
+// notifyApprovers tells the approvers of the loan's region that it is
+// waiting for them, leaving out anyone the registry wouldn't let act on it.
+func notifyApprovers(t *turn, loan *bot.Loan) {
+	members, err := stores.Members.List()
+	if err != nil {
+		log.Printf("notify approvers of %s: %v", loan.ID, err)
+		return
+	}
+	for _, m := range members {
+		if m.From == t.from {
 			continue
 		}
-		if !seen[n] {
-			seen[n] = true
-			recCount++
-			if recCount >= 2 {
-				break
-			}
+		if _, err := access.Check(m.From, m.Name, bot.ApproveLoan, loan); err != nil {
+			continue
 		}
+		notifyMember(m.From, loan.ID, "notify_submitted", i18n.Args{"loan": loan.ID, "applicant": loan.ApplicantName, "amount": loan.RequestedAmount, "region": localizedRegion(loan.Region)})
 	}
-	additional := float64(recCount * 100)
-	total := base + additional
-	if total > 1000 {
-		total = 1000
+}
+
+// localized is a notification argument that depends on the recipient's
+// language, such as a region's name.
+type localized func(language string) string
+
+func localizedRegion(region string) localized {
+	return func(language string) string { return regionName(language, region) }
+}
+
+func notifyMember(to, loanID, key string, args i18n.Args) {
+	language := "en"
+	if p, err := profiles.Get(to); err == nil {
+		language = p.Language
 	}
-	loan.ApprovedLimit = total
-	loan.TermMonths = term
-	// if mufundisi approved at least, status can be approved (we still allow elders to increase limit)
-	if loan.MufundisiApproved {
-		loan.Status = "approved"
+	formatted := i18n.Args{}
+	for name, a := range args {
+		switch v := a.(type) {
+		case money.Money:
+			a = v.Format(language)
+		case localized:
+			a = v(language)
+		}
+		formatted[name] = a
+	}
+	if err := notifier.Enqueue(to, language, loanID, getTextf(language, key, formatted)); err != nil {
+		log.Printf("notify %s of %s: %v", to, key, err)
 	}
 }
 
-// viewLoansForApplicant returns readable loans for the caller
-func viewLoansForApplicant(name string) string {
-	loanMu.Lock()
-	defer loanMu.Unlock()
+// showLoanHistory shows the audit log of one of the user's loans. Events
+// logged before they carried a catalog key show their English detail.
+func showLoanHistory(t *turn, input string) (flow.Transition, error) {
+	s := t.s
+	lid := strings.ToUpper(strings.TrimSpace(input))
+	if lid == "BACK" || lid == "0" {
+		return flow.Go(stLoanMenu), nil
+	}
+	loan, err := loans.Get(lid)
+	if err == bot.ErrNotFound || (err == nil && !ownsLoan(t.from, loan)) {
+		return flow.Stay(getText(s.Language, "history_unknown")), nil
+	} else if err != nil {
+		return flow.Transition{}, err
+	}
+	history, err := events.ForLoan(lid)
+	if err != nil {
+		return flow.Transition{}, err
+	}
+	out := getTextf(s.Language, "history_title", i18n.Args{"loan": lid})
+	for _, e := range history {
+		args := i18n.Args{
+			"at": formatTime(s.Language, e.At), "action": eventName(s.Language, e.Action), "actor": e.ActorName,
+			"role": roleName(s.Language, e.Role), "status": statusName(s.Language, e.Status),
+		}
+		if e.Key != "" {
+			args["detail"] = getTextf(s.Language, e.Key, localArgs(s.Language, nil, e.Amounts, e.Texts))
+			out += getTextf(s.Language, "history_line_detail", args)
+		} else if e.Detail != "" {
+			args["detail"] = e.Detail
+			out += getTextf(s.Language, "history_line_detail", args)
+		} else {
+			out += getTextf(s.Language, "history_line", args)
+		}
+	}
+	return flow.Stay(out + getText(s.Language, "history_footer")), nil
+}
+
+// viewLoansForApplicant returns readable loans filed by from
+func viewLoansForApplicant(from string, s *bot.Session) string {
 	out := ""
 	found := false
-	for _, l := range loans {
-		if strings.EqualFold(l.ApplicantName, name) {
+	for _, l := range listLoans() {
+		if ownsLoan(from, l) {
 			found = true
-			out += fmt.Sprintf("ID: %s\nApplicant: %s\nRegion: %s\nRequested: $%.2f\nStatus: %s\nApproved Limit: $%.2f\nTerm: %d months\nRecommendations: %d\nApprovals: Mufundisi: %v, Elders: %d\nBorrowed: $%.2f\nDecline reason: %s\n\n",
-				l.ID, l.ApplicantName, l.Region, l.RequestedAmount, l.Status, l.ApprovedLimit, l.TermMonths, len(l.Recommendations), l.MufundisiApproved, countTrue(l.ElderApprovals), l.Borrowed, l.DeclineReason)
+			out += getTextf(s.Language, "status_loan", i18n.Args{
+				"loan": l.ID, "applicant": l.ApplicantName, "region": regionName(s.Language, l.Region),
+				"amount": l.RequestedAmount.Format(s.Language), "status": statusName(s.Language, l.State()),
+				"limit": l.ApprovedLimit.Format(s.Language), "months": l.TermMonths, "policy": l.PolicyVersion,
+				"recs": len(l.Recommendations), "mufundisi": l.MufundisiApproved, "elders": countTrue(l.ElderApprovals),
+				"borrowed": l.Borrowed.Format(s.Language), "reason": l.DeclineReason,
+			})
+			if len(l.Installments) > 0 {
+				out += getTextf(s.Language, "status_repayment", i18n.Args{
+					"principal": repay.OutstandingPrincipal(l.Installments).Format(s.Language),
+					"arrears":   repay.Arrears(l.Installments, time.Now()).Format(s.Language),
+				})
+				if next, ok := repay.NextDue(l.Installments); ok {
+					out += getTextf(s.Language, "status_next_due", i18n.Args{"amount": next.Owed().Format(s.Language), "date": formatDate(s.Language, next.Due)})
+				}
+			}
+			out += "\n"
 		}
 	}
 	if !found {
-		return "No loan applications found for you.\n\nTo request a loan: Loan Menu -> 1"
+		return getText(s.Language, "status_none")
 	}
 	return out
 }
 
+// openWallet credits the demo opening balance to a wallet that has never had
+// a posting, so ending a session and starting over doesn't mint new money
+func openWallet(from string) error {
+	wallet := ledger.Wallet(from)
+	history, err := books.History(wallet)
+	if err != nil || len(history) > 0 {
+		return err
+	}
+	_, err = books.Post(ledger.Transfer(ledger.KindOpening, ledger.Promotions, wallet, openingBalance, nil))
+	return err
+}
+
+// resolveRecipient finds the session a send-money recipient refers to, given
+// either a WhatsApp number (local or international form) or a registered
+// @handle. Unknown recipients yield bot.ErrNotFound.
+func resolveRecipient(input string) (string, *bot.Profile, error) {
+	to := ""
+	if id, ok := bot.WhatsAppID(input); ok {
+		to = id
+	} else if handle, ok := bot.NormalizeHandle(input); ok {
+		var err error
+		if to, err = profiles.FindHandle(handle); err != nil {
+			return "", nil, err
+		}
+	} else {
+		return "", nil, bot.ErrNotFound
+	}
+	rp, err := profiles.Get(to)
+	if err != nil {
+		return "", nil, err
+	}
+	return to, rp, nil
+}
+
+// displayName is how a wallet owner is shown to others: their name, else
+// their handle, else their number
+func displayName(from string, p *bot.Profile) string {
+	switch {
+	case p.Name != "":
+		return p.Name
+	case p.Handle != "":
+		return "@" + p.Handle
+	}
+	return strings.TrimPrefix(from, "whatsapp:")
+}
+
+// transactionText renders a ledger entry as a history line for one wallet in
+// the given language; entries without a user-facing line render as ""
+func transactionText(language string, e ledger.Entry, wallet string) string {
+	net := e.Net(wallet)
+	amt := net.Format(language)
+	if net.IsNegative() {
+		amt = net.Neg().Format(language)
+	}
+	switch e.Kind {
+	case ledger.KindTransfer:
+		if net.IsPositive() {
+			return getTextf(language, "received_from", i18n.Args{"amount": amt, "sender": e.Meta["from"]})
+		}
+		return getTextf(language, "sent_to", i18n.Args{"amount": amt, "recipient": e.Meta["to"]})
+	case ledger.KindAirtime:
+		return getTextf(language, "bought_airtime", i18n.Args{"amount": amt})
+	case ledger.KindDisbursement:
+		return getTextf(language, "loan_disbursed", i18n.Args{"amount": amt, "loan": e.Meta["loan_id"]})
+	case ledger.KindRepayment:
+		return getTextf(language, "loan_repaid", i18n.Args{"amount": amt, "loan": e.Meta["loan_id"]})
+	case ledger.KindAdjustment:
+		if net.IsPositive() {
+			return getTextf(language, "adjusted_in", i18n.Args{"amount": amt})
+		}
+		return getTextf(language, "adjusted_out", i18n.Args{"amount": amt})
+	}
+	return ""
+}
+
+// listLoans returns every stored loan; a store failure is logged and shows
+// up as an empty list
+func listLoans() []*bot.Loan {
+	ls, err := loans.List()
+	if err != nil {
+		log.Printf("list loans: %v", err)
+	}
+	return ls
+}
+
 func countTrue(m map[string]bool) int {
 	c := 0
 	for _, v := range m {
@@ -1028,34 +2089,31 @@ func countTrue(m map[string]bool) int {
 	return c
 }
 
-// approverListPrompt lists pending loans in approver's region
-func approverListPrompt(s *Session) string {
-	loanMu.Lock()
-	defer loanMu.Unlock()
-	out := "Pending loans in your region:\n\n"
+// approverListPrompt lists the loans in approver's region that the registry
+// lets them act on: undecided ones, and approved ones that further approvals
+// can still raise
+func approverListPrompt(from string, s *bot.Session) string {
+	out := getText(s.Language, "approver_title")
 	count := 0
-	for _, l := range loans {
-		if l.Status == "pending" && strings.EqualFold(l.Region, s.Region) {
-			out += fmt.Sprintf("ID: %s | Applicant: %s | Requested: $%.2f\n", l.ID, l.ApplicantName, l.RequestedAmount)
+	for _, l := range listLoans() {
+		if (l.Open() || l.Drawable()) && strings.EqualFold(l.Region, s.Region) && mayAct(from, s, bot.ApproveLoan, l) {
+			out += getTextf(s.Language, "approver_line", i18n.Args{"loan": l.ID, "applicant": l.ApplicantName, "amount": l.RequestedAmount.Format(s.Language), "status": statusName(s.Language, l.State())})
 			count++
 		}
 	}
 	if count == 0 {
-		out = "No pending loans in your region.\n\nType 0 to go back."
+		out = getText(s.Language, "approver_none")
 	}
-	out += "\n\nType the Loan ID to act on (or 'back')."
+	out += getText(s.Language, "approver_footer")
 	return out
 }
 
-// recommendListPrompt lists loans in same region that can be recommended
-func recommendListPrompt(s *Session) string {
-	loanMu.Lock()
-	defer loanMu.Unlock()
-
+// recommendListPrompt lists loans in same region that the user may recommend
+func recommendListPrompt(from string, s *bot.Session) string {
 	// Filter only loans in same region that are pending
-	var filtered []*Loan
-	for _, l := range loans {
-		if l.Region == s.Region && l.Status == "pending" {
+	var filtered []*bot.Loan
+	for _, l := range listLoans() {
+		if l.Region == s.Region && l.Open() && mayAct(from, s, bot.RecommendLoan, l) {
 			filtered = append(filtered, l)
 		}
 	}
@@ -1070,28 +2128,179 @@ func recommendListPrompt(s *Session) string {
 	for i, l := range filtered {
 		index := fmt.Sprintf("%d", i+1)
 		s.TempLoanList[index] = l.ID
-		out += fmt.Sprintf("%s️⃣ %s | Region: %s | Status: %s | Recs: %d\n",
-			index, l.ApplicantName, l.Region, l.Status, len(l.Recommendations))
+		out += getTextf(s.Language, "recommend_line", i18n.Args{
+			"number": index, "applicant": l.ApplicantName, "region": regionName(s.Language, l.Region),
+			"status": statusName(s.Language, l.State()), "recs": len(l.Recommendations),
+		})
+	}
+	out += getTextf(s.Language, "recommend_footer", i18n.Args{"count": len(filtered)})
+	return out
+}
+
+// mayAct reports whether the registry lets from take act on loan; a registry
+// failure is logged and counts as no
+func mayAct(from string, s *bot.Session, act bot.Action, loan *bot.Loan) bool {
+	_, err := access.Check(from, s.Name, act, loan)
+	if err != nil && err != bot.ErrForbidden && err != bot.ErrConflict {
+		log.Printf("check %s on %s: %v", act, loan.ID, err)
+	}
+	return err == nil
+}
+
+// repayListPrompt lists the user's loans with money still owed
+func repayListPrompt(from string, s *bot.Session) string {
+	out := getText(s.Language, "repay_title")
+	count := 0
+	for _, l := range listLoans() {
+		if !repayable(from, l) {
+			continue
+		}
+		next, _ := repay.NextDue(l.Installments)
+		out += getTextf(s.Language, "repay_line", i18n.Args{
+			"loan": l.ID, "owed": repay.Owed(l.Installments).Format(s.Language),
+			"due": next.Owed().Format(s.Language), "date": formatDate(s.Language, next.Due),
+		})
+		count++
+	}
+	if count == 0 {
+		return getText(s.Language, "repay_none")
+	}
+	return out + getText(s.Language, "repay_footer")
+}
+
+// formatDate renders a due date with the month named in language
+func formatDate(language string, t time.Time) string {
+	return getTextf(language, "date", i18n.Args{"day": t.Day(), "month": monthName(language, t.Month()), "year": t.Year()})
+}
+
+// formatTime renders when something happened, to the minute
+func formatTime(language string, t time.Time) string {
+	return getTextf(language, "date_time", i18n.Args{"day": t.Day(), "month": monthName(language, t.Month()), "year": t.Year(), "time": t.Format("15:04")})
+}
+
+// monthName is month as named in language's "months" text, a
+// space-separated list of all twelve
+func monthName(language string, month time.Month) string {
+	names := strings.Fields(getText(language, "months"))
+	if len(names) != 12 {
+		return month.String()[:3]
+	}
+	return names[month-1]
+}
+
+// Catalog keys naming loan statuses, roles, regions, audit log actions and
+// underwriting rules. Values without a key are shown as stored.
+var (
+	statusKeys = map[bot.LoanStatus]string{
+		bot.Submitted:   "status_submitted",
+		bot.UnderReview: "status_under_review",
+		bot.Approved:    "status_approved",
+		bot.Declined:    "status_declined",
+		bot.Disbursed:   "status_disbursed",
+		bot.Closed:      "status_closed",
+	}
+	roleKeys = map[string]string{
+		"member":      "role_member",
+		"mufundisi":   "role_mufundisi",
+		"elder":       "role_elder",
+		"recommender": "role_recommender",
+	}
+	regionKeys = map[string]string{
+		"tabhera": "region_tabhera",
+		"nyika":   "region_nyika",
+	}
+	eventKeys = map[bot.EventAction]string{
+		bot.EventSubmitted:      "event_submitted",
+		bot.EventRecommended:    "event_recommended",
+		bot.EventNotRecommended: "event_not_recommended",
+		bot.EventApproved:       "event_approved",
+		bot.EventDeclined:       "event_declined",
+		bot.EventDisbursed:      "event_disbursed",
+		bot.EventRepaid:         "event_repaid",
+	}
+	ruleKeys = map[string]string{
+		underwrite.RulePolicy:      "rule_policy",
+		underwrite.RuleRequested:   "rule_requested",
+		underwrite.RuleWallet:      "rule_wallet_history",
+		underwrite.RulePerformance: "rule_loan_performance",
+		underwrite.RuleExposure:    "rule_exposure",
+	}
+)
+
+func statusName(language string, status bot.LoanStatus) string {
+	if key, ok := statusKeys[status]; ok {
+		return getText(language, key)
+	}
+	return string(status)
+}
+
+func roleName(language, role string) string {
+	if key, ok := roleKeys[role]; ok {
+		return getText(language, key)
+	}
+	return role
+}
+
+func regionName(language, region string) string {
+	if key, ok := regionKeys[strings.ToLower(region)]; ok {
+		return getText(language, key)
+	}
+	return region
+}
+
+// underwritingText lists how d reached its limit, a rule per line. Decisions
+// recorded before steps carried a catalog key show their English note.
+func underwritingText(language string, d *underwrite.Decision) string {
+	out := ""
+	for _, st := range d.Steps {
+		rule, note := st.Rule, st.Note
+		if key, ok := ruleKeys[st.Rule]; ok {
+			rule = getText(language, key)
+		}
+		if st.Key != "" {
+			note = getTextf(language, st.Key, localArgs(language, st.Counts, st.Amounts, st.Texts))
+		}
+		out += getTextf(language, "underwrite_step", i18n.Args{"rule": rule, "note": note, "limit": st.Limit.Format(language)})
 	}
-	out += getTextf(s.Language, "recommend_footer", len(filtered))
 	return out
 }
 
+// localArgs gathers the structured arguments of a stored message, with the
+// amounts formatted for language.
+func localArgs(language string, counts map[string]int, amounts map[string]money.Money, texts map[string]string) i18n.Args {
+	args := i18n.Args{}
+	for name, n := range counts {
+		args[name] = n
+	}
+	for name, m := range amounts {
+		args[name] = m.Format(language)
+	}
+	for name, t := range texts {
+		args[name] = t
+	}
+	return args
+}
+
+func eventName(language string, action bot.EventAction) string {
+	if key, ok := eventKeys[action]; ok {
+		return getText(language, key)
+	}
+	return string(action)
+}
+
 // borrowListPrompt lists approved loans for this session's user
-func borrowListPrompt(s *Session) string {
-	loanMu.Lock()
-	defer loanMu.Unlock()
-	out := "Your approved loans:\n\n"
+func borrowListPrompt(from string, s *bot.Session) string {
+	out := getText(s.Language, "borrow_title")
 	count := 0
-	for _, l := range loans {
-		if strings.EqualFold(l.ApplicantName, s.Name) && l.Status == "approved" {
-			out += fmt.Sprintf("ID: %s | Limit: $%.2f | Borrowed: $%.2f | Available: $%.2f\n", l.ID, l.ApprovedLimit, l.Borrowed, l.ApprovedLimit-l.Borrowed)
+	for _, l := range listLoans() {
+		if ownsLoan(from, l) && l.Drawable() {
+			out += getTextf(s.Language, "borrow_line", i18n.Args{"loan": l.ID, "limit": l.ApprovedLimit.Format(s.Language), "borrowed": l.Borrowed.Format(s.Language), "available": l.ApprovedLimit.Sub(l.Borrowed).Format(s.Language)})
 			count++
 		}
 	}
 	if count == 0 {
-		out = "You have no approved loans to borrow from.\n\nType 0 to go back."
+		out = getText(s.Language, "borrow_none")
 	}
-	out += "\n\nType Loan ID to borrow or 'back'."
+	out += getText(s.Language, "borrow_footer")
 	return out
 }
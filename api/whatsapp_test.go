@@ -0,0 +1,370 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xetkloset/demo/auth"
+	"github.com/xetkloset/demo/bot"
+	"github.com/xetkloset/demo/flow"
+	"github.com/xetkloset/demo/i18n"
+	"github.com/xetkloset/demo/ledger"
+	"github.com/xetkloset/demo/money"
+	"github.com/xetkloset/demo/notify"
+	"github.com/xetkloset/demo/repay"
+	"github.com/xetkloset/demo/transport"
+)
+
+// useMemoryStores gives the test empty in-memory stores and a Fake sender
+// for notifications, and puts the package's own back after it.
+func useMemoryStores(t *testing.T) *notify.Fake {
+	t.Helper()
+	savedStores, savedNotifier, savedAccess := stores, notifier, access
+	t.Cleanup(func() {
+		stores, notifier, access = savedStores, savedNotifier, savedAccess
+		profiles, conversations, loans = stores.Profiles, stores.Conversations, stores.Loans
+		events, books = stores.Events, ledger.New(stores.Journal, money.USD)
+	})
+	stores = &bot.Stores{
+		Profiles:      bot.NewMemoryProfileStore(),
+		Conversations: bot.NewMemoryConversationStore(),
+		Loans:         bot.NewMemoryLoanStore(),
+		Journal:       bot.NewMemoryJournal(),
+		Members:       bot.NewMemoryMemberStore(),
+		Events:        bot.NewMemoryEventStore(),
+		Outbox:        bot.NewMemoryOutbox(),
+		Replays:       transport.NewReplayGuard(bot.ReplayTTL),
+	}
+	profiles, conversations, loans = stores.Profiles, stores.Conversations, stores.Loans
+	events, books = stores.Events, ledger.New(stores.Journal, money.USD)
+	access = &bot.Access{Members: stores.Members, Admins: map[string]bool{}}
+	fake := &notify.Fake{}
+	notifier = notify.New(stores.Outbox, fake)
+	return fake
+}
+
+func usd(units int64) money.Money { return money.FromMajor(units, money.USD) }
+
+// Other loans count towards underwriting by the WhatsApp ID that filed them:
+// a second spelling of the same applicant's name doesn't hide their arrears,
+// and a namesake's record isn't theirs.
+func TestUnderwritingFactsByFiler(t *testing.T) {
+	useMemoryStores(t)
+	now := time.Now()
+	ann, namesake := "whatsapp:+263771111111", "whatsapp:+263772222222"
+	overdue := []repay.Installment{{Due: now.AddDate(0, -1, 0), Principal: usd(100), Interest: usd(0)}}
+	paid := []repay.Installment{{Due: now.AddDate(0, -2, 0), Principal: usd(100), Interest: usd(0), PaidPrincipal: usd(100), PaidInterest: usd(0)}}
+	for _, l := range []*bot.Loan{
+		{ApplicantName: "Ann Moyo", SubmittedBy: ann, Region: "Tabhera", Status: bot.Disbursed, Installments: overdue},
+		{ApplicantName: "Anne Moyo", SubmittedBy: ann, Region: "Tabhera", Status: bot.Approved, ApprovedLimit: usd(300), Borrowed: usd(0)},
+		{ApplicantName: "Ann Moyo", SubmittedBy: namesake, Region: "Tabhera", Status: bot.Closed, Installments: paid},
+	} {
+		if err := loans.Create(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name, by, applicant string
+		arrears             []string
+		repaid              int
+		exposure            money.Money
+	}{
+		{"new spelling", ann, "A. Moyo", []string{"L0001"}, 0, usd(400)},
+		{"same spelling", ann, "Ann Moyo", []string{"L0001"}, 0, usd(400)},
+		{"namesake", namesake, "Ann Moyo", nil, 1, usd(0)},
+		{"no filer", "", "Ann Moyo", nil, 0, usd(0)},
+	}
+	for _, tt := range tests {
+		f := underwritingFacts(&bot.Loan{ID: "L9999", ApplicantName: tt.applicant, SubmittedBy: tt.by, Region: "Tabhera"})
+		if len(f.InArrears) != len(tt.arrears) || (len(tt.arrears) > 0 && f.InArrears[0] != tt.arrears[0]) {
+			t.Errorf("%s: in arrears on %v, want %v", tt.name, f.InArrears, tt.arrears)
+		}
+		if f.Repaid != tt.repaid {
+			t.Errorf("%s: %d repaid, want %d", tt.name, f.Repaid, tt.repaid)
+		}
+		if f.Exposure != tt.exposure {
+			t.Errorf("%s: exposure %s, want %s", tt.name, f.Exposure, tt.exposure)
+		}
+	}
+}
+
+// The region picked for a loan is the loan's; the requester keeps acting in
+// the region they switched to.
+func TestLoanRequestRegion(t *testing.T) {
+	useMemoryStores(t)
+	from := "whatsapp:+263771111111"
+	s := &bot.Session{Profile: bot.Profile{Name: "Ruth", Role: "elder", Region: "Nyika", Language: "en"}}
+	s.PendingName, s.PendingApplicantID = "Ann Moyo", "63-123456A"
+	tr := &turn{from: from, channel: "whatsapp", s: s}
+	stage := stLoanRequestRegion
+	for _, input := range []string{"1", "50"} {
+		next, _, err := conversation.Step(tr, stage, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stage = next
+	}
+	ls, err := loans.List()
+	if err != nil || len(ls) != 1 {
+		t.Fatalf("loans %v, %v", ls, err)
+	}
+	if ls[0].Region != "Tabhera" {
+		t.Errorf("loan region %s, want Tabhera", ls[0].Region)
+	}
+	if s.Region != "Nyika" || s.Role != "elder" {
+		t.Errorf("requester now %s in %s, want elder in Nyika", s.Role, s.Region)
+	}
+}
+
+// Audit events record the actor's registry role and region, and read back in
+// the reader's language.
+func TestLoanHistory(t *testing.T) {
+	useMemoryStores(t)
+	applicant, elder := "whatsapp:+263771111111", "whatsapp:+263772222222"
+	if err := stores.Members.Save(&bot.Member{From: elder, Name: "Ruth", Role: "elder", Region: "Tabhera"}); err != nil {
+		t.Fatal(err)
+	}
+	loan := &bot.Loan{ApplicantName: "Ann Moyo", SubmittedBy: applicant, Region: "Tabhera", Status: bot.Submitted}
+	if err := loans.Create(loan); err != nil {
+		t.Fatal(err)
+	}
+	// the elder has switched to member in chat, somewhere else
+	asElder := &turn{from: elder, channel: "whatsapp", s: &bot.Session{Profile: bot.Profile{Name: "Ruth", Role: "member", Region: "Nyika"}}}
+	logLoanEvent(asElder, loan, bot.EventDeclined, reasonDetail("no guarantor"))
+	asApplicant := &turn{from: applicant, channel: "whatsapp", s: &bot.Session{Profile: bot.Profile{Name: "Ann", Role: "elder", Region: "Nyika"}}}
+	logLoanEvent(asApplicant, loan, bot.EventSubmitted, eventDetail{
+		note: "requested " + usd(50).String(), key: "event_detail_requested", amounts: map[string]money.Money{"amount": usd(50)},
+	})
+
+	history, err := events.ForLoan(loan.ID)
+	if err != nil || len(history) != 2 {
+		t.Fatalf("events %v, %v", history, err)
+	}
+	if e := history[0]; e.Role != "elder" || e.Region != "Tabhera" {
+		t.Errorf("elder logged as %s in %q", e.Role, e.Region)
+	}
+	if e := history[1]; e.Role != "member" || e.Region != "" {
+		t.Errorf("unregistered applicant logged as %s in %q", e.Role, e.Region)
+	}
+	if d := history[1].Detail; d != "requested "+usd(50).String() {
+		t.Errorf("detail %q", d)
+	}
+
+	for _, language := range []string{"en", "sn", "nd"} {
+		asApplicant.s.Language = language
+		tr, err := showLoanHistory(asApplicant, loan.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			getTextf(language, "event_detail_reason", i18n.Args{"reason": "no guarantor"}),
+			getTextf(language, "event_detail_requested", i18n.Args{"amount": usd(50).Format(language)}),
+			roleName(language, "elder"),
+		} {
+			if !strings.Contains(tr.Reply, want) {
+				t.Errorf("%s history %q lacks %q", language, tr.Reply, want)
+			}
+		}
+	}
+}
+
+// waitSent waits for the notifier to have sent n messages, delivering any it
+// left behind.
+func waitSent(t *testing.T, fake *notify.Fake, n int) []notify.Message {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if sent := fake.Sent(); len(sent) >= n {
+			return sent
+		}
+		if _, err := notifier.Deliver(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Fatalf("sent %v, want %d messages", fake.Sent(), n)
+	return nil
+}
+
+// Approvers are told of a new loan in their own language, region included.
+func TestNotifyApprovers(t *testing.T) {
+	fake := useMemoryStores(t)
+	applicant := "whatsapp:+263771111111"
+	approvers := map[string]string{"whatsapp:+263772222222": "sn", "whatsapp:+263773333333": "nd"}
+	for from, language := range approvers {
+		if err := stores.Members.Save(&bot.Member{From: from, Name: language, Role: "elder", Region: "Tabhera"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := profiles.Save(from, &bot.Profile{Name: language, Language: language}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// loans filed before regions came from a menu may hold them as typed
+	loan, err := createLoan("Ann Moyo", "63-123456A", "tabhera", usd(50), applicant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifyApprovers(&turn{from: applicant, channel: "whatsapp", s: &bot.Session{}}, loan)
+
+	sent := waitSent(t, fake, len(approvers))
+	if len(sent) != len(approvers) {
+		t.Fatalf("sent %v", sent)
+	}
+	for _, m := range sent {
+		language := approvers[m.To]
+		want := getTextf(language, "notify_submitted", i18n.Args{
+			"loan": loan.ID, "applicant": "Ann Moyo", "amount": usd(50).Format(language), "region": regionName(language, "Tabhera"),
+		})
+		if m.Reply.Text != want || m.Reply.Language != language {
+			t.Errorf("%s got %q in %s, want %q", m.To, m.Reply.Text, m.Reply.Language, want)
+		}
+	}
+}
+
+// Yes and no are the exact words of the user's language, or English, or the
+// numbers on the menu.
+func TestConfirmWords(t *testing.T) {
+	useMemoryStores(t)
+	tests := []struct {
+		language, input string
+		at, want        flow.StageID
+	}{
+		{"en", "yes", stConfirmSend, stConfirmPIN},
+		{"en", "1", stConfirmSend, stConfirmPIN},
+		{"en", "no", stConfirmSend, stPostAction},
+		{"en", "2", stConfirmSend, stPostAction},
+		{"en", "yesterday", stConfirmSend, stConfirmSend},
+		{"en", "✅", stConfirmSend, stConfirmSend},
+		{"sn", "hongu", stConfirmSend, stConfirmPIN},
+		{"sn", "Hongu", stConfirmSend, stConfirmPIN},
+		{"sn", "yes", stConfirmSend, stConfirmPIN},
+		{"sn", "kwete", stConfirmSend, stPostAction},
+		{"sn", "yebo", stConfirmSend, stConfirmSend},
+		{"nd", "yebo", stConfirmSend, stConfirmPIN},
+		{"nd", "hatshi", stConfirmSend, stPostAction},
+		{"nd", "0", stConfirmSend, stPostAction},
+		{"nd", "hongu", stConfirmSend, stConfirmSend},
+
+		{"en", "no", stPostAction, stAskPIN},
+		{"en", "0", stPostAction, stAskPIN},
+		{"en", "1", stPostAction, stMainMenu},
+		{"en", "not yet", stPostAction, stPostAction},
+		{"en", "know", stPostAction, stPostAction},
+		{"sn", "kwete", stPostAction, stAskPIN},
+		{"sn", "hatshi", stPostAction, stPostAction},
+		{"nd", "hatshi", stPostAction, stAskPIN},
+		{"nd", "nothing", stPostAction, stPostAction},
+	}
+	for _, tt := range tests {
+		s := &bot.Session{Profile: bot.Profile{Name: "Ann", Language: tt.language}}
+		s.PendingAmt, s.PendingName = usd(5), "Tendai"
+		next, _, err := conversation.Step(&turn{from: "whatsapp:+263771111111", channel: "whatsapp", s: s}, tt.at, tt.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next != tt.want {
+			t.Errorf("%s %q at %s: went to %s, want %s", tt.language, tt.input, tt.at, next, tt.want)
+		}
+	}
+}
+
+// member stores a signed-up profile for from and returns it.
+func member(t *testing.T, from, language string) *bot.Profile {
+	t.Helper()
+	pin, err := auth.Enroll("2580")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &bot.Profile{Name: "Ann", PIN: pin, Role: "member", Region: "Tabhera", Language: language, LanguageSet: true}
+	if err := profiles.Save(from, p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// A member coming back to a transfer left at the confirmation is told it
+// timed out and asked for their PIN; the transfer isn't made.
+func TestExpiredConversationResumes(t *testing.T) {
+	useMemoryStores(t)
+	from, to := "whatsapp:+263771111111", "whatsapp:+263772222222"
+	member(t, from, "sn")
+	now := time.Now()
+	left := &bot.Conversation{Stage: stConfirmSend, PendingTo: to, PendingName: "Tendai", PendingAmt: usd(5),
+		StartedAt: now.Add(-10 * time.Minute), LastSeen: now.Add(-6 * time.Minute)}
+	if err := conversations.Save(from, left); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := converse(transport.Message{From: from, Body: "1", Channel: transport.ChannelWhatsApp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := getText("sn", "session_expired") + "\n\n" + getText("sn", "welcome_back"); !strings.HasPrefix(reply.Text, want) {
+		t.Errorf("reply %q, want it to start %q", reply.Text, want)
+	}
+	c, err := conversations.Get(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Stage != stVerifyPIN || c.PendingTo != "" || c.TimedOut {
+		t.Errorf("conversation now %+v", c)
+	}
+	if bal, err := books.Balance(ledger.Wallet(to)); err != nil || bal.IsPositive() {
+		t.Errorf("recipient has %s, %v", bal, err)
+	}
+
+	reply, err = converse(transport.Message{From: from, Body: "0000", Channel: transport.ChannelWhatsApp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(reply.Text, getText("sn", "session_expired")) {
+		t.Errorf("told twice: %q", reply.Text)
+	}
+}
+
+// The sweeper resets and drops conversations only: members keep their
+// profile, PIN and language, and are told of the timeout when they return.
+func TestSweepConversations(t *testing.T) {
+	useMemoryStores(t)
+	now := time.Now()
+	idle, active, stale := "whatsapp:+263771111111", "whatsapp:+263772222222", "whatsapp:+263773333333"
+	left := map[string]*bot.Conversation{
+		idle:   {Stage: stMainMenu, StartedAt: now.Add(-time.Hour), LastSeen: now.Add(-20 * time.Minute)},
+		active: {Stage: stMainMenu, StartedAt: now.Add(-time.Hour), LastSeen: now.Add(-time.Minute)},
+		stale:  {Stage: stAskPIN, LastSeen: now.Add(-8 * 24 * time.Hour), TimedOut: true},
+	}
+	saved := map[string]*bot.Profile{}
+	for from, c := range left {
+		saved[from] = member(t, from, "nd")
+		if err := conversations.Save(from, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := sweepConversations(now); n != 2 || err != nil {
+		t.Fatalf("swept %d, %v; want 2", n, err)
+	}
+	if c, err := conversations.Get(idle); err != nil || c.Stage != stAskPIN || !c.TimedOut {
+		t.Errorf("idle conversation %+v, %v", c, err)
+	}
+	if c, err := conversations.Get(active); err != nil || c.Stage != stMainMenu {
+		t.Errorf("active conversation %+v, %v", c, err)
+	}
+	if _, err := conversations.Get(stale); err != bot.ErrNotFound {
+		t.Errorf("stale conversation kept: %v", err)
+	}
+	for from, want := range saved {
+		p, err := profiles.Get(from)
+		if err != nil || p.Name != want.Name || p.PIN != want.PIN || p.Language != "nd" || !p.LanguageSet {
+			t.Errorf("%s: profile %+v, %v", from, p, err)
+		}
+	}
+
+	reply, err := converse(transport.Message{From: idle, Body: "hi", Channel: transport.ChannelWhatsApp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(reply.Text, getText("nd", "session_expired")) {
+		t.Errorf("reply %q doesn't say the session timed out", reply.Text)
+	}
+}
@@ -0,0 +1,154 @@
+// Package auth handles wallet PINs: enrollment as a salted hash, verification
+// with an attempt counter, and a timed lockout after repeated failures.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	// MaxAttempts wrong PINs in a row lock the PIN for LockoutDuration.
+	MaxAttempts     = 3
+	LockoutDuration = 15 * time.Minute
+
+	pinLength  = 4
+	saltLength = 16
+	keyLength  = 32
+	iterations = 100_000
+)
+
+var (
+	ErrFormat   = errors.New("auth: PIN must be 4 digits")
+	ErrWrongPIN = errors.New("auth: wrong PIN")
+	ErrLocked   = errors.New("auth: too many wrong PINs, locked")
+)
+
+// PIN is an enrolled PIN. Only the salted PBKDF2-SHA256 hash is kept.
+type PIN struct {
+	Hash        string    `json:"hash,omitempty"`
+	Salt        string    `json:"salt,omitempty"`
+	Iterations  int       `json:"iterations,omitempty"`
+	Failures    int       `json:"failures,omitempty"`
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+}
+
+// ValidFormat reports whether pin is exactly four digits.
+func ValidFormat(pin string) bool {
+	if len(pin) != pinLength {
+		return false
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Enroll hashes pin with a fresh random salt.
+func Enroll(pin string) (PIN, error) {
+	if !ValidFormat(pin) {
+		return PIN{}, ErrFormat
+	}
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return PIN{}, err
+	}
+	return PIN{
+		Hash:       base64.StdEncoding.EncodeToString(pbkdf2([]byte(pin), salt, iterations)),
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Iterations: iterations,
+	}, nil
+}
+
+// Enrolled reports whether a PIN has been set.
+func (p PIN) Enrolled() bool {
+	return p.Hash != ""
+}
+
+// Verify checks pin against the hash. Every wrong PIN counts towards the
+// lockout; a correct one resets the count. While locked every attempt fails
+// with ErrLocked without being checked.
+func (p *PIN) Verify(pin string, now time.Time) error {
+	if p.Locked(now) {
+		return ErrLocked
+	}
+	if !p.LockedUntil.IsZero() {
+		p.LockedUntil = time.Time{}
+	}
+	if p.matches(pin) {
+		p.Failures = 0
+		return nil
+	}
+	p.Failures++
+	if p.Failures >= MaxAttempts {
+		p.Failures = 0
+		p.LockedUntil = now.Add(LockoutDuration)
+		return ErrLocked
+	}
+	return ErrWrongPIN
+}
+
+// Locked reports whether the PIN is locked out at now.
+func (p PIN) Locked(now time.Time) bool {
+	return now.Before(p.LockedUntil)
+}
+
+// Remaining is how many wrong PINs are left before a lockout.
+func (p PIN) Remaining() int {
+	return MaxAttempts - p.Failures
+}
+
+// LockedFor is how long the lockout still lasts at now.
+func (p PIN) LockedFor(now time.Time) time.Duration {
+	if !p.Locked(now) {
+		return 0
+	}
+	return p.LockedUntil.Sub(now)
+}
+
+func (p PIN) matches(pin string) bool {
+	if !p.Enrolled() || !ValidFormat(pin) {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(p.Hash)
+	if err != nil {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(p.Salt)
+	if err != nil {
+		return false
+	}
+	got := pbkdf2([]byte(pin), salt, p.Iterations)
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// pbkdf2 is PBKDF2 (RFC 8018) with HMAC-SHA256, producing keyLength bytes.
+func pbkdf2(password, salt []byte, iter int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var out []byte
+	for block := uint32(1); len(out) < keyLength; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:keyLength]
+}
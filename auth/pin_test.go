@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestValidFormat(t *testing.T) {
+	for pin, want := range map[string]bool{
+		"1234": true, "0000": true,
+		"": false, "123": false, "12345": false, "12a4": false, " 123": false, "١٢٣٤": false,
+	} {
+		if got := ValidFormat(pin); got != want {
+			t.Errorf("ValidFormat(%q) = %v, want %v", pin, got, want)
+		}
+	}
+}
+
+// RFC 7914, section 11: PBKDF2-HMAC-SHA256 of "passwd" and "salt", one
+// iteration, first keyLength bytes.
+func TestPBKDF2(t *testing.T) {
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"
+	if got := hex.EncodeToString(pbkdf2([]byte("passwd"), []byte("salt"), 1)); got != want {
+		t.Errorf("pbkdf2 = %s, want %s", got, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		attempts  []string      // PINs tried a minute apart
+		after     time.Duration // then waited this long past the last try
+		pin       string        // before trying this one
+		want      error
+		remaining int
+	}{
+		{name: "right", pin: "1234", want: nil, remaining: MaxAttempts},
+		{name: "wrong", pin: "9999", want: ErrWrongPIN, remaining: MaxAttempts - 1},
+		{name: "malformed counts", pin: "12", want: ErrWrongPIN, remaining: MaxAttempts - 1},
+		{name: "right resets the count", attempts: []string{"9999", "9999"}, pin: "1234", want: nil, remaining: MaxAttempts},
+		{name: "third wrong locks", attempts: []string{"9999", "9999"}, pin: "9999", want: ErrLocked, remaining: MaxAttempts},
+		{name: "locked refuses the right PIN", attempts: []string{"9999", "9999", "9999"}, after: LockoutDuration - time.Second, pin: "1234", want: ErrLocked, remaining: MaxAttempts},
+		{name: "lockout ends", attempts: []string{"9999", "9999", "9999"}, after: LockoutDuration, pin: "1234", want: nil, remaining: MaxAttempts},
+		{name: "fresh count after lockout", attempts: []string{"9999", "9999", "9999"}, after: LockoutDuration, pin: "9999", want: ErrWrongPIN, remaining: MaxAttempts - 1},
+	}
+	enrolled, err := Enroll("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, now := enrolled, start
+			for _, a := range tt.attempts {
+				p.Verify(a, now)
+				now = now.Add(time.Minute)
+			}
+			now = now.Add(tt.after - time.Minute)
+			if err := p.Verify(tt.pin, now); err != tt.want {
+				t.Errorf("Verify(%q) = %v, want %v", tt.pin, err, tt.want)
+			}
+			if got := p.Remaining(); got != tt.remaining {
+				t.Errorf("Remaining() = %d, want %d", got, tt.remaining)
+			}
+		})
+	}
+}
+
+func TestLockedFor(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	p, err := Enroll("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < MaxAttempts; i++ {
+		p.Verify("0000", now)
+	}
+	if got := p.LockedFor(now.Add(time.Minute)); got != LockoutDuration-time.Minute {
+		t.Errorf("LockedFor a minute in = %v, want %v", got, LockoutDuration-time.Minute)
+	}
+	if got := p.LockedFor(now.Add(LockoutDuration)); got != 0 {
+		t.Errorf("LockedFor once over = %v, want 0", got)
+	}
+}
+
+func TestEnrollRejectsMalformed(t *testing.T) {
+	if _, err := Enroll("12ab"); err != ErrFormat {
+		t.Errorf("Enroll(%q) = %v, want %v", "12ab", err, ErrFormat)
+	}
+}
@@ -0,0 +1,124 @@
+// Package bot holds the WalletBot domain model and its persistence.
+//
+// It lives outside api/ because every file there is deployed as its own
+// serverless function; anything the functions share has to be importable.
+package bot
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/xetkloset/demo/auth"
+	"github.com/xetkloset/demo/flow"
+	"github.com/xetkloset/demo/money"
+	"github.com/xetkloset/demo/repay"
+	"github.com/xetkloset/demo/underwrite"
+)
+
+// Profile is who a member is, kept for as long as they are a member. Their
+// money is in the ledger wallet under the same WhatsApp ID.
+type Profile struct {
+	Name     string
+	Handle   string   // optional @handle others can send money to, stored without the @
+	PIN      auth.PIN // enrolled once; only the salted hash is stored
+	Role     string   // "member", "mufundisi", "elder", "recommender" (we treat recommender as member with flag)
+	Region   string   // "Tabhera" or "Nyika"
+	Language string   // a catalog language code: "en" (English), "sn" (Shona), "nd" (Ndebele), ...
+	// LanguageSet is true once the member has picked Language or confirmed
+	// the one guessed from their first message.
+	LanguageSet bool
+}
+
+// Conversation is where a member is in the chat and what they have entered
+// on the way. It is thrown away when they sign out or it times out.
+type Conversation struct {
+	Stage              flow.StageID
+	PendingPIN         auth.PIN // PIN being enrolled, until it is entered a second time
+	PendingName        string
+	PendingTo          string // WhatsApp ID of the send-money recipient
+	PendingAmt         money.Money
+	PendingLoan        string // loan the current stage acts on, or being drawn on
+	PendingAction      string // "send", "airtime", "borrow" or "repay" waiting for PIN re-confirmation
+	PendingRole        string // role picked in the switch role menu, until its region is chosen
+	PendingApplicantID string
+	PendingRegion      string            // region picked for the loan being requested
+	TempLoanList       map[string]string // Maps numbers to loan IDs for recommendation selection
+	StartedAt          time.Time         // first message of the conversation
+	LastSeen           time.Time         // last message; see Expiry
+	TimedOut           bool              // the conversation was reset by Expiry and the member not told yet
+}
+
+// Session is what one message works on: the member's profile and their
+// conversation. It is stored as the two separately.
+type Session struct {
+	Profile
+	Conversation
+}
+
+// Loan model
+type Loan struct {
+	ID                string
+	ApplicantName     string
+	ApplicantID       string
+	Region            string
+	RequestedAmount   money.Money
+	Status            LoanStatus // see workflow.go; read it through State
+	MufundisiApproved bool
+	ElderApprovals    map[string]bool   // keyed by approver WhatsApp ID
+	ApprovalReasons   map[string]string // each approver's vote or reason, keyed by WhatsApp ID
+	ApproverNames     map[string]string // approver WhatsApp ID to name, for display only
+	Recommendations   []string          // recommender names
+	ApprovedLimit     money.Money
+	TermMonths        int
+	DeclineReason     string
+	Borrowed          money.Money
+	PolicyVersion     string               // version of the limit policy that set ApprovedLimit and TermMonths
+	Underwriting      *underwrite.Decision // how ApprovedLimit was reached, rule by rule
+	SubmittedBy       string               // WhatsApp ID of whoever filed the request
+	Terms             repay.Terms          // interest pricing, fixed at the first draw
+	Installments      []repay.Installment  // repayment schedule of every draw, in due order
+}
+
+// DefaultCountryCode is assumed for numbers written in local 0XX form.
+const DefaultCountryCode = "263"
+
+// WhatsAppID turns a phone number the way a user might type it
+// ("0772 123 456", "+263772123456") into the "whatsapp:+<digits>" key Twilio
+// sends as From.
+func WhatsAppID(number string) (string, bool) {
+	number = strings.TrimPrefix(strings.TrimSpace(number), "whatsapp:")
+	var digits strings.Builder
+	for i, r := range number {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0, r == ' ', r == '-':
+		default:
+			return "", false
+		}
+	}
+	d := digits.String()
+	if strings.HasPrefix(d, "0") {
+		d = DefaultCountryCode + strings.TrimPrefix(d, "0")
+	}
+	if len(d) < 8 || len(d) > 15 {
+		return "", false
+	}
+	return "whatsapp:+" + d, true
+}
+
+// NormalizeHandle strips a leading @ and lowercases h, reporting whether the
+// result is a valid handle: 3 to 20 letters, digits or underscores.
+func NormalizeHandle(h string) (string, bool) {
+	h = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(h), "@"))
+	if len(h) < 3 || len(h) > 20 {
+		return "", false
+	}
+	for _, r := range h {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return "", false
+		}
+	}
+	return h, true
+}
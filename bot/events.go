@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xetkloset/demo/money"
+)
+
+// EventAction is what happened to a loan.
+type EventAction string
+
+const (
+	EventSubmitted      EventAction = "submitted"
+	EventRecommended    EventAction = "recommended"
+	EventNotRecommended EventAction = "not_recommended"
+	EventApproved       EventAction = "approved"
+	EventDeclined       EventAction = "declined"
+	EventDisbursed      EventAction = "disbursed"
+	EventRepaid         EventAction = "repaid"
+)
+
+// LoanEvent is one entry in a loan's audit log. Detail gives the amount,
+// reason or similar in English, for logs and the admin API; Key names the
+// catalog message that gives it in the reader's language, filled in from
+// Amounts and Texts.
+type LoanEvent struct {
+	ID        string                 `json:"id"`
+	LoanID    string                 `json:"loan_id"`
+	Action    EventAction            `json:"action"`
+	Actor     string                 `json:"actor"` // WhatsApp ID of whoever acted
+	ActorName string                 `json:"actor_name"`
+	Role      string                 `json:"role"`    // the actor's registry role when they acted, "member" if none
+	Region    string                 `json:"region"`  // the actor's registry region when they acted
+	Channel   string                 `json:"channel"` // transport the action came in on, e.g. "whatsapp"
+	At        time.Time              `json:"at"`
+	Detail    string                 `json:"detail"`
+	Key       string                 `json:"key,omitempty"`
+	Amounts   map[string]money.Money `json:"amounts,omitempty"`
+	Texts     map[string]string      `json:"texts,omitempty"`
+	Status    LoanStatus             `json:"status"` // the loan's status after the event
+}
+
+func eventID(seq int) string {
+	return fmt.Sprintf("E%06d", seq)
+}
+
+// eventsFor picks loanID's events out of the whole log.
+func eventsFor(events []LoanEvent, loanID string) []LoanEvent {
+	var out []LoanEvent
+	for _, e := range events {
+		if e.LoanID == loanID {
+			out = append(out, e)
+		}
+	}
+	return out
+}
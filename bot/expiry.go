@@ -0,0 +1,32 @@
+package bot
+
+import (
+	"time"
+
+	"github.com/xetkloset/demo/flow"
+)
+
+// Expiry decides when a conversation has been left too long to carry on
+// from where it stopped. Only the conversation is reset then; who the member
+// is, their PIN and their wallet are in the Profile and ledger and are kept.
+type Expiry struct {
+	Idle      time.Duration                  // longest gap between messages; 0 for no limit
+	StageIdle map[flow.StageID]time.Duration // shorter or longer gaps for particular stages
+	Lifetime  time.Duration                  // longest conversation from its first message; 0 for no limit
+}
+
+// Expired reports whether c has timed out at now. Conversations saved before
+// expiry was tracked never have.
+func (e Expiry) Expired(c *Conversation, now time.Time) bool {
+	if c.LastSeen.IsZero() {
+		return false
+	}
+	idle := e.Idle
+	if d, ok := e.StageIdle[c.Stage]; ok {
+		idle = d
+	}
+	if idle > 0 && now.Sub(c.LastSeen) > idle {
+		return true
+	}
+	return e.Lifetime > 0 && !c.StartedAt.IsZero() && now.Sub(c.StartedAt) > e.Lifetime
+}
@@ -0,0 +1,47 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xetkloset/demo/flow"
+)
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	e := Expiry{
+		Idle:      15 * time.Minute,
+		StageIdle: map[flow.StageID]time.Duration{"confirm_send": 5 * time.Minute, "long_form": time.Hour},
+		Lifetime:  12 * time.Hour,
+	}
+	conv := func(stage flow.StageID, started, idle time.Duration) *Conversation {
+		c := &Conversation{Stage: stage, LastSeen: now.Add(-idle)}
+		if started > 0 {
+			c.StartedAt = now.Add(-started)
+		}
+		return c
+	}
+	tests := []struct {
+		name string
+		e    Expiry
+		c    *Conversation
+		want bool
+	}{
+		{"never seen", e, &Conversation{Stage: "main_menu"}, false},
+		{"idle at the limit", e, conv("main_menu", time.Hour, 15*time.Minute), false},
+		{"idle past the limit", e, conv("main_menu", time.Hour, 15*time.Minute+time.Nanosecond), true},
+		{"stage limit", e, conv("confirm_send", time.Hour, 5*time.Minute), false},
+		{"past the stage limit", e, conv("confirm_send", time.Hour, 5*time.Minute+time.Nanosecond), true},
+		{"longer stage limit", e, conv("long_form", time.Hour, 30*time.Minute), false},
+		{"no idle limit", Expiry{Lifetime: 12 * time.Hour}, conv("main_menu", time.Hour, 11*time.Hour), false},
+		{"lifetime at the limit", e, conv("main_menu", 12*time.Hour, time.Minute), false},
+		{"lifetime past the limit", e, conv("main_menu", 12*time.Hour+time.Nanosecond, time.Minute), true},
+		{"no start recorded", e, conv("main_menu", 0, time.Minute), false},
+		{"no limits", Expiry{}, conv("main_menu", 365*24*time.Hour, 365*24*time.Hour), false},
+	}
+	for _, tt := range tests {
+		if got := tt.e.Expired(tt.c, now); got != tt.want {
+			t.Errorf("%s: Expired = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
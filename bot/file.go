@@ -0,0 +1,546 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xetkloset/demo/ledger"
+)
+
+// jsonFile is a JSON document on disk. Every read decodes the file afresh and
+// every change rewrites it through a temp file and rename, so a crash never
+// leaves a half-written document behind. A change holds an exclusive lock on
+// a .lock file beside the document from read to rename, so processes sharing
+// the directory (serverless instances, say) never interleave their changes.
+type jsonFile[T any] struct {
+	mu   sync.Mutex
+	path string
+}
+
+// read decodes the document, returning the zero value if it doesn't exist yet.
+// Callers hold f.mu.
+func (f *jsonFile[T]) read() (T, error) {
+	var v T
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return v, nil
+	}
+	if err != nil {
+		return v, err
+	}
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+func (f *jsonFile[T]) write(v T) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func (f *jsonFile[T]) view(fn func(v T) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, err := f.read()
+	if err != nil {
+		return err
+	}
+	return fn(v)
+}
+
+func (f *jsonFile[T]) update(fn func(v *T) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	unlock, err := lockFile(f.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	v, err := f.read()
+	if err != nil {
+		return err
+	}
+	if err := fn(&v); err != nil {
+		return err
+	}
+	return f.write(v)
+}
+
+// errUnchanged aborts an update that has nothing to write.
+var errUnchanged = errors.New("unchanged")
+
+// FileProfileStore keeps all profiles in profiles.json.
+type FileProfileStore struct {
+	file jsonFile[map[string]*Profile]
+}
+
+// OpenFileProfileStore opens (creating if needed) the profile file in dir.
+func OpenFileProfileStore(dir string) (*FileProfileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	st := &FileProfileStore{file: jsonFile[map[string]*Profile]{path: filepath.Join(dir, "profiles.json")}}
+	// fail at startup rather than on the first message if the file is corrupt
+	if err := st.file.view(func(map[string]*Profile) error { return nil }); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (st *FileProfileStore) Get(from string) (*Profile, error) {
+	var p *Profile
+	err := st.file.view(func(m map[string]*Profile) error {
+		var ok bool
+		if p, ok = m[from]; !ok {
+			return ErrNotFound
+		}
+		return nil
+	})
+	return p, err
+}
+
+func (st *FileProfileStore) FindHandle(handle string) (string, error) {
+	var from string
+	err := st.file.view(func(m map[string]*Profile) error {
+		var err error
+		from, err = findHandle(m, handle)
+		return err
+	})
+	return from, err
+}
+
+func (st *FileProfileStore) Save(from string, p *Profile) error {
+	return st.file.update(func(m *map[string]*Profile) error {
+		if *m == nil {
+			*m = make(map[string]*Profile)
+		}
+		(*m)[from] = p
+		return nil
+	})
+}
+
+// FileConversationStore keeps the conversations in progress in
+// conversations.json.
+type FileConversationStore struct {
+	file jsonFile[map[string]*Conversation]
+}
+
+// OpenFileConversationStore opens (creating if needed) the conversation file
+// in dir.
+func OpenFileConversationStore(dir string) (*FileConversationStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	st := &FileConversationStore{file: jsonFile[map[string]*Conversation]{path: filepath.Join(dir, "conversations.json")}}
+	if err := st.file.view(func(map[string]*Conversation) error { return nil }); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (st *FileConversationStore) Get(from string) (*Conversation, error) {
+	var c *Conversation
+	err := st.file.view(func(m map[string]*Conversation) error {
+		var ok bool
+		if c, ok = m[from]; !ok {
+			return ErrNotFound
+		}
+		return nil
+	})
+	return c, err
+}
+
+func (st *FileConversationStore) Save(from string, c *Conversation) error {
+	return st.file.update(func(m *map[string]*Conversation) error {
+		if *m == nil {
+			*m = make(map[string]*Conversation)
+		}
+		(*m)[from] = c
+		return nil
+	})
+}
+
+func (st *FileConversationStore) Delete(from string) error {
+	return st.file.update(func(m *map[string]*Conversation) error {
+		delete(*m, from)
+		return nil
+	})
+}
+
+func (st *FileConversationStore) Sweep(fn func(from string, c *Conversation) (*Conversation, bool)) (int, error) {
+	n := 0
+	err := st.file.update(func(m *map[string]*Conversation) error {
+		for from, c := range *m {
+			keep, changed := fn(from, c)
+			switch {
+			case keep == nil:
+				delete(*m, from)
+			case changed:
+				(*m)[from] = keep
+			default:
+				continue
+			}
+			n++
+		}
+		if n == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err == errUnchanged {
+		err = nil
+	}
+	return n, err
+}
+
+// splitSessions moves a sessions.json written before profiles and
+// conversations were kept apart into profiles.json and conversations.json,
+// keeping the old file as sessions.json.old. It does nothing once
+// profiles.json exists.
+func splitSessions(dir string) error {
+	old := filepath.Join(dir, "sessions.json")
+	if _, err := os.Stat(filepath.Join(dir, "profiles.json")); err == nil {
+		return nil
+	}
+	if _, err := os.Stat(old); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	sessions, err := (&jsonFile[map[string]*Session]{path: old}).read()
+	if err != nil {
+		return err
+	}
+	profiles := make(map[string]*Profile, len(sessions))
+	conversations := make(map[string]*Conversation, len(sessions))
+	for from, s := range sessions {
+		profiles[from] = &s.Profile
+		conversations[from] = &s.Conversation
+	}
+	if err := (&jsonFile[map[string]*Conversation]{path: filepath.Join(dir, "conversations.json")}).write(conversations); err != nil {
+		return err
+	}
+	// profiles.json last: its presence marks the split as done
+	if err := (&jsonFile[map[string]*Profile]{path: filepath.Join(dir, "profiles.json")}).write(profiles); err != nil {
+		return err
+	}
+	return os.Rename(old, old+".old")
+}
+
+// loanFile is the on-disk layout of loans.json. Counter is kept separately
+// from the loans so IDs stay unique even if loans are ever removed.
+type loanFile struct {
+	Counter int              `json:"counter"`
+	Loans   map[string]*Loan `json:"loans"`
+}
+
+// FileLoanStore keeps all loans and the loan ID counter in loans.json.
+type FileLoanStore struct {
+	file jsonFile[loanFile]
+}
+
+// OpenFileLoanStore opens (creating if needed) the loan file in dir.
+func OpenFileLoanStore(dir string) (*FileLoanStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	st := &FileLoanStore{file: jsonFile[loanFile]{path: filepath.Join(dir, "loans.json")}}
+	if err := st.file.view(func(loanFile) error { return nil }); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (st *FileLoanStore) Create(l *Loan) error {
+	return st.file.update(func(f *loanFile) error {
+		if f.Loans == nil {
+			f.Loans = make(map[string]*Loan)
+		}
+		f.Counter++
+		l.ID = loanID(f.Counter)
+		f.Loans[l.ID] = l
+		return nil
+	})
+}
+
+func (st *FileLoanStore) Get(id string) (*Loan, error) {
+	var l *Loan
+	err := st.file.view(func(f loanFile) error {
+		var ok bool
+		if l, ok = f.Loans[id]; !ok {
+			return ErrNotFound
+		}
+		return nil
+	})
+	return l, err
+}
+
+func (st *FileLoanStore) Save(l *Loan) error {
+	return st.file.update(func(f *loanFile) error {
+		if _, ok := f.Loans[l.ID]; !ok {
+			return ErrNotFound
+		}
+		f.Loans[l.ID] = l
+		return nil
+	})
+}
+
+func (st *FileLoanStore) List() ([]*Loan, error) {
+	var out []*Loan
+	err := st.file.view(func(f loanFile) error {
+		for _, l := range f.Loans {
+			out = append(out, l)
+		}
+		return nil
+	})
+	sortLoans(out)
+	return out, err
+}
+
+// FileJournal keeps the ledger journal in journal.json.
+type FileJournal struct {
+	file jsonFile[[]ledger.Entry]
+}
+
+// OpenFileJournal opens (creating if needed) the journal file in dir.
+func OpenFileJournal(dir string) (*FileJournal, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	j := &FileJournal{file: jsonFile[[]ledger.Entry]{path: filepath.Join(dir, "journal.json")}}
+	if err := j.file.view(func([]ledger.Entry) error { return nil }); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *FileJournal) Append(e *ledger.Entry, check func([]ledger.Entry) error) error {
+	return j.file.update(func(entries *[]ledger.Entry) error {
+		if err := check(*entries); err != nil {
+			return err
+		}
+		e.ID = entryID(len(*entries) + 1)
+		*entries = append(*entries, *e)
+		return nil
+	})
+}
+
+func (j *FileJournal) Entries() ([]ledger.Entry, error) {
+	var out []ledger.Entry
+	err := j.file.view(func(entries []ledger.Entry) error {
+		out = entries
+		return nil
+	})
+	return out, err
+}
+
+// FileMemberStore keeps the member registry in members.json.
+type FileMemberStore struct {
+	file jsonFile[map[string]*Member]
+}
+
+// OpenFileMemberStore opens (creating if needed) the member file in dir.
+func OpenFileMemberStore(dir string) (*FileMemberStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	st := &FileMemberStore{file: jsonFile[map[string]*Member]{path: filepath.Join(dir, "members.json")}}
+	if err := st.file.view(func(map[string]*Member) error { return nil }); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (st *FileMemberStore) Get(from string) (*Member, error) {
+	var mem *Member
+	err := st.file.view(func(m map[string]*Member) error {
+		var ok bool
+		if mem, ok = m[from]; !ok {
+			return ErrNotFound
+		}
+		return nil
+	})
+	return mem, err
+}
+
+func (st *FileMemberStore) Save(mem *Member) error {
+	return st.file.update(func(m *map[string]*Member) error {
+		if *m == nil {
+			*m = make(map[string]*Member)
+		}
+		(*m)[mem.From] = mem
+		return nil
+	})
+}
+
+func (st *FileMemberStore) Delete(from string) error {
+	return st.file.update(func(m *map[string]*Member) error {
+		if _, ok := (*m)[from]; !ok {
+			return ErrNotFound
+		}
+		delete(*m, from)
+		return nil
+	})
+}
+
+func (st *FileMemberStore) List() ([]*Member, error) {
+	var out []*Member
+	err := st.file.view(func(m map[string]*Member) error {
+		for _, mem := range m {
+			out = append(out, mem)
+		}
+		return nil
+	})
+	sortMembers(out)
+	return out, err
+}
+
+// FileEventStore keeps the loan audit log in events.json.
+type FileEventStore struct {
+	file jsonFile[[]LoanEvent]
+}
+
+// OpenFileEventStore opens (creating if needed) the event file in dir.
+func OpenFileEventStore(dir string) (*FileEventStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	st := &FileEventStore{file: jsonFile[[]LoanEvent]{path: filepath.Join(dir, "events.json")}}
+	if err := st.file.view(func([]LoanEvent) error { return nil }); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (st *FileEventStore) Append(e *LoanEvent) error {
+	return st.file.update(func(events *[]LoanEvent) error {
+		e.ID = eventID(len(*events) + 1)
+		*events = append(*events, *e)
+		return nil
+	})
+}
+
+func (st *FileEventStore) ForLoan(loanID string) ([]LoanEvent, error) {
+	var out []LoanEvent
+	err := st.file.view(func(events []LoanEvent) error {
+		out = eventsFor(events, loanID)
+		return nil
+	})
+	return out, err
+}
+
+// FileOutbox keeps the notification queue in outbox.json.
+type FileOutbox struct {
+	file jsonFile[[]Notification]
+}
+
+// OpenFileOutbox opens (creating if needed) the outbox file in dir.
+func OpenFileOutbox(dir string) (*FileOutbox, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	ob := &FileOutbox{file: jsonFile[[]Notification]{path: filepath.Join(dir, "outbox.json")}}
+	if err := ob.file.view(func([]Notification) error { return nil }); err != nil {
+		return nil, err
+	}
+	return ob, nil
+}
+
+func (ob *FileOutbox) Enqueue(n *Notification) error {
+	return ob.file.update(func(queue *[]Notification) error {
+		n.ID = notificationID(len(*queue) + 1)
+		*queue = append(*queue, *n)
+		return nil
+	})
+}
+
+func (ob *FileOutbox) Claim(now time.Time, lease time.Duration, max int) ([]Notification, error) {
+	var out []Notification
+	err := ob.file.update(func(queue *[]Notification) error {
+		out = claim(*queue, now, lease, max)
+		return nil
+	})
+	return out, err
+}
+
+func (ob *FileOutbox) Save(n *Notification) error {
+	return ob.file.update(func(queue *[]Notification) error {
+		return replaceNotification(*queue, n)
+	})
+}
+
+// FileReplayStore keeps the message IDs the webhooks have handled in
+// replays.json, each for ttl, so every instance sharing the directory
+// refuses a message any of them has handled.
+type FileReplayStore struct {
+	file jsonFile[map[string]time.Time]
+	ttl  time.Duration
+	now  func() time.Time
+}
+
+// OpenFileReplayStore opens (creating if needed) the replay file in dir.
+func OpenFileReplayStore(dir string, ttl time.Duration) (*FileReplayStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	st := &FileReplayStore{file: jsonFile[map[string]time.Time]{path: filepath.Join(dir, "replays.json")}, ttl: ttl, now: time.Now}
+	if err := st.file.view(func(map[string]time.Time) error { return nil }); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// First records id and reports whether it hadn't been seen within the TTL,
+// dropping the IDs that have expired. An empty id is never accepted.
+func (st *FileReplayStore) First(id string) (bool, error) {
+	if id == "" {
+		return false, nil
+	}
+	first := false
+	err := st.file.update(func(m *map[string]time.Time) error {
+		if *m == nil {
+			*m = make(map[string]time.Time)
+		}
+		now := st.now()
+		for k, t := range *m {
+			if now.Sub(t) > st.ttl {
+				delete(*m, k)
+			}
+		}
+		if _, dup := (*m)[id]; !dup {
+			(*m)[id], first = now, true
+		}
+		return nil
+	})
+	return first, err
+}
+
+func (st *FileReplayStore) Forget(id string) error {
+	return st.file.update(func(m *map[string]time.Time) error {
+		delete(*m, id)
+		return nil
+	})
+}
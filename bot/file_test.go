@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xetkloset/demo/ledger"
+	"github.com/xetkloset/demo/money"
+)
+
+// Two journals opened on one directory stand in for two serverless instances:
+// they share nothing but the files, so only the file lock keeps them from
+// both spending the same money or handing out the same loan ID.
+func TestFileStoresShareDirectory(t *testing.T) {
+	dir := t.TempDir()
+	var books [2]*ledger.Ledger
+	var stores [2]*FileLoanStore
+	for i := range books {
+		j, err := OpenFileJournal(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		books[i] = ledger.New(j, money.USD)
+		if stores[i], err = OpenFileLoanStore(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ann, bob := ledger.Wallet("ann"), ledger.Wallet("bob")
+	if _, err := books[0].Post(ledger.Transfer(ledger.KindOpening, ledger.Promotions, ann, money.New(1000, money.USD), nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	const tries = 20 // per ledger, of $1 each against a $10 balance
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	spent, ids := 0, map[string]bool{}
+	for i := range books {
+		for n := 0; n < tries; n++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, err := books[i].Post(ledger.Transfer(ledger.KindTransfer, ann, bob, money.New(100, money.USD), nil))
+				l := &Loan{}
+				if err := stores[i].Create(l); err != nil {
+					t.Error(err)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if err == nil {
+					spent++
+				}
+				ids[l.ID] = true
+			}(i)
+		}
+	}
+	wg.Wait()
+
+	if spent != 10 {
+		t.Errorf("%d transfers of $1 went through against $10", spent)
+	}
+	if bal, _ := books[1].Balance(ann); !bal.IsZero() {
+		t.Errorf("ann's balance = %v, want 0", bal)
+	}
+	if len(ids) != 2*tries {
+		t.Errorf("%d distinct loan IDs for %d loans", len(ids), 2*tries)
+	}
+}
+
+// A message ID handled by one instance is refused by every other instance on
+// the directory until it expires or is forgotten.
+func TestFileReplayStore(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	var instances [2]*FileReplayStore
+	for i := range instances {
+		st, err := OpenFileReplayStore(dir, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st.now = func() time.Time { return now }
+		instances[i] = st
+	}
+	a, b := instances[0], instances[1]
+	tests := []struct {
+		name   string
+		st     *FileReplayStore
+		id     string
+		after  time.Duration // clock moved on before the check
+		forget bool          // forget id before the check
+		want   bool
+	}{
+		{"first delivery", a, "SM1", 0, false, true},
+		{"replayed to the same instance", a, "SM1", 0, false, false},
+		{"replayed to another instance", b, "SM1", 0, false, false},
+		{"another message", b, "SM2", 0, false, true},
+		{"retried after a failure", b, "SM1", 0, true, true},
+		{"within the TTL", a, "SM1", time.Hour, false, false},
+		{"after the TTL", a, "SM2", time.Minute, false, true},
+		{"no ID", a, "", 0, false, false},
+	}
+	for _, tt := range tests {
+		now = now.Add(tt.after)
+		if tt.forget {
+			if err := a.Forget(tt.id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := tt.st.First(tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: First(%q) = %v, want %v", tt.name, tt.id, got, tt.want)
+		}
+	}
+}
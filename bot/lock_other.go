@@ -0,0 +1,10 @@
+//go:build !unix
+
+package bot
+
+// lockFile does nothing where flock isn't available: there only the
+// in-process mutex orders changes, so a data directory mustn't be shared
+// between processes.
+func lockFile(path string) (unlock func(), err error) {
+	return func() {}, nil
+}
@@ -0,0 +1,281 @@
+package bot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xetkloset/demo/ledger"
+)
+
+// MemoryProfileStore keeps profiles in a map for the life of the process.
+type MemoryProfileStore struct {
+	mu       sync.Mutex
+	profiles map[string]*Profile
+}
+
+func NewMemoryProfileStore() *MemoryProfileStore {
+	return &MemoryProfileStore{profiles: make(map[string]*Profile)}
+}
+
+func (m *MemoryProfileStore) Get(from string) (*Profile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.profiles[from]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return p, nil
+}
+
+func (m *MemoryProfileStore) FindHandle(handle string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return findHandle(m.profiles, handle)
+}
+
+func (m *MemoryProfileStore) Save(from string, p *Profile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.profiles[from] = p
+	return nil
+}
+
+// MemoryConversationStore keeps conversations in a map for the life of the
+// process.
+type MemoryConversationStore struct {
+	mu            sync.Mutex
+	conversations map[string]*Conversation
+}
+
+func NewMemoryConversationStore() *MemoryConversationStore {
+	return &MemoryConversationStore{conversations: make(map[string]*Conversation)}
+}
+
+func (m *MemoryConversationStore) Get(from string) (*Conversation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.conversations[from]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
+
+func (m *MemoryConversationStore) Save(from string, c *Conversation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conversations[from] = c
+	return nil
+}
+
+func (m *MemoryConversationStore) Delete(from string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.conversations, from)
+	return nil
+}
+
+// Sweep hands fn copies and stores what it returns rather than changing
+// conversations in place, since a message may be holding the old one.
+func (m *MemoryConversationStore) Sweep(fn func(from string, c *Conversation) (*Conversation, bool)) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for from, c := range m.conversations {
+		cp := *c
+		keep, changed := fn(from, &cp)
+		switch {
+		case keep == nil:
+			delete(m.conversations, from)
+		case changed:
+			m.conversations[from] = keep
+		default:
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+// MemoryLoanStore keeps loans in a map for the life of the process.
+type MemoryLoanStore struct {
+	mu      sync.Mutex
+	loans   map[string]*Loan
+	counter int
+}
+
+func NewMemoryLoanStore() *MemoryLoanStore {
+	return &MemoryLoanStore{loans: make(map[string]*Loan)}
+}
+
+func (m *MemoryLoanStore) Create(l *Loan) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counter++
+	l.ID = loanID(m.counter)
+	m.loans[l.ID] = l
+	return nil
+}
+
+func (m *MemoryLoanStore) Get(id string) (*Loan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.loans[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return l, nil
+}
+
+func (m *MemoryLoanStore) Save(l *Loan) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.loans[l.ID]; !ok {
+		return ErrNotFound
+	}
+	m.loans[l.ID] = l
+	return nil
+}
+
+func (m *MemoryLoanStore) List() ([]*Loan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]*Loan, 0, len(m.loans))
+	for _, l := range m.loans {
+		out = append(out, l)
+	}
+	sortLoans(out)
+	return out, nil
+}
+
+// MemoryJournal keeps ledger entries in a slice for the life of the process.
+type MemoryJournal struct {
+	mu      sync.Mutex
+	entries []ledger.Entry
+}
+
+func NewMemoryJournal() *MemoryJournal {
+	return &MemoryJournal{}
+}
+
+func (m *MemoryJournal) Append(e *ledger.Entry, check func([]ledger.Entry) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := check(m.entries); err != nil {
+		return err
+	}
+	e.ID = entryID(len(m.entries) + 1)
+	m.entries = append(m.entries, *e)
+	return nil
+}
+
+func (m *MemoryJournal) Entries() ([]ledger.Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ledger.Entry(nil), m.entries...), nil
+}
+
+// MemoryMemberStore keeps the member registry in a map for the life of the
+// process.
+type MemoryMemberStore struct {
+	mu      sync.Mutex
+	members map[string]*Member
+}
+
+func NewMemoryMemberStore() *MemoryMemberStore {
+	return &MemoryMemberStore{members: make(map[string]*Member)}
+}
+
+func (m *MemoryMemberStore) Get(from string) (*Member, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mem, ok := m.members[from]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return mem, nil
+}
+
+func (m *MemoryMemberStore) Save(mem *Member) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.members[mem.From] = mem
+	return nil
+}
+
+func (m *MemoryMemberStore) Delete(from string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.members[from]; !ok {
+		return ErrNotFound
+	}
+	delete(m.members, from)
+	return nil
+}
+
+func (m *MemoryMemberStore) List() ([]*Member, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]*Member, 0, len(m.members))
+	for _, mem := range m.members {
+		out = append(out, mem)
+	}
+	sortMembers(out)
+	return out, nil
+}
+
+// MemoryEventStore keeps the loan audit log in a slice for the life of the
+// process.
+type MemoryEventStore struct {
+	mu     sync.Mutex
+	events []LoanEvent
+}
+
+func NewMemoryEventStore() *MemoryEventStore {
+	return &MemoryEventStore{}
+}
+
+func (m *MemoryEventStore) Append(e *LoanEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.ID = eventID(len(m.events) + 1)
+	m.events = append(m.events, *e)
+	return nil
+}
+
+func (m *MemoryEventStore) ForLoan(loanID string) ([]LoanEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return eventsFor(m.events, loanID), nil
+}
+
+// MemoryOutbox keeps queued notifications in a slice for the life of the
+// process.
+type MemoryOutbox struct {
+	mu    sync.Mutex
+	queue []Notification
+}
+
+func NewMemoryOutbox() *MemoryOutbox {
+	return &MemoryOutbox{}
+}
+
+func (m *MemoryOutbox) Enqueue(n *Notification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n.ID = notificationID(len(m.queue) + 1)
+	m.queue = append(m.queue, *n)
+	return nil
+}
+
+func (m *MemoryOutbox) Claim(now time.Time, lease time.Duration, max int) ([]Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return claim(m.queue, now, lease, max), nil
+}
+
+func (m *MemoryOutbox) Save(n *Notification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return replaceNotification(m.queue, n)
+}
@@ -0,0 +1,65 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+)
+
+// NotificationStatus is where an outbound notification is in delivery.
+type NotificationStatus string
+
+const (
+	NotificationPending NotificationStatus = "pending" // waiting for its first or next attempt
+	NotificationSent    NotificationStatus = "sent"
+	NotificationFailed  NotificationStatus = "failed" // gave up after too many attempts
+)
+
+// Notification is a message to a member sent outside the conversation, such
+// as telling an applicant their loan was approved.
+type Notification struct {
+	ID        string             `json:"id"`
+	To        string             `json:"to"` // WhatsApp ID
+	Text      string             `json:"text"`
+	Language  string             `json:"language"`
+	LoanID    string             `json:"loan_id,omitempty"`
+	Status    NotificationStatus `json:"status"`
+	Attempts  int                `json:"attempts"`
+	NextAt    time.Time          `json:"next_at"` // when a pending notification is next tried
+	LastError string             `json:"last_error,omitempty"`
+	Created   time.Time          `json:"created"`
+	SentAt    *time.Time         `json:"sent_at,omitempty"`
+}
+
+func notificationID(seq int) string {
+	return fmt.Sprintf("N%06d", seq)
+}
+
+// claim picks up to max pending notifications due at now, oldest first, and
+// pushes their NextAt out by lease so no other dispatcher picks them up
+// while they are being sent.
+func claim(ns []Notification, now time.Time, lease time.Duration, max int) []Notification {
+	var out []Notification
+	for i := range ns {
+		if len(out) == max {
+			break
+		}
+		n := &ns[i]
+		if n.Status != NotificationPending || n.NextAt.After(now) {
+			continue
+		}
+		n.NextAt = now.Add(lease)
+		out = append(out, *n)
+	}
+	return out
+}
+
+// replaceNotification swaps n into ns by ID.
+func replaceNotification(ns []Notification, n *Notification) error {
+	for i := range ns {
+		if ns[i].ID == n.ID {
+			ns[i] = *n
+			return nil
+		}
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,176 @@
+package bot
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Regions the loan scheme runs in.
+var Regions = []string{"Tabhera", "Nyika"}
+
+// Roles a member can be registered with.
+var Roles = []string{"member", "mufundisi", "elder", "recommender"}
+
+// ValidRole reports whether role is one of Roles.
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Grant lets a user act in a role within a region; an empty Region covers
+// every region.
+type Grant struct {
+	Role   string
+	Region string
+}
+
+// Covers reports whether the grant allows role in region.
+func (g Grant) Covers(role, region string) bool {
+	return g.Role == role && (g.Region == "" || strings.EqualFold(g.Region, region))
+}
+
+// RoleAuthority decides who may take on which role. Every user may be a
+// member in any region; all other roles must be granted.
+type RoleAuthority interface {
+	Grants(from string) ([]Grant, error)
+}
+
+// Permits reports whether from may act as role in region under a.
+func Permits(a RoleAuthority, from, role, region string) (bool, error) {
+	if role == "member" {
+		return true, nil
+	}
+	grants, err := a.Grants(from)
+	if err != nil {
+		return false, err
+	}
+	for _, g := range grants {
+		if g.Covers(role, region) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Member is a registry entry: the church/community role a person holds and
+// the region they hold it in, as recorded by an administrator.
+type Member struct {
+	From      string // WhatsApp ID
+	Name      string
+	Role      string
+	Region    string
+	UpdatedBy string // WhatsApp ID of the administrator who last changed it, or "admin-api:<officer>"
+	UpdatedAt time.Time
+}
+
+// ErrForbidden and ErrConflict are returned by Access.Check.
+var (
+	ErrForbidden = errors.New("not permitted")
+	ErrConflict  = errors.New("conflict of interest")
+)
+
+// Action is a loan workflow step only some members may take.
+type Action string
+
+const (
+	ApproveLoan   Action = "approve"
+	RecommendLoan Action = "recommend"
+)
+
+// Access is the authorization layer over the member registry. It is the
+// RoleAuthority for role switching and decides who may act on which loan.
+// Administrators are configured outside the registry so they can't lock
+// themselves out of it.
+type Access struct {
+	Members MemberStore
+	Admins  map[string]bool
+}
+
+// AccessFromEnv builds Access over members with the administrators listed,
+// comma separated, in WALLETBOT_ADMINS. Numbers take any form WhatsAppID
+// accepts; unusable ones are reported.
+func AccessFromEnv(members MemberStore) (*Access, error) {
+	a := &Access{Members: members, Admins: map[string]bool{}}
+	for _, n := range strings.Split(os.Getenv("WALLETBOT_ADMINS"), ",") {
+		if n = strings.TrimSpace(n); n == "" {
+			continue
+		}
+		from, ok := WhatsAppID(n)
+		if !ok {
+			return nil, errors.New("WALLETBOT_ADMINS: bad number " + n)
+		}
+		a.Admins[from] = true
+	}
+	return a, nil
+}
+
+// IsAdmin reports whether from may manage the registry.
+func (a *Access) IsAdmin(from string) bool { return a.Admins[from] }
+
+// Grants returns the role the registry records for from.
+func (a *Access) Grants(from string) ([]Grant, error) {
+	m, err := a.Members.Get(from)
+	if err == ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return []Grant{{Role: m.Role, Region: m.Region}}, nil
+}
+
+// Check returns the registry entry allowing from, known in chat as name, to
+// take act on loan, or ErrForbidden when the registry doesn't give them the
+// role in the loan's region, or ErrConflict when they applied for or
+// submitted the loan. A nil loan only checks the role.
+func (a *Access) Check(from, name string, act Action, loan *Loan) (*Member, error) {
+	m, err := a.Members.Get(from)
+	if err == ErrNotFound {
+		return nil, ErrForbidden
+	} else if err != nil {
+		return nil, err
+	}
+	switch act {
+	case ApproveLoan:
+		if m.Role != "mufundisi" && m.Role != "elder" {
+			return nil, ErrForbidden
+		}
+	case RecommendLoan:
+		if m.Role != "recommender" {
+			return nil, ErrForbidden
+		}
+	default:
+		return nil, ErrForbidden
+	}
+	if loan == nil {
+		return m, nil
+	}
+	if !strings.EqualFold(m.Region, loan.Region) {
+		return nil, ErrForbidden
+	}
+	if loan.SubmittedBy == from || strings.EqualFold(loan.ApplicantName, name) ||
+		(m.Name != "" && strings.EqualFold(loan.ApplicantName, m.Name)) {
+		return nil, ErrConflict
+	}
+	return m, nil
+}
+
+// NormalizeRegion returns the canonical spelling of a region name.
+func NormalizeRegion(region string) (string, bool) {
+	for _, r := range Regions {
+		if strings.EqualFold(r, region) {
+			return r, true
+		}
+	}
+	return "", false
+}
+
+func sortMembers(ms []*Member) {
+	sort.Slice(ms, func(i, j int) bool { return ms[i].From < ms[j].From })
+}
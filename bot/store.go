@@ -0,0 +1,173 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/xetkloset/demo/ledger"
+	"github.com/xetkloset/demo/transport"
+)
+
+// ErrNotFound is returned by store lookups for unknown keys.
+var ErrNotFound = errors.New("not found")
+
+// ProfileStore keeps one Profile per WhatsApp ID. FindHandle returns the
+// WhatsApp ID of the profile that registered a handle.
+type ProfileStore interface {
+	Get(from string) (*Profile, error)
+	FindHandle(handle string) (string, error)
+	Save(from string, p *Profile) error
+}
+
+// ConversationStore keeps the conversation in progress per WhatsApp ID.
+// Sweep calls fn with a copy of every conversation; fn returns the
+// conversation to keep in its place, or nil to drop it, and whether that is
+// a change. Sweep returns how many conversations changed or were dropped.
+type ConversationStore interface {
+	Get(from string) (*Conversation, error)
+	Save(from string, c *Conversation) error
+	Delete(from string) error
+	Sweep(fn func(from string, c *Conversation) (keep *Conversation, changed bool)) (int, error)
+}
+
+// LoanStore keeps loan applications. Create assigns the loan its ID from a
+// counter that only ever increases, so IDs are never reused.
+type LoanStore interface {
+	Create(l *Loan) error
+	Get(id string) (*Loan, error)
+	Save(l *Loan) error
+	List() ([]*Loan, error)
+}
+
+// MemberStore is the member registry, keyed by WhatsApp ID. List is sorted
+// by WhatsApp ID.
+type MemberStore interface {
+	Get(from string) (*Member, error)
+	Save(m *Member) error
+	Delete(from string) error
+	List() ([]*Member, error)
+}
+
+// EventStore is the append-only audit log of loan events. Append assigns the
+// event its ID; nothing is ever changed or removed. ForLoan returns a loan's
+// events oldest first.
+type EventStore interface {
+	Append(e *LoanEvent) error
+	ForLoan(loanID string) ([]LoanEvent, error)
+}
+
+// OutboxStore queues notifications for delivery. Enqueue assigns the
+// notification its ID. Claim returns up to max pending notifications that
+// are due, oldest first, and defers them by lease so a concurrent dispatcher
+// skips them; Save records the outcome of an attempt.
+type OutboxStore interface {
+	Enqueue(n *Notification) error
+	Claim(now time.Time, lease time.Duration, max int) ([]Notification, error)
+	Save(n *Notification) error
+}
+
+// ReplayTTL is how long the message IDs the webhooks have handled are kept
+// (see transport.Replays).
+const ReplayTTL = 24 * time.Hour
+
+// Stores groups the stores one deployment shares.
+type Stores struct {
+	Profiles      ProfileStore
+	Conversations ConversationStore
+	Loans         LoanStore
+	Journal       ledger.Journal
+	Members       MemberStore
+	Events        EventStore
+	Outbox        OutboxStore
+	Replays       transport.Replays
+}
+
+// OpenStores picks the store implementation at startup. When
+// WALLETBOT_DATA_DIR is set, profiles, conversations, loans, the ledger
+// journal, the member registry, the loan audit log, the notification outbox
+// and the message IDs the webhooks have handled are kept as JSON files in
+// that directory; otherwise they live in memory and are lost on cold start. A sessions.json from before profiles
+// and conversations were kept apart is split into the two first.
+func OpenStores() (*Stores, error) {
+	dir := os.Getenv("WALLETBOT_DATA_DIR")
+	if dir == "" {
+		return &Stores{
+			Profiles:      NewMemoryProfileStore(),
+			Conversations: NewMemoryConversationStore(),
+			Loans:         NewMemoryLoanStore(),
+			Journal:       NewMemoryJournal(),
+			Members:       NewMemoryMemberStore(),
+			Events:        NewMemoryEventStore(),
+			Outbox:        NewMemoryOutbox(),
+			Replays:       transport.NewReplayGuard(ReplayTTL),
+		}, nil
+	}
+	if err := splitSessions(dir); err != nil {
+		return nil, err
+	}
+	ps, err := OpenFileProfileStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := OpenFileConversationStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	ls, err := OpenFileLoanStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	j, err := OpenFileJournal(dir)
+	if err != nil {
+		return nil, err
+	}
+	ms, err := OpenFileMemberStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	es, err := OpenFileEventStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	ob, err := OpenFileOutbox(dir)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := OpenFileReplayStore(dir, ReplayTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &Stores{Profiles: ps, Conversations: cs, Loans: ls, Journal: j, Members: ms, Events: es, Outbox: ob, Replays: rs}, nil
+}
+
+func findHandle(profiles map[string]*Profile, handle string) (string, error) {
+	for from, p := range profiles {
+		if handle != "" && p.Handle == handle {
+			return from, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+func loanID(seq int) string {
+	return fmt.Sprintf("L%04d", seq)
+}
+
+func entryID(seq int) string {
+	return fmt.Sprintf("J%06d", seq)
+}
+
+// sortLoans orders loans by ID; IDs grow in width past L9999 so compare
+// length first.
+func sortLoans(ls []*Loan) {
+	sort.Slice(ls, func(i, j int) bool {
+		a, b := ls[i].ID, ls[j].ID
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+}
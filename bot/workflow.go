@@ -0,0 +1,168 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xetkloset/demo/policy"
+)
+
+// LoanStatus is where a loan is in its life.
+type LoanStatus string
+
+const (
+	Submitted   LoanStatus = "submitted"    // filed, nobody has looked at it yet
+	UnderReview LoanStatus = "under_review" // recommended or voted on, no decision yet
+	Approved    LoanStatus = "approved"     // the quorum approved; nothing drawn yet
+	Declined    LoanStatus = "declined"     // vetoed or declined by enough elders
+	Disbursed   LoanStatus = "disbursed"    // money has been drawn
+	Closed      LoanStatus = "closed"       // everything drawn has been repaid
+)
+
+// legacyPending is the status loans were filed with before the workflow.
+const legacyPending LoanStatus = "pending"
+
+var (
+	// ErrTransition is returned for a move the workflow doesn't allow.
+	ErrTransition = errors.New("bot: loan status change not allowed")
+	// ErrDecided is returned when a decided loan would be reopened.
+	ErrDecided = errors.New("bot: loan already decided")
+)
+
+// transitions lists where each status may move to. Declined and Closed are
+// final.
+var transitions = map[LoanStatus][]LoanStatus{
+	Submitted:   {UnderReview, Approved, Declined},
+	UnderReview: {Approved, Declined},
+	Approved:    {Disbursed},
+	Disbursed:   {Closed},
+}
+
+// State is the loan's status, reading loans filed as "pending" before the
+// workflow existed as submitted or under review.
+func (l *Loan) State() LoanStatus {
+	if l.Status != legacyPending {
+		return l.Status
+	}
+	if l.MufundisiApproved || len(l.ApprovalReasons) > 0 || len(l.Recommendations) > 0 {
+		return UnderReview
+	}
+	return Submitted
+}
+
+// MoveTo changes the loan's status, if the workflow allows it.
+func (l *Loan) MoveTo(to LoanStatus) error {
+	from := l.State()
+	for _, ok := range transitions[from] {
+		if ok == to {
+			l.Status = to
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s to %s", ErrTransition, from, to)
+}
+
+// Open reports whether the loan is still waiting for a decision.
+func (l *Loan) Open() bool {
+	st := l.State()
+	return st == Submitted || st == UnderReview
+}
+
+// Drawable reports whether money may be drawn on the loan.
+func (l *Loan) Drawable() bool {
+	st := l.State()
+	return st == Approved || st == Disbursed
+}
+
+// Recommend adds name's recommendation. Only undecided loans take
+// recommendations.
+func (l *Loan) Recommend(name string) error {
+	if !l.Open() {
+		return ErrDecided
+	}
+	l.Recommendations = append(l.Recommendations, name)
+	return l.review()
+}
+
+// Vote records the approval or decline of the approver with WhatsApp ID from
+// and decides the loan once q is met or a decline is final. Votes are kept by
+// from, so approvers sharing a name each count and one who renames
+// themselves still has one vote; name is only kept to show who voted. A
+// decided loan can't be declined any more; an approved one still takes
+// approvals, which may raise its limit.
+func (l *Loan) Vote(q policy.Quorum, from, name, role string, approve bool, reason string) error {
+	if !l.Open() && !(approve && l.Drawable()) {
+		return ErrDecided
+	}
+	if l.ElderApprovals == nil {
+		l.ElderApprovals = map[string]bool{}
+	}
+	if approve {
+		l.NoteApprover(from, name, "approved")
+	} else {
+		l.NoteApprover(from, name, "declined: "+reason)
+	}
+	switch role {
+	case "mufundisi":
+		l.MufundisiApproved = approve
+	case "elder":
+		l.ElderApprovals[from] = approve
+	}
+	if !l.Open() {
+		return nil
+	}
+	switch {
+	case !approve && q.Vetoes(role):
+		l.DeclineReason = reason
+		return l.MoveTo(Declined)
+	case !approve && q.ElderDeclines > 0 && l.elderDeclines() >= q.ElderDeclines:
+		l.DeclineReason = reason
+		return l.MoveTo(Declined)
+	case q.Met(l.MufundisiApproved, countApprovals(l.ElderApprovals)):
+		return l.MoveTo(Approved)
+	}
+	return l.review()
+}
+
+// NoteApprover records what the approver or recommender with WhatsApp ID
+// from, known as name, said about the loan.
+func (l *Loan) NoteApprover(from, name, reason string) {
+	if l.ApprovalReasons == nil {
+		l.ApprovalReasons = map[string]string{}
+	}
+	if l.ApproverNames == nil {
+		l.ApproverNames = map[string]string{}
+	}
+	l.ApprovalReasons[from] = reason
+	l.ApproverNames[from] = name
+}
+
+// review marks a submitted loan as under review.
+func (l *Loan) review() error {
+	if l.State() == Submitted {
+		return l.MoveTo(UnderReview)
+	}
+	l.Status = l.State()
+	return nil
+}
+
+func (l *Loan) elderDeclines() int {
+	n := 0
+	for from, ok := range l.ElderApprovals {
+		if !ok && strings.HasPrefix(l.ApprovalReasons[from], "declined") {
+			n++
+		}
+	}
+	return n
+}
+
+func countApprovals(m map[string]bool) int {
+	n := 0
+	for _, ok := range m {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
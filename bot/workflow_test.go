@@ -0,0 +1,96 @@
+package bot
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/xetkloset/demo/policy"
+)
+
+type vote struct {
+	from, name, role string
+	approve          bool
+}
+
+// Approvers' WhatsApp IDs; otherRuth shares Ruth's name.
+const (
+	ruth      = "whatsapp:+263771000001"
+	tendai    = "whatsapp:+263771000002"
+	pastor    = "whatsapp:+263771000003"
+	otherRuth = "whatsapp:+263771000004"
+)
+
+func elder(from, name string, approve bool) vote { return vote{from, name, "elder", approve} }
+func mufundisi(approve bool) vote                { return vote{pastor, "Pastor", "mufundisi", approve} }
+
+func TestVote(t *testing.T) {
+	builtin := policy.Builtin.Default.Quorum
+	twoElders := policy.Quorum{Elders: 2, ElderDeclines: 1}
+	tests := []struct {
+		name  string
+		q     policy.Quorum
+		votes []vote
+		want  LoanStatus
+	}{
+		{"first vote opens review", builtin, []vote{elder(ruth, "Ruth", true)}, UnderReview},
+		{"mufundisi meets quorum", builtin, []vote{mufundisi(true)}, Approved},
+		{"mufundisi vetoes", builtin, []vote{elder(ruth, "Ruth", true), mufundisi(false)}, Declined},
+		{"one elder decline is not enough", builtin, []vote{elder(ruth, "Ruth", false)}, UnderReview},
+		{"two elder declines decline", builtin, []vote{elder(ruth, "Ruth", false), elder(tendai, "Tendai", false)}, Declined},
+		{"same elder twice counts once", builtin, []vote{elder(ruth, "Ruth", false), elder(ruth, "Ruth", false)}, UnderReview},
+		{"elder changes to approve", builtin, []vote{elder(ruth, "Ruth", false), elder(ruth, "Ruth", true), elder(tendai, "Tendai", false)}, UnderReview},
+		{"elders short of quorum", twoElders, []vote{elder(ruth, "Ruth", true)}, UnderReview},
+		{"elders meet quorum", twoElders, []vote{elder(ruth, "Ruth", true), elder(tendai, "Tendai", true)}, Approved},
+		{"repeat approval doesn't count twice", twoElders, []vote{elder(ruth, "Ruth", true), elder(ruth, "Ruth", true)}, UnderReview},
+		{"mufundisi not needed", twoElders, []vote{mufundisi(true)}, UnderReview},
+		{"mufundisi can't veto", twoElders, []vote{mufundisi(false)}, UnderReview},
+		{"single elder decline", twoElders, []vote{elder(ruth, "Ruth", true), elder(tendai, "Tendai", false)}, Declined},
+		{"namesakes both count", twoElders, []vote{elder(ruth, "Ruth", true), elder(otherRuth, "Ruth", true)}, Approved},
+		{"renamed elder votes once", twoElders, []vote{elder(ruth, "Ruth", true), elder(ruth, "Ruth M", true)}, UnderReview},
+		{"namesake's decline is their own", builtin, []vote{elder(ruth, "Ruth", false), elder(otherRuth, "Ruth", false)}, Declined},
+		{"veto is case-insensitive", policy.Quorum{Veto: []string{"Elder"}, Elders: 1}, []vote{elder(ruth, "Ruth", false)}, Declined},
+	}
+	for _, tt := range tests {
+		l := &Loan{Status: Submitted}
+		for _, v := range tt.votes {
+			if err := l.Vote(tt.q, v.from, v.name, v.role, v.approve, "no"); err != nil {
+				t.Fatalf("%s: vote %+v: %v", tt.name, v, err)
+			}
+		}
+		if got := l.State(); got != tt.want {
+			t.Errorf("%s: status %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVoteAfterDecision(t *testing.T) {
+	q := policy.Builtin.Default.Quorum
+
+	declined := &Loan{Status: Submitted}
+	if err := declined.Vote(q, pastor, "Pastor", "mufundisi", false, "arrears"); err != nil {
+		t.Fatal(err)
+	}
+	if declined.DeclineReason != "arrears" {
+		t.Errorf("decline reason %q, want %q", declined.DeclineReason, "arrears")
+	}
+	if err := declined.Vote(q, ruth, "Ruth", "elder", true, ""); !errors.Is(err, ErrDecided) {
+		t.Errorf("approving a declined loan: %v, want ErrDecided", err)
+	}
+
+	approved := &Loan{Status: Submitted}
+	if err := approved.Vote(q, pastor, "Pastor", "mufundisi", true, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := approved.Vote(q, ruth, "Ruth", "elder", true, ""); err != nil {
+		t.Errorf("approving an approved loan: %v", err)
+	}
+	if !approved.ElderApprovals[ruth] || approved.ApproverNames[ruth] != "Ruth" {
+		t.Error("late approval not recorded")
+	}
+	if err := approved.Vote(q, tendai, "Tendai", "elder", false, "no"); !errors.Is(err, ErrDecided) {
+		t.Errorf("declining an approved loan: %v, want ErrDecided", err)
+	}
+	if got := approved.State(); got != Approved {
+		t.Errorf("status %s, want %s", got, Approved)
+	}
+}
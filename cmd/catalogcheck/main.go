@@ -0,0 +1,81 @@
+// Command catalogcheck compares the message catalogs with the English one and
+// with the code: it reports keys a language is missing or has extra, messages
+// whose arguments don't match the English message's, and English keys that
+// appear as a string literal nowhere in the Go sources. It exits with status
+// 1 when it finds anything.
+//
+//	go run ./cmd/catalogcheck
+//	go run ./cmd/catalogcheck -catalogs ./mycatalogs -src ./api
+//
+// Without -catalogs it checks the built-in catalogs.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xetkloset/demo/i18n"
+)
+
+func main() {
+	dir := flag.String("catalogs", "", "catalog directory (default the built-in catalogs)")
+	src := flag.String("src", ".", "directory of Go sources that use the keys, searched recursively")
+	flag.Parse()
+
+	var c *i18n.Catalogs
+	var err error
+	if *dir != "" {
+		c, err = i18n.Load(os.DirFS(*dir))
+	} else {
+		c, err = i18n.Builtin()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	literals, err := stringLiterals(*src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	problems := c.Check(func(key string) bool { return literals[key] })
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+// stringLiterals collects the string literals in the non-test Go files
+// under dir.
+func stringLiterals(dir string) (map[string]bool, error) {
+	literals := map[string]bool{}
+	fset := token.NewFileSet()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return err
+		}
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			if lit, ok := n.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				if s, err := strconv.Unquote(lit.Value); err == nil {
+					literals[s] = true
+				}
+			}
+			return true
+		})
+		return nil
+	})
+	return literals, err
+}
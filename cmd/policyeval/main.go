@@ -0,0 +1,71 @@
+// Command policyeval is a dry run of the loan limit policy: it checks a
+// policy file and shows the limit and term a set of approvals would get,
+// without touching any loan. Given -requested it also underwrites the result
+// against the applicant facts passed as flags.
+//
+//	go run ./cmd/policyeval -policy policy/example.json -region Nyika -mufundisi -elders 1 -recs 2
+//	go run ./cmd/policyeval -mufundisi -elders 2 -requested 450 -activity 5 -exposure 300
+//
+// Without -policy it reads WALLETBOT_POLICY_FILE, falling back to the
+// built-in policy like the bot does.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/xetkloset/demo/money"
+	"github.com/xetkloset/demo/policy"
+	"github.com/xetkloset/demo/underwrite"
+)
+
+func main() {
+	path := flag.String("policy", "", "policy file (default $WALLETBOT_POLICY_FILE or the built-in policy)")
+	region := flag.String("region", "Tabhera", "loan region")
+	mufundisi := flag.Bool("mufundisi", false, "the mufundisi has approved")
+	elders := flag.Int("elders", 0, "number of elders who have approved")
+	recs := flag.Int("recs", 0, "number of distinct recommenders")
+	requested := flag.String("requested", "", "amount requested; underwrites the result when set")
+	activity := flag.Int("activity", 0, "wallet transactions the applicant has made")
+	repaid := flag.Int("repaid", 0, "other loans the applicant has repaid")
+	arrears := flag.String("arrears", "", "comma-separated IDs of other loans in arrears")
+	exposure := flag.String("exposure", "0", "amount owed or undrawn on other loans")
+	flag.Parse()
+
+	var src *policy.Source
+	var err error
+	if *path != "" {
+		src, err = policy.Open(*path)
+	} else {
+		src, err = policy.FromEnv()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	p, err := src.Current()
+	if err != nil {
+		log.Fatal(err)
+	}
+	res := p.Evaluate(policy.Approvals{Region: *region, Mufundisi: *mufundisi, Elders: *elders, Recommendations: *recs})
+	fmt.Print(res.Explain())
+	if *requested == "" {
+		return
+	}
+	f := underwrite.Facts{Activity: *activity, Repaid: *repaid, Exposure: money.Zero(p.Currency)}
+	if f.Requested, err = money.Parse(*requested, p.Currency); err != nil {
+		log.Fatalf("-requested: %v", err)
+	}
+	if *exposure != "0" {
+		if f.Exposure, err = money.Parse(*exposure, p.Currency); err != nil {
+			log.Fatalf("-exposure: %v", err)
+		}
+	}
+	if *arrears != "" {
+		f.InArrears = strings.Split(*arrears, ",")
+	}
+	d := underwrite.Decide(p.Rules(*region), res, f, time.Now())
+	fmt.Printf("\nunderwriting:\n%slimit %s, term %d months\n", d.Explain(), d.Limit, d.TermMonths)
+}
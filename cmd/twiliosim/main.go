@@ -0,0 +1,55 @@
+// Command twiliosim stands in for Twilio when running the bot locally: it
+// signs a WhatsApp message webhook with the test auth token and prints the
+// TwiML reply. Run the server with TWILIO_TEST_MODE=1 and no TWILIO_AUTH_TOKEN.
+//
+//	go run ./cmd/twiliosim -url http://localhost:3000/api/whatsapp -from whatsapp:+263771234567 hi
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/xetkloset/demo/twilio"
+)
+
+func main() {
+	target := flag.String("url", "http://localhost:3000/api/whatsapp", "webhook URL, exactly as the server sees it")
+	from := flag.String("from", "whatsapp:+263770000001", "sender")
+	token := flag.String("token", twilio.TestAuthToken, "auth token to sign with")
+	sid := flag.String("sid", "", "MessageSid to send (random if empty); reuse one to test replay rejection")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: twiliosim [flags] message")
+		os.Exit(2)
+	}
+	if *sid == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		*sid = "SM" + hex.EncodeToString(b)
+	}
+	params := url.Values{
+		"MessageSid": {*sid},
+		"From":       {*from},
+		"To":         {"whatsapp:+14155238886"},
+		"Body":       {strings.Join(flag.Args(), " ")},
+	}
+	req, err := twilio.Signer{AuthToken: *token}.NewRequest(*target, params)
+	if err != nil {
+		log.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Printf("%s\n%s\n", resp.Status, body)
+}
@@ -0,0 +1,167 @@
+// Package flow runs a conversation as a set of registered stages.
+//
+// Each stage has a prompt shown on entry, a parser for the user's reply,
+// validators, and the stages it may move to. Build checks that every stage a
+// transition names is registered, so a misspelt or missing stage is caught
+// when the flow is assembled instead of stranding a user mid-conversation.
+package flow
+
+import (
+	"errors"
+	"fmt"
+)
+
+// StageID names a stage.
+type StageID string
+
+// ErrUnknownStage is returned when asked to run a stage that isn't registered.
+var ErrUnknownStage = errors.New("flow: unknown stage")
+
+// Rejection turns down a reply: the user is shown Msg and stays where they are.
+// Parsers, validators and Next return it; any other error is a failure.
+type Rejection struct {
+	Msg string
+}
+
+func (r *Rejection) Error() string { return r.Msg }
+
+// Reject returns a Rejection with msg.
+func Reject(msg string) error { return &Rejection{Msg: msg} }
+
+// Transition is the outcome of a step. An empty To stays in the current
+// stage; an empty Reply shows the prompt of the stage moved to.
+type Transition struct {
+	To    StageID
+	Reply string
+}
+
+// Go moves to stage to and shows its prompt.
+func Go(to StageID) Transition { return Transition{To: to} }
+
+// Say moves to stage to and replies with msg instead of its prompt.
+func Say(to StageID, msg string) Transition { return Transition{To: to, Reply: msg} }
+
+// Stay keeps the current stage and replies with msg, or its prompt if msg is empty.
+func Stay(msg string) Transition { return Transition{Reply: msg} }
+
+// Stage describes one step of the conversation. C is the per-message context
+// and V what Parse makes of the user's reply.
+type Stage[C, V any] struct {
+	// Enter returns the prompt shown when moving into the stage.
+	Enter func(C) string
+	// Parse reads the reply; it is required.
+	Parse func(C, string) (V, error)
+	// Validate runs in order on the parsed value.
+	Validate []func(C, V) error
+	// Next decides where to go; it is required.
+	Next func(C, V) (Transition, error)
+	// To lists every stage Next may move to, other than staying put.
+	To []StageID
+}
+
+type node[C any] struct {
+	enter func(C) string
+	step  func(C, string) (Transition, error)
+	to    map[StageID]bool
+}
+
+// Machine is a set of stages. Register them with Add, then call Build once
+// before use.
+type Machine[C any] struct {
+	start StageID
+	nodes map[StageID]*node[C]
+	errs  []error
+}
+
+// New returns an empty machine whose conversations begin at start.
+func New[C any](start StageID) *Machine[C] {
+	return &Machine[C]{start: start, nodes: map[StageID]*node[C]{}}
+}
+
+// Add registers stage id. Mistakes are reported by Build.
+func Add[C, V any](m *Machine[C], id StageID, st Stage[C, V]) {
+	if _, dup := m.nodes[id]; dup {
+		m.errs = append(m.errs, fmt.Errorf("flow: stage %q registered twice", id))
+		return
+	}
+	if st.Parse == nil || st.Next == nil {
+		m.errs = append(m.errs, fmt.Errorf("flow: stage %q needs Parse and Next", id))
+		return
+	}
+	n := &node[C]{enter: st.Enter, to: map[StageID]bool{}}
+	for _, to := range st.To {
+		n.to[to] = true
+	}
+	n.step = func(c C, input string) (Transition, error) {
+		v, err := st.Parse(c, input)
+		if err != nil {
+			return Transition{}, err
+		}
+		for _, check := range st.Validate {
+			if err := check(c, v); err != nil {
+				return Transition{}, err
+			}
+		}
+		return st.Next(c, v)
+	}
+	m.nodes[id] = n
+}
+
+// Parse is a Stage parser that passes the reply through unchanged.
+func Parse[C any](_ C, input string) (string, error) { return input, nil }
+
+// Build reports registration mistakes: duplicate or incomplete stages, and
+// transitions or a start stage that name an unregistered stage.
+func (m *Machine[C]) Build() error {
+	errs := append([]error(nil), m.errs...)
+	if m.nodes[m.start] == nil {
+		errs = append(errs, fmt.Errorf("flow: start stage %q is not registered", m.start))
+	}
+	for id, n := range m.nodes {
+		for to := range n.to {
+			if m.nodes[to] == nil {
+				errs = append(errs, fmt.Errorf("flow: stage %q moves to unregistered stage %q", id, to))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Start is the stage new conversations begin at.
+func (m *Machine[C]) Start() StageID { return m.start }
+
+// Has reports whether id is registered.
+func (m *Machine[C]) Has(id StageID) bool { return m.nodes[id] != nil }
+
+// Prompt returns the entry prompt of stage id.
+func (m *Machine[C]) Prompt(c C, id StageID) string {
+	if n := m.nodes[id]; n != nil && n.enter != nil {
+		return n.enter(c)
+	}
+	return ""
+}
+
+// Step feeds input to stage at and returns the stage to continue from and
+// the reply. Rejections come back as a reply, not an error.
+func (m *Machine[C]) Step(c C, at StageID, input string) (StageID, string, error) {
+	n := m.nodes[at]
+	if n == nil {
+		return at, "", fmt.Errorf("%w %q", ErrUnknownStage, at)
+	}
+	t, err := n.step(c, input)
+	var rej *Rejection
+	if errors.As(err, &rej) {
+		return at, rej.Msg, nil
+	} else if err != nil {
+		return at, "", err
+	}
+	if t.To == "" {
+		t.To = at
+	} else if t.To != at && !n.to[t.To] {
+		return at, "", fmt.Errorf("flow: stage %q moved to undeclared stage %q", at, t.To)
+	}
+	if t.Reply == "" {
+		t.Reply = m.Prompt(c, t.To)
+	}
+	return t.To, t.Reply, nil
+}
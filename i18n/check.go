@@ -0,0 +1,77 @@
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kinds of Problem.
+const (
+	Missing   = "missing"   // the language lacks an English key
+	Unknown   = "unknown"   // the language has a key English doesn't
+	Unused    = "unused"    // no code asks for the key
+	Arguments = "arguments" // the arguments differ from the English message's
+)
+
+// Problem is one way the catalogs are out of step.
+type Problem struct {
+	Language string
+	Key      string
+	Kind     string
+	Detail   string
+}
+
+func (p Problem) String() string {
+	s := fmt.Sprintf("%s: %s %s", p.Language, p.Kind, p.Key)
+	if p.Detail != "" {
+		s += ": " + p.Detail
+	}
+	return s
+}
+
+// Check compares every catalog with the English one. used reports whether
+// code refers to a key; keys it says no to are reported as unused. With a nil
+// used, unused keys aren't looked for.
+func (c *Catalogs) Check(used func(key string) bool) []Problem {
+	var problems []Problem
+	ref := c.texts[Fallback]
+	for _, key := range sortedKeys(ref) {
+		if used != nil && !used(key) {
+			problems = append(problems, Problem{Language: Fallback, Key: key, Kind: Unused})
+		}
+	}
+	for _, l := range c.languages {
+		language := l.Code
+		if language == Fallback {
+			continue
+		}
+		texts := c.texts[language]
+		for _, key := range sortedKeys(ref) {
+			if _, ok := texts[key]; !ok {
+				problems = append(problems, Problem{Language: language, Key: key, Kind: Missing})
+				continue
+			}
+			want := strings.Join(c.messages[Fallback][key].argNames(), " ")
+			if got := strings.Join(c.messages[language][key].argNames(), " "); want != got {
+				problems = append(problems, Problem{Language: language, Key: key, Kind: Arguments,
+					Detail: fmt.Sprintf("{%s}, English has {%s}", got, want)})
+			}
+		}
+		for _, key := range sortedKeys(texts) {
+			if _, ok := ref[key]; !ok {
+				problems = append(problems, Problem{Language: language, Key: key, Kind: Unknown})
+			}
+		}
+	}
+	return problems
+}
+
+func sortedKeys(texts map[string]string) []string {
+	keys := make([]string, 0, len(texts))
+	for key := range texts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,41 @@
+package i18n
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Guess picks the language a first message is most likely in. A word of text
+// among a catalog's "greetings" decides it; failing that, the longest of a
+// catalog's "phone_prefixes" that starts phone ("+263...") does, unless
+// another catalog lists the same prefix: a country where several of the
+// languages are spoken says nothing about which one. Both are space-separated
+// lists. ok is false when nothing decided it.
+func (c *Catalogs) Guess(text, phone string) (language string, ok bool) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) })
+	for _, l := range c.languages {
+		for _, g := range strings.Fields(strings.ToLower(c.texts[l.Code]["greetings"])) {
+			for _, w := range words {
+				if w == g {
+					return l.Code, true
+				}
+			}
+		}
+	}
+	best, shared := "", false
+	for _, l := range c.languages {
+		for _, p := range strings.Fields(c.texts[l.Code]["phone_prefixes"]) {
+			switch {
+			case !strings.HasPrefix(phone, p) || len(p) < len(best):
+			case len(p) > len(best):
+				best, language, shared = p, l.Code, false
+			case l.Code != language:
+				shared = true
+			}
+		}
+	}
+	if best == "" || shared {
+		return "", false
+	}
+	return language, true
+}
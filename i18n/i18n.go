@@ -0,0 +1,178 @@
+// Package i18n holds the texts the bot speaks, one catalog per language. A
+// catalog is a JSON file named after its language code ("sn.json"): it
+// declares the language, and maps message keys to messages with named
+// arguments, plurals and selects (see message).
+//
+//	{
+//	  "language": {"code": "sn", "name": "chiShona", "order": 2,
+//	               "plural": "one_other", "group": ",", "decimal": "."},
+//	  "messages": {"goodbye": "👋 Tinotenda ...", ...}
+//	}
+//
+// The loaded catalogs are the registry of languages the bot speaks, and each
+// declares everything the bot needs to know about its language, so adding one
+// takes only a new file. The catalogs in catalogs/ are built in;
+// WALLETBOT_CATALOG_DIR points at a directory to load instead, so wording can
+// be fixed without a rebuild. Every message is checked as it is loaded, so
+// a mistake in a catalog stops the bot starting rather than garbling a reply.
+//
+// English is the reference catalog: a key another language lacks is shown in
+// English, and Check reports how the others differ from it.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Fallback is the language of the reference catalog.
+const Fallback = "en"
+
+//go:embed catalogs/*.json
+var builtin embed.FS
+
+// Language is what a catalog declares about itself.
+type Language struct {
+	Code    string `json:"code"`
+	Name    string `json:"name"`    // in the language itself, for the language menu
+	Order   int    `json:"order"`   // place in the language menu, lowest first
+	Plural  string `json:"plural"`  // its plural rule, a key of PluralRules
+	Group   string `json:"group"`   // digit-group mark, "," in 1,250
+	Decimal string `json:"decimal"` // decimal mark, "." in 2.50
+}
+
+// catalog is the layout of a catalog file.
+type catalog struct {
+	Language Language          `json:"language"`
+	Messages map[string]string `json:"messages"`
+}
+
+// Catalogs are the loaded catalogs, by language code.
+type Catalogs struct {
+	languages []Language // in menu order
+	byCode    map[string]Language
+	texts     map[string]map[string]string
+	messages  map[string]map[string]message
+
+	mu      sync.Mutex
+	missing map[string]bool // "lang/key" already logged
+}
+
+// Builtin returns the catalogs compiled into the binary.
+func Builtin() (*Catalogs, error) {
+	sub, err := fs.Sub(builtin, "catalogs")
+	if err != nil {
+		return nil, err
+	}
+	return Load(sub)
+}
+
+// FromEnv loads the catalogs in WALLETBOT_CATALOG_DIR, or the built-in ones
+// when it isn't set.
+func FromEnv() (*Catalogs, error) {
+	dir := os.Getenv("WALLETBOT_CATALOG_DIR")
+	if dir == "" {
+		return Builtin()
+	}
+	return Load(os.DirFS(dir))
+}
+
+// Load reads every *.json catalog at the top of fsys. Each must declare the
+// language its file is named after, with a name, a known plural rule and its
+// number marks; there must be an English one.
+func Load(fsys fs.FS) (*Catalogs, error) {
+	names, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, err
+	}
+	c := &Catalogs{byCode: map[string]Language{}, texts: map[string]map[string]string{}, messages: map[string]map[string]message{}, missing: map[string]bool{}}
+	var errs []error
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		var cat catalog
+		if err := json.Unmarshal(data, &cat); err != nil {
+			return nil, fmt.Errorf("i18n: %s: %v", name, err)
+		}
+		l := cat.Language
+		if l.Code != strings.TrimSuffix(path.Base(name), ".json") || l.Name == "" {
+			return nil, fmt.Errorf("i18n: %s: declares language %q named %q", name, l.Code, l.Name)
+		}
+		if _, ok := PluralRules[l.Plural]; !ok {
+			return nil, fmt.Errorf("i18n: %s: unknown plural rule %q", name, l.Plural)
+		}
+		if l.Group == "" || l.Decimal == "" || l.Group == l.Decimal {
+			return nil, fmt.Errorf("i18n: %s: number marks %q and %q", name, l.Group, l.Decimal)
+		}
+		c.languages = append(c.languages, l)
+		c.byCode[l.Code] = l
+		c.texts[l.Code] = cat.Messages
+		c.messages[l.Code] = map[string]message{}
+		for _, key := range sortedKeys(cat.Messages) {
+			m, err := parseMessage(cat.Messages[key])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("i18n: %s: %s: %v", name, key, err))
+				continue
+			}
+			c.messages[l.Code][key] = m
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if c.texts[Fallback] == nil {
+		return nil, fmt.Errorf("i18n: no %s.json catalog", Fallback)
+	}
+	sort.Slice(c.languages, func(i, j int) bool {
+		a, b := c.languages[i], c.languages[j]
+		return a.Order < b.Order || (a.Order == b.Order && a.Code < b.Code)
+	})
+	return c, nil
+}
+
+// Languages returns the languages of the loaded catalogs, in menu order.
+func (c *Catalogs) Languages() []Language {
+	return append([]Language(nil), c.languages...)
+}
+
+// Text returns the message for key in language, for messages without
+// arguments.
+func (c *Catalogs) Text(language, key string) string {
+	return c.Textf(language, key, nil)
+}
+
+// Textf returns the message for key in language formatted with args,
+// falling back to English and then to the key itself. Each gap is logged
+// once.
+func (c *Catalogs) Textf(language, key string, args Args) string {
+	m, ok := c.messages[language][key]
+	if !ok {
+		c.logMissing(language, key)
+		if m, ok = c.messages[Fallback][key]; !ok {
+			return key
+		}
+		language = Fallback
+	}
+	return m.format(c.byCode[language], args)
+}
+
+func (c *Catalogs) logMissing(language, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.missing[language+"/"+key] {
+		return
+	}
+	c.missing[language+"/"+key] = true
+	log.Printf("i18n: no %q text in %s", key, language)
+}
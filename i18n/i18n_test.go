@@ -0,0 +1,139 @@
+package i18n
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func catalogFile(language, messages string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(`{"language": ` + language + `, "messages": {` + messages + `}}`)}
+}
+
+const enLanguage = `{"code": "en", "name": "English", "order": 1, "plural": "one_other", "group": ",", "decimal": "."}`
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"en.json": catalogFile(enLanguage, `"loans": "{n, plural, one {# loan} other {# loans}}", "hi": "Hi", "greetings": "hello", "phone_prefixes": "+1"`),
+		"fr.json": catalogFile(`{"code": "fr", "name": "Français", "order": 2, "plural": "zero_one_other", "group": " ", "decimal": ","}`,
+			`"loans": "{n, plural, one {# prêt} other {# prêts}}", "greetings": "bonjour", "phone_prefixes": "+33 +1"`),
+		"sw.json": catalogFile(`{"code": "sw", "name": "Kiswahili", "order": 2, "plural": "one_other", "group": ",", "decimal": "."}`,
+			`"greetings": "habari", "phone_prefixes": "+255"`),
+	}
+	c, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var codes []string
+	for _, l := range c.Languages() {
+		codes = append(codes, l.Code)
+	}
+	if got := strings.Join(codes, " "); got != "en fr sw" {
+		t.Errorf("languages %s, want en fr sw", got)
+	}
+	for _, tt := range []struct{ language, key, want string }{
+		{"fr", "loans", "0 prêt"},
+		{"en", "loans", "0 loans"},
+		{"fr", "hi", "Hi"},
+		{"xx", "hi", "Hi"},
+		{"fr", "nope", "nope"},
+	} {
+		if got := c.Textf(tt.language, tt.key, Args{"n": 0}); got != tt.want {
+			t.Errorf("Textf(%s, %s) = %q, want %q", tt.language, tt.key, got, tt.want)
+		}
+	}
+	if got := c.Textf("fr", "loans", Args{"n": 1500}); got != "1 500 prêts" {
+		t.Errorf("French 1500 loans = %q", got)
+	}
+	for _, tt := range []struct{ text, phone, want string }{
+		{"Bonjour!", "+1555", "fr"},
+		{"hi", "+255700", "sw"},
+		{"hi", "+33600", "fr"},
+		{"hi", "+1555", ""}, // +1 is listed by English and French
+		{"hi", "+44", ""},
+	} {
+		got, ok := c.Guess(tt.text, tt.phone)
+		if !ok {
+			got = ""
+		}
+		if got != tt.want {
+			t.Errorf("Guess(%q, %q) = %q, want %q", tt.text, tt.phone, got, tt.want)
+		}
+	}
+}
+
+// Guess on the built-in catalogs, where Shona and Ndebele are both spoken
+// in Zimbabwe (+263) and only Ndebele is listed for South Africa (+27).
+func TestGuess(t *testing.T) {
+	c, err := Builtin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, text, phone, want string
+	}{
+		{"Shona greeting", "Mhoro", "+263771111111", "sn"},
+		{"Shona greeting in a sentence", "Mangwanani, ndeipi?", "+447700900000", "sn"},
+		{"Ndebele greeting", "sawubona", "+263771111111", "nd"},
+		{"Ndebele greeting with punctuation", "Salibonani!!", "+27821234567", "nd"},
+		{"greeting beats the number", "mhoro", "+27821234567", "sn"},
+		{"greeting inside a word", "sawubonaphi", "+1555", ""},
+		{"shared prefix", "hi", "+263771111111", ""},
+		{"Ndebele-only prefix", "hi", "+27821234567", "nd"},
+		{"unknown country", "hello", "+447700900000", ""},
+		{"nothing to go on", "", "", ""},
+	}
+	for _, tt := range tests {
+		got, ok := c.Guess(tt.text, tt.phone)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("%s: Guess(%q, %q) = %q, %v; want %q", tt.name, tt.text, tt.phone, got, ok, tt.want)
+		}
+	}
+
+	// the longest prefix decides, even over a shorter shared one
+	c, err = Load(fstest.MapFS{
+		"en.json": catalogFile(enLanguage, `"phone_prefixes": "+1"`),
+		"fr.json": catalogFile(`{"code": "fr", "name": "Français", "plural": "one_other", "group": " ", "decimal": ","}`, `"phone_prefixes": "+1 +1418"`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for phone, want := range map[string]string{"+14185550000": "fr", "+12125550000": ""} {
+		if got, _ := c.Guess("allo", phone); got != want {
+			t.Errorf("Guess(%q) = %q, want %q", phone, got, want)
+		}
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{"no English", fstest.MapFS{
+			"fr.json": catalogFile(`{"code": "fr", "name": "Français", "plural": "other", "group": " ", "decimal": ","}`, ``),
+		}, "no en.json"},
+		{"wrong code", fstest.MapFS{
+			"en.json": catalogFile(`{"code": "eng", "name": "English", "plural": "one_other", "group": ",", "decimal": "."}`, ``),
+		}, `declares language "eng"`},
+		{"unknown plural rule", fstest.MapFS{
+			"en.json": catalogFile(`{"code": "en", "name": "English", "plural": "dual", "group": ",", "decimal": "."}`, ``),
+		}, `unknown plural rule "dual"`},
+		{"no marks", fstest.MapFS{
+			"en.json": catalogFile(`{"code": "en", "name": "English", "plural": "one_other"}`, ``),
+		}, "number marks"},
+		{"same marks", fstest.MapFS{
+			"en.json": catalogFile(`{"code": "en", "name": "English", "plural": "one_other", "group": ".", "decimal": "."}`, ``),
+		}, "number marks"},
+		{"bad message", fstest.MapFS{
+			"en.json": catalogFile(enLanguage, `"a": "{n, plural, one {#}}", "b": "{"`),
+		}, "en.json: a: at"},
+	}
+	for _, tt := range tests {
+		_, err := Load(tt.fsys)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Args are the named arguments of a message.
+type Args map[string]interface{}
+
+// A message is a catalog text in a subset of ICU MessageFormat:
+//
+//	Sent {amount} to {name}
+//	{count, number} loans
+//	{months, plural, =0 {no term} one {# month} other {# months}}
+//	{role, select, mufundisi {Mufundisi} other {Elder}} approved it
+//
+// Inside a plural branch, and any select within it, # is the number.
+// Numbers and # are written with the language's digit-group and decimal
+// marks. An apostrophe quotes a following brace or # up to the next
+// apostrophe, and two stand for one; any other apostrophe is literal, so
+// "Type 'back'" needs no escaping.
+type message []node
+
+// node is one piece of a message: literal text, an argument, or the # of
+// the plural branch it is in.
+type node struct {
+	text  string // literal text when arg and pound are unset
+	arg   string
+	kind  string // "", "number", "plural" or "select"
+	pound bool
+	cases []branch // plural and select
+}
+
+type branch struct {
+	key string // "=2", a plural category or a select value
+	msg message
+}
+
+// pluralCategories are the CLDR plural categories a branch may be keyed by.
+var pluralCategories = map[string]bool{"zero": true, "one": true, "two": true, "few": true, "many": true, "other": true}
+
+// PluralRules give the plural category of a whole number, by the rule names
+// catalogs declare (see Language). They follow CLDR's rules for integers.
+var PluralRules = map[string]func(n int64) string{
+	// 1 is one: English, Shona, Ndebele and most Bantu languages
+	"one_other": func(n int64) string {
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+	// 0 and 1 are one: French, Portuguese, Amharic
+	"zero_one_other": func(n int64) string {
+		if n == 0 || n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+	// no plural forms: Chinese, Japanese, Yoruba
+	"other": func(int64) string { return "other" },
+}
+
+// parseMessage compiles text, reporting the first thing wrong with it.
+func parseMessage(text string) (message, error) {
+	p := &parser{s: text}
+	m, err := p.message(false)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.s) {
+		return nil, p.errorf("unmatched }")
+	}
+	return m, nil
+}
+
+type parser struct {
+	s string
+	i int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at %d: %s", p.i, fmt.Sprintf(format, args...))
+}
+
+// message reads up to an unmatched } or the end. inPlural makes # the number.
+func (p *parser) message(inPlural bool) (message, error) {
+	var m message
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			m = append(m, node{text: lit.String()})
+			lit.Reset()
+		}
+	}
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		switch {
+		case c == '\'':
+			p.quoted(&lit, inPlural)
+		case c == '{':
+			flush()
+			n, err := p.placeholder(inPlural)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, n)
+		case c == '}':
+			flush()
+			return m, nil
+		case c == '#' && inPlural:
+			flush()
+			m = append(m, node{pound: true})
+			p.i++
+		default:
+			lit.WriteByte(c)
+			p.i++
+		}
+	}
+	flush()
+	return m, nil
+}
+
+// quoted reads an apostrophe and whatever it quotes into lit.
+func (p *parser) quoted(lit *strings.Builder, inPlural bool) {
+	p.i++
+	if p.i < len(p.s) && p.s[p.i] == '\'' {
+		lit.WriteByte('\'')
+		p.i++
+		return
+	}
+	if p.i >= len(p.s) || !(p.s[p.i] == '{' || p.s[p.i] == '}' || (p.s[p.i] == '#' && inPlural)) {
+		lit.WriteByte('\'')
+		return
+	}
+	for p.i < len(p.s) {
+		if p.s[p.i] == '\'' {
+			if p.i+1 < len(p.s) && p.s[p.i+1] == '\'' {
+				lit.WriteByte('\'')
+				p.i += 2
+				continue
+			}
+			p.i++
+			return
+		}
+		lit.WriteByte(p.s[p.i])
+		p.i++
+	}
+}
+
+// placeholder reads {name}, {name, number}, or a plural or select. inPlural
+// carries an enclosing plural's # into select branches.
+func (p *parser) placeholder(inPlural bool) (node, error) {
+	p.i++ // {
+	n := node{arg: p.word()}
+	if n.arg == "" {
+		return n, p.errorf("argument name expected")
+	}
+	if p.accept('}') {
+		return n, nil
+	}
+	if !p.accept(',') {
+		return n, p.errorf("expected , or } after %s", n.arg)
+	}
+	n.kind = p.word()
+	switch n.kind {
+	case "number":
+		if !p.accept('}') {
+			return n, p.errorf("expected } after %s, number", n.arg)
+		}
+		return n, nil
+	case "plural", "select":
+	default:
+		return n, p.errorf("unknown argument type %q", n.kind)
+	}
+	if !p.accept(',') {
+		return n, p.errorf("expected , after %s, %s", n.arg, n.kind)
+	}
+	seen := map[string]bool{}
+	for {
+		p.space()
+		if p.accept('}') {
+			break
+		}
+		key := p.word()
+		switch {
+		case key == "":
+			return n, p.errorf("%s branch expected in %s", n.kind, n.arg)
+		case seen[key]:
+			return n, p.errorf("%s has two %s branches", n.arg, key)
+		case n.kind == "plural" && !pluralCategories[key] && !exact(key):
+			return n, p.errorf("%s is not a plural category", key)
+		}
+		seen[key] = true
+		p.space()
+		if !p.accept('{') {
+			return n, p.errorf("expected { after %s", key)
+		}
+		msg, err := p.message(inPlural || n.kind == "plural")
+		if err != nil {
+			return n, err
+		}
+		if !p.accept('}') {
+			return n, p.errorf("unterminated %s branch in %s", key, n.arg)
+		}
+		n.cases = append(n.cases, branch{key: key, msg: msg})
+	}
+	if !seen["other"] {
+		return n, p.errorf("%s has no other branch", n.arg)
+	}
+	return n, nil
+}
+
+// exact reports whether key is a plural branch for one number, like "=0".
+func exact(key string) bool {
+	_, err := strconv.ParseInt(strings.TrimPrefix(key, "="), 10, 64)
+	return strings.HasPrefix(key, "=") && err == nil
+}
+
+func (p *parser) space() {
+	for p.i < len(p.s) && strings.IndexByte(" \t\n", p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+// word reads a name, type or branch key, skipping the space around it.
+func (p *parser) word() string {
+	p.space()
+	start := p.i
+	for p.i < len(p.s) && strings.IndexByte(" \t\n{},#'", p.s[p.i]) < 0 {
+		p.i++
+	}
+	w := p.s[start:p.i]
+	p.space()
+	return w
+}
+
+func (p *parser) accept(c byte) bool {
+	if p.i < len(p.s) && p.s[p.i] == c {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// format renders m in language. An argument missing from args is left in
+// braces, so the gap shows.
+func (m message) format(language Language, args Args) string {
+	var b strings.Builder
+	m.write(&b, language, args, nil)
+	return b.String()
+}
+
+func (m message) write(b *strings.Builder, language Language, args Args, pound *int64) {
+	for _, n := range m {
+		switch {
+		case n.pound:
+			b.WriteString(language.number(*pound))
+		case n.arg == "":
+			b.WriteString(n.text)
+		default:
+			v, ok := args[n.arg]
+			if !ok {
+				b.WriteString("{" + n.arg + "}")
+				continue
+			}
+			switch n.kind {
+			case "plural":
+				num, _ := integer(v)
+				pickPlural(n.cases, language.Plural, num).write(b, language, args, &num)
+			case "select":
+				pickSelect(n.cases, fmt.Sprint(v)).write(b, language, args, pound)
+			case "number":
+				b.WriteString(language.number(v))
+			default:
+				fmt.Fprint(b, v)
+			}
+		}
+	}
+}
+
+// number writes v with the language's group and decimal marks. Values that
+// aren't numbers are written as they are.
+func (l Language) number(v interface{}) string {
+	var s string
+	switch n := v.(type) {
+	case float64:
+		s = strconv.FormatFloat(n, 'f', -1, 64)
+	case float32:
+		s = strconv.FormatFloat(float64(n), 'f', -1, 32)
+	default:
+		i, ok := integer(v)
+		if !ok {
+			return fmt.Sprint(v)
+		}
+		s = strconv.FormatInt(i, 10)
+	}
+	var b strings.Builder
+	if strings.HasPrefix(s, "-") {
+		b.WriteString("-")
+		s = s[1:]
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(l.Group)
+		}
+		b.WriteRune(r)
+	}
+	if frac != "" {
+		b.WriteString(l.Decimal + frac)
+	}
+	return b.String()
+}
+
+// integer reads a whole number argument; anything else counts as 0.
+func integer(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+func pickPlural(cases []branch, rule string, n int64) message {
+	exactKey, category := "="+strconv.FormatInt(n, 10), PluralRules[rule](n)
+	for _, c := range cases {
+		if c.key == exactKey {
+			return c.msg
+		}
+	}
+	return pickSelect(cases, category)
+}
+
+func pickSelect(cases []branch, key string) message {
+	var other message
+	for _, c := range cases {
+		if c.key == key {
+			return c.msg
+		}
+		if c.key == "other" {
+			other = c.msg
+		}
+	}
+	return other
+}
+
+// argNames lists the arguments m refers to, sorted.
+func (m message) argNames() []string {
+	seen := map[string]bool{}
+	var walk func(message)
+	walk = func(m message) {
+		for _, n := range m {
+			if n.arg != "" {
+				seen[n.arg] = true
+			}
+			for _, c := range n.cases {
+				walk(c.msg)
+			}
+		}
+	}
+	walk(m)
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
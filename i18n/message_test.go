@@ -0,0 +1,85 @@
+package i18n
+
+import (
+	"strings"
+	"testing"
+)
+
+var (
+	english = Language{Code: "en", Plural: "one_other", Group: ",", Decimal: "."}
+	french  = Language{Code: "fr", Plural: "zero_one_other", Group: " ", Decimal: ","}
+)
+
+func TestFormat(t *testing.T) {
+	months := "{n, plural, =0 {no term} one {# month} other {# months}}"
+	tests := []struct {
+		lang Language
+		text string
+		args Args
+		want string
+	}{
+		{english, "Sent {amount} to {name}", Args{"amount": "$5.00", "name": "Ann"}, "Sent $5.00 to Ann"},
+		{english, "Hi {name}", nil, "Hi {name}"},
+		{english, months, Args{"n": 0}, "no term"},
+		{english, months, Args{"n": 1}, "1 month"},
+		{english, months, Args{"n": 6}, "6 months"},
+		{english, months, Args{"n": 1200}, "1,200 months"},
+		{french, "{n, plural, one {# mois} other {# mois!}}", Args{"n": 0}, "0 mois"},
+		{french, "{n, plural, one {# mois} other {# mois!}}", Args{"n": 2}, "2 mois!"},
+		{english, "{n, plural, one {# month} other {# months}}", Args{"n": "x"}, "0 months"},
+		{english, "{n, number} loans", Args{"n": 1234567}, "1,234,567 loans"},
+		{english, "{n, number}", Args{"n": -1234}, "-1,234"},
+		{english, "{n, number}", Args{"n": 999}, "999"},
+		{french, "{n, number}", Args{"n": 1234567}, "1 234 567"},
+		{french, "{n, number}", Args{"n": 12.5}, "12,5"},
+		{english, "{n, number}", Args{"n": "many"}, "many"},
+		{english, "{role, select, mufundisi {Mufundisi} other {Elder}} approved", Args{"role": "mufundisi"}, "Mufundisi approved"},
+		{english, "{role, select, mufundisi {Mufundisi} other {Elder}} approved", Args{"role": "elder"}, "Elder approved"},
+		{english, "{role, select, elder {{n, plural, one {# elder} other {# elders}}} other {#}}", Args{"role": "elder", "n": 2}, "2 elders"},
+		{english, "{n, plural, other {{role, select, elder {# by elders} other {#}}}}", Args{"role": "elder", "n": 3}, "3 by elders"},
+		{english, "Type 'back' or '{'menu'}'", nil, "Type 'back' or {menu}"},
+		{english, "It''s # {n, plural, other {'#' #}}", Args{"n": 4}, "It's # # 4"},
+	}
+	for _, tt := range tests {
+		m, err := parseMessage(tt.text)
+		if err != nil {
+			t.Errorf("parse %q: %v", tt.text, err)
+			continue
+		}
+		if got := m.format(tt.lang, tt.args); got != tt.want {
+			t.Errorf("%s %q with %v = %q, want %q", tt.lang.Code, tt.text, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"oops }", "unmatched }"},
+		{"{}", "argument name expected"},
+		{"{n", "expected , or }"},
+		{"{n, date}", "unknown argument type"},
+		{"{n, number, integer}", "expected } after n, number"},
+		{"{n, plural {# x}}", "expected , after n, plural"},
+		{"{n, plural, one {#}}", "no other branch"},
+		{"{n, plural, few {#} lots {#} other {#}}", "lots is not a plural category"},
+		{"{n, plural, one {#} one {#} other {#}}", "two one branches"},
+		{"{n, select, a b {x} other {y}}", "expected { after a"},
+		{"{n, select, a {x", "unterminated a branch"},
+	}
+	for _, tt := range tests {
+		_, err := parseMessage(tt.text)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parse %q: %v, want %q", tt.text, err, tt.want)
+		}
+	}
+}
+
+func TestArgNames(t *testing.T) {
+	m, err := parseMessage("{b} {a, plural, other {{c, select, other {{b}}}}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(m.argNames(), ","); got != "a,b,c" {
+		t.Errorf("argNames = %s, want a,b,c", got)
+	}
+}
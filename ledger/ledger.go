@@ -0,0 +1,232 @@
+// Package ledger is a double-entry book of wallet money. Every movement is a
+// journal entry whose debit and credit legs balance, and account balances are
+// always derived from the postings rather than stored.
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xetkloset/demo/money"
+)
+
+var (
+	ErrUnbalanced        = errors.New("ledger: entry debits and credits differ")
+	ErrInsufficientFunds = errors.New("ledger: insufficient funds")
+)
+
+// Account names. The prefix decides the account's kind and so which side
+// increases its balance.
+const (
+	walletPrefix  = "wallet:"
+	loanPrefix    = "loan:"
+	payablePrefix = "payable:"
+	expensePrefix = "expense:"
+	incomePrefix  = "income:"
+
+	// Promotions funds the opening balance every new wallet is given.
+	Promotions = expensePrefix + "promotions"
+	// AirtimePayable is what we owe the network for airtime sold.
+	AirtimePayable = payablePrefix + "airtime"
+	// InterestIncome is the interest borrowers have paid on loans.
+	InterestIncome = incomePrefix + "interest"
+	// Adjustments absorbs manual corrections to wallet balances.
+	Adjustments = expensePrefix + "adjustments"
+)
+
+// Wallet is a member's wallet account, a liability of the operator.
+func Wallet(owner string) string { return walletPrefix + owner }
+
+// LoanReceivable is the principal a borrower owes on one loan, an asset.
+func LoanReceivable(loanID string) string { return loanPrefix + loanID }
+
+// debitNormal reports whether debits increase the account's balance.
+func debitNormal(account string) bool {
+	return strings.HasPrefix(account, loanPrefix) || strings.HasPrefix(account, expensePrefix)
+}
+
+// Entry kinds, used to render history lines.
+const (
+	KindOpening      = "opening_balance"
+	KindTransfer     = "transfer"
+	KindAirtime      = "airtime"
+	KindDisbursement = "loan_disbursement"
+	KindRepayment    = "loan_repayment"
+	KindAdjustment   = "adjustment"
+)
+
+// Side of a leg.
+type Side string
+
+const (
+	Debit  Side = "debit"
+	Credit Side = "credit"
+)
+
+// Leg is one posting of an entry to an account.
+type Leg struct {
+	Account string      `json:"account"`
+	Side    Side        `json:"side"`
+	Amount  money.Money `json:"amount"`
+}
+
+// Entry is a balanced journal entry. Kind and Meta carry what is needed to
+// describe the entry later (counterparty, loan ID, ...) in any language.
+type Entry struct {
+	ID   string            `json:"id"`
+	Time time.Time         `json:"time"`
+	Kind string            `json:"kind"`
+	Meta map[string]string `json:"meta,omitempty"`
+	Legs []Leg             `json:"legs"`
+}
+
+// Net is the entry's effect on the balance of account.
+func (e Entry) Net(account string) money.Money {
+	var n money.Money
+	for _, l := range e.Legs {
+		if l.Account != account {
+			continue
+		}
+		if (l.Side == Debit) == debitNormal(account) {
+			n = n.Add(l.Amount)
+		} else {
+			n = n.Sub(l.Amount)
+		}
+	}
+	return n
+}
+
+// Touches reports whether any leg posts to account.
+func (e Entry) Touches(account string) bool {
+	for _, l := range e.Legs {
+		if l.Account == account {
+			return true
+		}
+	}
+	return false
+}
+
+// Journal is the append-only storage of entries. Append passes check the
+// entries already journaled and appends e only if check returns nil, with no
+// other append in between, even from another process; it assigns e's ID.
+type Journal interface {
+	Append(e *Entry, check func(entries []Entry) error) error
+	Entries() ([]Entry, error)
+}
+
+// Ledger validates and posts entries to a Journal. All its accounts are held
+// in one currency.
+type Ledger struct {
+	journal  Journal
+	currency string
+	now      func() time.Time
+}
+
+func New(j Journal, currency string) *Ledger {
+	return &Ledger{journal: j, currency: currency, now: time.Now}
+}
+
+// Transfer builds a two-leg entry moving amount from one account to another:
+// the from account is debited and the to account credited.
+func Transfer(kind, from, to string, amount money.Money, meta map[string]string) Entry {
+	return Entry{
+		Kind: kind,
+		Meta: meta,
+		Legs: []Leg{
+			{Account: from, Side: Debit, Amount: amount},
+			{Account: to, Side: Credit, Amount: amount},
+		},
+	}
+}
+
+// Post validates e and appends it. An entry must have at least two legs with
+// positive amounts in the ledger's currency whose debits equal its credits,
+// and it may not take any wallet below zero. The balances are checked in the
+// same journal step as the append, so two posts can't both spend the same
+// money.
+func (l *Ledger) Post(e Entry) (Entry, error) {
+	if err := l.validate(e); err != nil {
+		return Entry{}, err
+	}
+	e.Time = l.now()
+	err := l.journal.Append(&e, func(entries []Entry) error {
+		for _, leg := range e.Legs {
+			net := e.Net(leg.Account)
+			if !strings.HasPrefix(leg.Account, walletPrefix) || !net.IsNegative() {
+				continue
+			}
+			if l.balance(entries, leg.Account).Add(net).IsNegative() {
+				return ErrInsufficientFunds
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+// Balance of account computed from every posting to it.
+func (l *Ledger) Balance(account string) (money.Money, error) {
+	entries, err := l.journal.Entries()
+	if err != nil {
+		return money.Money{}, err
+	}
+	return l.balance(entries, account), nil
+}
+
+// History returns the entries that touch account, newest first.
+func (l *Ledger) History(account string) ([]Entry, error) {
+	entries, err := l.journal.Entries()
+	if err != nil {
+		return nil, err
+	}
+	var out []Entry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Touches(account) {
+			out = append(out, entries[i])
+		}
+	}
+	return out, nil
+}
+
+func (l *Ledger) validate(e Entry) error {
+	if len(e.Legs) < 2 {
+		return fmt.Errorf("ledger: entry needs at least two legs, got %d", len(e.Legs))
+	}
+	debits, credits := money.Zero(l.currency), money.Zero(l.currency)
+	for _, leg := range e.Legs {
+		if leg.Account == "" {
+			return errors.New("ledger: leg without account")
+		}
+		if leg.Amount.Currency != l.currency {
+			return fmt.Errorf("ledger: %s leg in a %s ledger", leg.Amount.Currency, l.currency)
+		}
+		if !leg.Amount.IsPositive() {
+			return fmt.Errorf("ledger: invalid amount %v on %s", leg.Amount, leg.Account)
+		}
+		switch leg.Side {
+		case Debit:
+			debits = debits.Add(leg.Amount)
+		case Credit:
+			credits = credits.Add(leg.Amount)
+		default:
+			return fmt.Errorf("ledger: invalid side %q", leg.Side)
+		}
+	}
+	if debits.Cmp(credits) != 0 {
+		return ErrUnbalanced
+	}
+	return nil
+}
+
+func (l *Ledger) balance(entries []Entry, account string) money.Money {
+	b := money.Zero(l.currency)
+	for _, e := range entries {
+		b = b.Add(e.Net(account))
+	}
+	return b
+}
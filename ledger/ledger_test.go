@@ -0,0 +1,81 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/xetkloset/demo/money"
+)
+
+// sliceJournal keeps entries in memory for the tests.
+type sliceJournal struct{ entries []Entry }
+
+func (j *sliceJournal) Append(e *Entry, check func([]Entry) error) error {
+	if err := check(j.entries); err != nil {
+		return err
+	}
+	e.ID = fmt.Sprintf("E%d", len(j.entries)+1)
+	j.entries = append(j.entries, *e)
+	return nil
+}
+
+func (j *sliceJournal) Entries() ([]Entry, error) { return j.entries, nil }
+
+func usd(cents int64) money.Money { return money.New(cents, money.USD) }
+
+func TestPost(t *testing.T) {
+	ann, bob := Wallet("ann"), Wallet("bob")
+	tests := []struct {
+		name string
+		e    Entry
+		err  error
+		ann  int64 // balances afterwards, in cents
+		bob  int64
+	}{
+		{"within balance", Transfer(KindTransfer, ann, bob, usd(4000), nil), nil, 6000, 4000},
+		{"whole balance", Transfer(KindTransfer, ann, bob, usd(10000), nil), nil, 0, 10000},
+		{"one cent over", Transfer(KindTransfer, ann, bob, usd(10001), nil), ErrInsufficientFunds, 10000, 0},
+		{"empty wallet", Transfer(KindTransfer, bob, ann, usd(1), nil), ErrInsufficientFunds, 10000, 0},
+		{"expense accounts may go negative", Transfer(KindAdjustment, Adjustments, bob, usd(500), nil), nil, 10000, 500},
+		{"unbalanced", Entry{Kind: KindTransfer, Legs: []Leg{
+			{Account: ann, Side: Debit, Amount: usd(100)},
+			{Account: bob, Side: Credit, Amount: usd(90)},
+		}}, ErrUnbalanced, 10000, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(&sliceJournal{}, money.USD)
+			if _, err := l.Post(Transfer(KindOpening, Promotions, ann, usd(10000), nil)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := l.Post(tt.e); !errors.Is(err, tt.err) {
+				t.Fatalf("Post = %v, want %v", err, tt.err)
+			}
+			for account, want := range map[string]int64{ann: tt.ann, bob: tt.bob} {
+				if got, _ := l.Balance(account); got.Minor != want {
+					t.Errorf("balance of %s = %v, want %d cents", account, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPostRejectsMalformed(t *testing.T) {
+	ann, bob := Wallet("ann"), Wallet("bob")
+	for name, e := range map[string]Entry{
+		"one leg":        {Legs: []Leg{{Account: ann, Side: Debit, Amount: usd(1)}}},
+		"zero amount":    Transfer(KindTransfer, ann, bob, usd(0), nil),
+		"other currency": Transfer(KindTransfer, ann, bob, money.New(100, "ZAR"), nil),
+		"no account":     Transfer(KindTransfer, "", bob, usd(1), nil),
+		"bad side": {Legs: []Leg{
+			{Account: ann, Side: "sideways", Amount: usd(1)},
+			{Account: bob, Side: Credit, Amount: usd(1)},
+		}},
+	} {
+		j := &sliceJournal{}
+		if _, err := New(j, money.USD).Post(e); err == nil || len(j.entries) != 0 {
+			t.Errorf("%s: Post = %v with %d entries, want an error and nothing journaled", name, err, len(j.entries))
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xetkloset/demo/transport"
+)
+
+// DefaultBaseURL is the Graph API the client talks to unless told otherwise.
+const DefaultBaseURL = "https://graph.facebook.com/v20.0"
+
+// Cloud API limits on interactive messages.
+const (
+	maxButtons     = 3
+	maxButtonTitle = 20
+	maxRows        = 10
+	maxRowTitle    = 24
+	maxRowDesc     = 72
+	maxInteractive = 1024 // body text of an interactive message
+	maxText        = 4096 // body of a plain text message
+)
+
+// ListButtons labels the button that opens a list menu, by language; English
+// is used for languages it lacks.
+var ListButtons = map[string]string{
+	"en": "Choose",
+}
+
+// Client sends messages from one business phone number.
+type Client struct {
+	Token         string // system user or app access token
+	PhoneNumberID string
+	BaseURL       string       // DefaultBaseURL when empty
+	HTTP          *http.Client // a client with a 10s timeout when nil
+}
+
+var defaultHTTP = &http.Client{Timeout: 10 * time.Second}
+
+// outbound is a Cloud API message send request.
+type outbound struct {
+	Product     string       `json:"messaging_product"`
+	To          string       `json:"to"`
+	Type        string       `json:"type"`
+	Text        *text        `json:"text,omitempty"`
+	Interactive *interactive `json:"interactive,omitempty"`
+}
+
+type text struct {
+	Body string `json:"body"`
+}
+
+type interactive struct {
+	Type   string  `json:"type"` // "button" or "list"
+	Body   caption `json:"body"`
+	Action action  `json:"action"`
+}
+
+type caption struct {
+	Text string `json:"text"`
+}
+
+type action struct {
+	Button   string    `json:"button,omitempty"` // list only
+	Buttons  []button  `json:"buttons,omitempty"`
+	Sections []section `json:"sections,omitempty"`
+}
+
+type button struct {
+	Type  string `json:"type"`
+	Reply choice `json:"reply"`
+}
+
+type section struct {
+	Rows []row `json:"rows"`
+}
+
+type row struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// Send delivers reply to the WhatsApp ID to ("whatsapp:+<digits>"). A reply
+// ending in a numbered menu goes out as reply buttons when it has at most
+// three short choices and as a list when it has at most ten; anything else
+// is sent as text.
+func (c *Client) Send(to string, reply transport.Reply) error {
+	msg := outbound{
+		Product:     "whatsapp",
+		To:          strings.TrimPrefix(strings.TrimPrefix(to, "whatsapp:"), "+"),
+		Type:        "interactive",
+		Interactive: menu(reply),
+	}
+	if msg.Interactive == nil {
+		msg.Type = "text"
+		msg.Text = &text{Body: transport.Truncate(reply.Text, maxText)}
+	}
+	return c.post(msg)
+}
+
+// menu builds the interactive form of reply, or nil when it has none.
+func menu(reply transport.Reply) *interactive {
+	body, options := transport.Menu(reply.Text)
+	if len(options) == 0 || len(options) > maxRows || body == "" || utf8.RuneCountInString(body) > maxInteractive {
+		return nil
+	}
+	if len(options) <= maxButtons && fitButtons(options) {
+		m := &interactive{Type: "button", Body: caption{Text: body}}
+		for _, o := range options {
+			m.Action.Buttons = append(m.Action.Buttons, button{Type: "reply", Reply: choice{ID: o.ID, Title: o.Title}})
+		}
+		return m
+	}
+	label, ok := ListButtons[reply.Language]
+	if !ok {
+		label = ListButtons["en"]
+	}
+	var s section
+	for _, o := range options {
+		r := row{ID: o.ID, Title: transport.Truncate(o.Title, maxRowTitle)}
+		if r.Title != o.Title {
+			// the full title goes underneath rather than being lost
+			r.Description = transport.Truncate(o.Title, maxRowDesc)
+		}
+		s.Rows = append(s.Rows, r)
+	}
+	return &interactive{Type: "list", Body: caption{Text: body}, Action: action{Button: label, Sections: []section{s}}}
+}
+
+func fitButtons(options []transport.Option) bool {
+	for _, o := range options {
+		if utf8.RuneCountInString(o.Title) > maxButtonTitle {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Client) post(msg outbound) error {
+	if c.Token == "" || c.PhoneNumberID == "" {
+		return fmt.Errorf("meta: access token or phone number ID not configured")
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	base := c.BaseURL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(base, "/")+"/"+c.PhoneNumberID+"/messages", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	hc := c.HTTP
+	if hc == nil {
+		hc = defaultHTTP
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("meta: send to %s: %s: %s", msg.To, resp.Status, bytes.TrimSpace(detail))
+	}
+	return nil
+}
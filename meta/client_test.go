@@ -0,0 +1,122 @@
+package meta
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/xetkloset/demo/transport"
+)
+
+// send passes reply through a Client talking to a stand-in Graph API and
+// returns the message it posted.
+func send(t *testing.T, reply transport.Reply) outbound {
+	t.Helper()
+	var got outbound
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/PNID/messages" || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("posted to %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(`{"messages": [{"id": "wamid.1"}]}`))
+	}))
+	defer srv.Close()
+	c := &Client{Token: "token", PhoneNumberID: "PNID", BaseURL: srv.URL}
+	if err := c.Send("whatsapp:+263771111111", reply); err != nil {
+		t.Fatal(err)
+	}
+	if got.To != "263771111111" {
+		t.Errorf("sent to %q", got.To)
+	}
+	return got
+}
+
+// numbered appends a menu of titles, numbered from 1, to text.
+func numbered(text string, titles ...string) string {
+	for i, title := range titles {
+		text += "\n" + strconv.Itoa(i+1) + "️⃣ " + title
+	}
+	return text
+}
+
+func TestSendButtons(t *testing.T) {
+	got := send(t, transport.Reply{Text: "Send $5.00 to Tendai?\n1️⃣ Yes\n2️⃣ No", Language: "en"})
+	if got.Type != "interactive" || got.Interactive == nil || got.Interactive.Type != "button" {
+		t.Fatalf("sent %+v", got)
+	}
+	if got.Interactive.Body.Text != "Send $5.00 to Tendai?" {
+		t.Errorf("body %q", got.Interactive.Body.Text)
+	}
+	want := []button{{"reply", choice{ID: "1", Title: "Yes"}}, {"reply", choice{ID: "2", Title: "No"}}}
+	if !reflect.DeepEqual(got.Interactive.Action.Buttons, want) {
+		t.Errorf("buttons %+v", got.Interactive.Action.Buttons)
+	}
+}
+
+func TestSendList(t *testing.T) {
+	saved := ListButtons
+	defer func() { ListButtons = saved }()
+	ListButtons = map[string]string{"en": "Choose", "nd": "Khetha"}
+
+	long := "Request a loan for someone else" // too long for a button or a row title
+	tests := []struct {
+		name, language, label string
+		titles                []string
+	}{
+		{"four choices", "en", "Choose", []string{"Balance", "Send", "Airtime", "Loans"}},
+		{"long title", "en", "Choose", []string{"Balance", long}},
+		{"language with a label", "nd", "Khetha", []string{"Imali", "Thumela", "Airtime", "Imalimboleko"}},
+		{"language without a label", "sn", "Choose", []string{"Mari", "Tumira", "Airtime", "Zvikwereti"}},
+	}
+
+	for _, tt := range tests {
+		got := send(t, transport.Reply{Text: numbered("Main menu:", tt.titles...), Language: tt.language})
+		if got.Interactive == nil || got.Interactive.Type != "list" {
+			t.Errorf("%s: sent %+v", tt.name, got)
+			continue
+		}
+		a := got.Interactive.Action
+		if a.Button != tt.label || len(a.Sections) != 1 || len(a.Sections[0].Rows) != len(tt.titles) {
+			t.Errorf("%s: action %+v", tt.name, a)
+			continue
+		}
+		for i, r := range a.Sections[0].Rows {
+			title := tt.titles[i]
+			want := row{ID: strconv.Itoa(i + 1), Title: title}
+			if title == long {
+				want.Title, want.Description = transport.Truncate(long, maxRowTitle), long
+			}
+			if r != want {
+				t.Errorf("%s: row %d is %+v, want %+v", tt.name, i, r, want)
+			}
+		}
+	}
+}
+
+// Replies that can't be an interactive message go out as the text they are.
+func TestSendText(t *testing.T) {
+	eleven := make([]string, 11)
+	for i := range eleven {
+		eleven[i] = "Region"
+	}
+	tests := []struct {
+		name, text string
+	}{
+		{"no menu", "Your balance is $5.00"},
+		{"menu only", "1️⃣ Yes\n2️⃣ No"},
+		{"too many choices", numbered("Pick:", eleven...)},
+		{"body too long", numbered(strings.Repeat("x", maxInteractive+1), "Yes", "No")},
+	}
+	for _, tt := range tests {
+		got := send(t, transport.Reply{Text: tt.text, Language: "en"})
+		if got.Type != "text" || got.Interactive != nil || got.Text == nil || got.Text.Body != tt.text {
+			t.Errorf("%s: sent %+v", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Package meta is the WhatsApp Cloud API channel: it answers Meta's webhook
+// verification handshake, checks that message webhooks are signed with the
+// app secret, runs incoming messages through the conversation and sends the
+// replies back through the Graph API, with menus shown as reply buttons or
+// lists.
+//
+// Meta signs every webhook with HMAC-SHA256 over the raw body, keyed with the
+// app secret, and sends "sha256=<hex>" in X-Hub-Signature-256. See
+// https://developers.facebook.com/docs/graph-api/webhooks/getting-started
+package meta
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/xetkloset/demo/transport"
+)
+
+// SignatureHeader carries the request signature.
+const SignatureHeader = "X-Hub-Signature-256"
+
+// maxBody caps how much of a webhook body is read.
+const maxBody = 1 << 20
+
+// Signature computes the X-Hub-Signature-256 header for body.
+func Signature(appSecret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Webhook serves the Cloud API webhook for one phone number.
+type Webhook struct {
+	// VerifyToken is the token entered in the app dashboard; the GET
+	// handshake must echo it back.
+	VerifyToken string
+	// AppSecret is the app secret the POST signatures are keyed with.
+	AppSecret string
+	// Client sends the replies.
+	Client *Client
+	// Replays remembers handled message IDs, since Meta delivers at least
+	// once; nil disables the check. Instances that don't share memory must
+	// share the store.
+	Replays transport.Replays
+}
+
+// FromEnv configures a Webhook from META_VERIFY_TOKEN, META_APP_SECRET,
+// META_ACCESS_TOKEN and META_PHONE_NUMBER_ID, remembering message IDs in
+// replays.
+func FromEnv(replays transport.Replays) *Webhook {
+	return &Webhook{
+		VerifyToken: os.Getenv("META_VERIFY_TOKEN"),
+		AppSecret:   os.Getenv("META_APP_SECRET"),
+		Client: &Client{
+			Token:         os.Getenv("META_ACCESS_TOKEN"),
+			PhoneNumberID: os.Getenv("META_PHONE_NUMBER_ID"),
+		},
+		Replays: replays,
+	}
+}
+
+// payload is the part of a webhook notification the bot reads. Status
+// updates for sent messages arrive in the same envelope and are ignored.
+type payload struct {
+	Entry []struct {
+		Changes []struct {
+			Value struct {
+				Messages []inbound `json:"messages"`
+			} `json:"value"`
+		} `json:"changes"`
+	} `json:"entry"`
+}
+
+type inbound struct {
+	ID   string `json:"id"`
+	From string `json:"from"` // digits only, with the country code
+	Type string `json:"type"`
+	Text struct {
+		Body string `json:"body"`
+	} `json:"text"`
+	Interactive struct {
+		Type        string `json:"type"`
+		ButtonReply choice `json:"button_reply"`
+		ListReply   choice `json:"list_reply"`
+	} `json:"interactive"`
+	Button struct {
+		Payload string `json:"payload"`
+		Text    string `json:"text"`
+	} `json:"button"`
+}
+
+type choice struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// body is what the user said. A tapped button or list row reads as the
+// option's ID, the same digit they would have typed; other message types
+// (images, locations, ...) come through empty.
+func (m inbound) body() string {
+	switch m.Type {
+	case "text":
+		return m.Text.Body
+	case "interactive":
+		switch m.Interactive.Type {
+		case "button_reply":
+			return m.Interactive.ButtonReply.ID
+		case "list_reply":
+			return m.Interactive.ListReply.ID
+		}
+	case "button":
+		if m.Button.Payload != "" {
+			return m.Button.Payload
+		}
+		return m.Button.Text
+	}
+	return ""
+}
+
+// Handler returns the webhook handler: GET answers the verification
+// handshake, POST runs each message in the notification through conv and
+// sends the reply. Unsigned or badly signed posts get a 403. When conv fails
+// the message is forgotten and the post answered with a 503 so Meta delivers
+// it again; a reply that can't be sent is only logged, since the
+// conversation has already moved on.
+func (h *Webhook) Handler(conv transport.Conversation) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.verify(w, r)
+		case http.MethodPost:
+			h.receive(w, r, conv)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func (h *Webhook) verify(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if h.VerifyToken == "" || q.Get("hub.mode") != "subscribe" ||
+		!hmac.Equal([]byte(q.Get("hub.verify_token")), []byte(h.VerifyToken)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, q.Get("hub.challenge"))
+}
+
+func (h *Webhook) receive(w http.ResponseWriter, r *http.Request, conv transport.Conversation) {
+	if h.AppSecret == "" {
+		log.Printf("meta: no app secret configured, rejecting webhook")
+		http.Error(w, "Webhook not configured", http.StatusInternalServerError)
+		return
+	}
+	raw, err := io.ReadAll(io.LimitReader(r.Body, maxBody))
+	if err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !hmac.Equal([]byte(Signature(h.AppSecret, raw)), []byte(r.Header.Get(SignatureHeader))) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var p payload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	for _, e := range p.Entry {
+		for _, c := range e.Changes {
+			for _, m := range c.Value.Messages {
+				if h.Replays != nil {
+					first, err := h.Replays.First(m.ID)
+					if err != nil {
+						log.Printf("meta: replay check %s: %v", m.ID, err)
+						http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+						return
+					}
+					if !first {
+						continue
+					}
+				}
+				from := "whatsapp:+" + m.From
+				reply, err := conv(transport.Message{From: from, Body: m.body(), Channel: transport.ChannelWhatsApp})
+				if err != nil {
+					log.Printf("meta %s: %v", from, err)
+					if h.Replays != nil {
+						if err := h.Replays.Forget(m.ID); err != nil {
+							log.Printf("meta: forget %s: %v", m.ID, err)
+						}
+					}
+					http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+					return
+				}
+				if err := h.Client.Send(from, reply); err != nil {
+					log.Printf("meta send %s: %v", from, err)
+				}
+			}
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
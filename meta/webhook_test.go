@@ -0,0 +1,74 @@
+package meta
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xetkloset/demo/bot"
+	"github.com/xetkloset/demo/transport"
+)
+
+// notification is a webhook body carrying one text message.
+func notification(id, body string) string {
+	return `{"entry": [{"changes": [{"value": {"messages": [
+		{"id": "` + id + `", "from": "263771111111", "type": "text", "text": {"body": "` + body + `"}}
+	]}}]}]}`
+}
+
+func post(h http.Handler, secret, body string) int {
+	req := httptest.NewRequest(http.MethodPost, "/api/meta", strings.NewReader(body))
+	req.Header.Set(SignatureHeader, Signature(secret, []byte(body)))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// Two webhooks stand in for two serverless instances sharing the stores: a
+// message handled by one is skipped by the other, unless handling it failed.
+func TestReceiveReplays(t *testing.T) {
+	replays, err := bot.OpenFileReplayStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fail error
+	var got []string
+	conv := func(m transport.Message) (transport.Reply, error) {
+		if fail != nil {
+			return transport.Reply{}, fail
+		}
+		got = append(got, m.Body)
+		return transport.Reply{Text: "ok"}, nil
+	}
+	var instances [2]http.Handler
+	for i := range instances {
+		instances[i] = (&Webhook{AppSecret: "secret", Client: &Client{}, Replays: replays}).Handler(conv)
+	}
+
+	tests := []struct {
+		name     string
+		instance int
+		id       string
+		secret   string
+		fail     error
+		want     int
+		handled  int // messages conv has handled so far
+	}{
+		{"first delivery", 0, "wamid.1", "secret", nil, http.StatusOK, 1},
+		{"redelivered to another instance", 1, "wamid.1", "secret", nil, http.StatusOK, 1},
+		{"forged", 1, "wamid.2", "other", nil, http.StatusForbidden, 1},
+		{"handling fails", 1, "wamid.2", "secret", errors.New("store down"), http.StatusServiceUnavailable, 1},
+		{"retried on another instance", 0, "wamid.2", "secret", nil, http.StatusOK, 2},
+		{"retried again", 1, "wamid.2", "secret", nil, http.StatusOK, 2},
+	}
+	for _, tt := range tests {
+		fail = tt.fail
+		code := post(instances[tt.instance], tt.secret, notification(tt.id, "hi "+tt.id))
+		if code != tt.want || len(got) != tt.handled {
+			t.Errorf("%s: status %d after %d messages, want %d after %d", tt.name, code, len(got), tt.want, tt.handled)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Package money is exact currency arithmetic in integer minor units, with
+// strict parsing of user-typed amounts and per-language formatting.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// USD is the currency every wallet and loan is held in.
+const USD = "USD"
+
+var (
+	ErrFormat      = errors.New("money: not a plain decimal amount")
+	ErrNotPositive = errors.New("money: amount must be greater than zero")
+	ErrPrecision   = errors.New("money: more decimal places than the currency allows")
+	ErrRange       = errors.New("money: amount too large")
+	ErrCurrency    = errors.New("money: unknown currency")
+)
+
+// currency describes how a currency is written.
+type currency struct {
+	digits int               // minor unit digits
+	symbol map[string]string // per language, "" key is the default
+}
+
+var currencies = map[string]currency{
+	USD:   {digits: 2, symbol: map[string]string{"": "$", "sn": "US$", "nd": "US$"}},
+	"ZAR": {digits: 2, symbol: map[string]string{"": "R"}},
+	"ZWG": {digits: 2, symbol: map[string]string{"": "ZiG"}},
+}
+
+// Locale is how a language writes numbers: its digit-group and decimal marks.
+type Locale struct{ Group, Decimal string }
+
+// Locales are the number marks by language; English's are used for
+// languages it lacks. The bot fills in the others from the languages its
+// message catalogs declare.
+var Locales = map[string]Locale{
+	"en": {",", "."},
+}
+
+// maxMinor bounds parsed amounts well inside int64 so sums can't overflow.
+const maxMinor = 1e15
+
+// Money is an exact amount of a currency in minor units (cents for USD). The
+// zero value is zero of no particular currency and combines with any amount.
+type Money struct {
+	Minor    int64  `json:"minor"`
+	Currency string `json:"currency"`
+}
+
+// New returns minor units of cur.
+func New(minor int64, cur string) Money {
+	return Money{Minor: minor, Currency: cur}
+}
+
+// FromMajor returns whole units (dollars) of cur.
+func FromMajor(units int64, cur string) Money {
+	return Money{Minor: units * scale(cur), Currency: cur}
+}
+
+// Zero is nothing of cur.
+func Zero(cur string) Money {
+	return Money{Currency: cur}
+}
+
+func scale(cur string) int64 {
+	s := int64(1)
+	for range currencies[cur].digits {
+		s *= 10
+	}
+	return s
+}
+
+// Parse reads an amount as a user types it: digits with an optional currency
+// symbol in front, optional correctly placed thousands commas and at most as
+// many decimals as cur has minor digits. Signs, exponents, NaN/Inf, zero and
+// fractions of a cent are all rejected.
+func Parse(s, cur string) (Money, error) {
+	c, ok := currencies[cur]
+	if !ok {
+		return Money{}, ErrCurrency
+	}
+	s = strings.TrimSpace(s)
+	for _, sym := range c.symbol {
+		if sym != "" && len(s) > len(sym) && strings.EqualFold(s[:len(sym)], sym) {
+			s = s[len(sym):]
+			break
+		}
+	}
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if hasFrac && frac == "" {
+		return Money{}, ErrFormat
+	}
+	whole, err := stripGroups(whole)
+	if err != nil {
+		return Money{}, err
+	}
+	if !allDigits(whole) || !allDigits(frac) || (whole == "" && frac == "") {
+		return Money{}, ErrFormat
+	}
+	if len(frac) > c.digits {
+		return Money{}, ErrPrecision
+	}
+	if len(strings.TrimLeft(whole, "0")) > 13 {
+		return Money{}, ErrRange
+	}
+	frac += strings.Repeat("0", c.digits-len(frac))
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil || minor > maxMinor {
+		return Money{}, ErrRange
+	}
+	if minor == 0 {
+		return Money{}, ErrNotPositive
+	}
+	return Money{Minor: minor, Currency: cur}, nil
+}
+
+// stripGroups removes thousands commas, which must sit every three digits.
+func stripGroups(s string) (string, error) {
+	if !strings.Contains(s, ",") {
+		return s, nil
+	}
+	groups := strings.Split(s, ",")
+	if len(groups[0]) == 0 || len(groups[0]) > 3 {
+		return "", ErrFormat
+	}
+	for _, g := range groups[1:] {
+		if len(g) != 3 {
+			return "", ErrFormat
+		}
+	}
+	return strings.Join(groups, ""), nil
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// common picks the currency two amounts share; mixing currencies is a
+// programming error.
+func common(a, b Money) string {
+	switch {
+	case a.Currency == "":
+		return b.Currency
+	case b.Currency == "", a.Currency == b.Currency:
+		return a.Currency
+	}
+	panic(fmt.Sprintf("money: mixing %s and %s", a.Currency, b.Currency))
+}
+
+func (m Money) Add(o Money) Money {
+	return Money{Minor: m.Minor + o.Minor, Currency: common(m, o)}
+}
+
+func (m Money) Sub(o Money) Money {
+	return Money{Minor: m.Minor - o.Minor, Currency: common(m, o)}
+}
+
+// Mul scales m by a whole number.
+func (m Money) Mul(n int64) Money {
+	return Money{Minor: m.Minor * n, Currency: m.Currency}
+}
+
+func (m Money) Neg() Money {
+	return Money{Minor: -m.Minor, Currency: m.Currency}
+}
+
+// Cmp returns -1, 0 or +1 as m is less than, equal to or greater than o.
+func (m Money) Cmp(o Money) int {
+	common(m, o)
+	switch {
+	case m.Minor < o.Minor:
+		return -1
+	case m.Minor > o.Minor:
+		return 1
+	}
+	return 0
+}
+
+// Min returns the smaller of m and o.
+func (m Money) Min(o Money) Money {
+	if m.Cmp(o) <= 0 {
+		return Money{Minor: m.Minor, Currency: common(m, o)}
+	}
+	return Money{Minor: o.Minor, Currency: common(m, o)}
+}
+
+func (m Money) IsZero() bool     { return m.Minor == 0 }
+func (m Money) IsPositive() bool { return m.Minor > 0 }
+func (m Money) IsNegative() bool { return m.Minor < 0 }
+
+// String is the unlocalized form used in logs and debugging, e.g. "USD 12.50".
+func (m Money) String() string {
+	if m.Minor < 0 {
+		return m.Currency + " -" + m.digits(",", ".")
+	}
+	return m.Currency + " " + m.digits(",", ".")
+}
+
+// Format writes m for a reader of the given language, e.g. "$1,250.00" in
+// English or "US$1,250.00" in Shona. Unknown languages use English.
+func (m Money) Format(language string) string {
+	sep, ok := Locales[language]
+	if !ok {
+		sep = Locales["en"]
+	}
+	sym := m.Currency
+	if c, ok := currencies[m.Currency]; ok {
+		if sym, ok = c.symbol[language]; !ok {
+			sym = c.symbol[""]
+		}
+	}
+	if m.Minor < 0 {
+		return "-" + sym + m.Neg().digits(sep.Group, sep.Decimal)
+	}
+	return sym + m.digits(sep.Group, sep.Decimal)
+}
+
+// digits writes |m| with the given separators.
+func (m Money) digits(group, decimal string) string {
+	n := m.Minor
+	if n < 0 {
+		n = -n
+	}
+	d := currencies[m.Currency].digits
+	sc := scale(m.Currency)
+	whole := strconv.FormatInt(n/sc, 10)
+	var b strings.Builder
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(group)
+		}
+		b.WriteRune(r)
+	}
+	if d > 0 {
+		b.WriteString(decimal)
+		fmt.Fprintf(&b, "%0*d", d, n%sc)
+	}
+	return b.String()
+}
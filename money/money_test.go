@@ -0,0 +1,76 @@
+package money
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in    string
+		minor int64
+		err   error
+	}{
+		{"12", 1200, nil},
+		{"12.5", 1250, nil},
+		{"12.50", 1250, nil},
+		{" 0.01 ", 1, nil},
+		{".5", 50, nil},
+		{"$12", 1200, nil},
+		{"us$12", 1200, nil},
+		{"1,250.75", 125075, nil},
+		{"1,000,000", 100000000, nil},
+		{"", 0, ErrFormat},
+		{"$", 0, ErrFormat},
+		{"12.", 0, ErrFormat},
+		{"-5", 0, ErrFormat},
+		{"+5", 0, ErrFormat},
+		{"1e3", 0, ErrFormat},
+		{"NaN", 0, ErrFormat},
+		{"Inf", 0, ErrFormat},
+		{"12,50", 0, ErrFormat},
+		{"1,2500", 0, ErrFormat},
+		{",100", 0, ErrFormat},
+		{"1 000", 0, ErrFormat},
+		{"0", 0, ErrNotPositive},
+		{"0.00", 0, ErrNotPositive},
+		{"0.001", 0, ErrPrecision},
+		{"12.345", 0, ErrPrecision},
+		{"99999999999999", 0, ErrRange},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in, USD)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%q) error = %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if err == nil && (got.Minor != tt.minor || got.Currency != USD) {
+			t.Errorf("Parse(%q) = %+v, want %d minor USD", tt.in, got, tt.minor)
+		}
+	}
+}
+
+func TestParseUnknownCurrency(t *testing.T) {
+	if _, err := Parse("5", "XXX"); !errors.Is(err, ErrCurrency) {
+		t.Errorf("Parse in XXX: error = %v, want %v", err, ErrCurrency)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		m        Money
+		language string
+		want     string
+	}{
+		{New(125000, USD), "en", "$1,250.00"},
+		{New(125000, USD), "sn", "US$1,250.00"},
+		{New(5, USD), "en", "$0.05"},
+		{New(-1250, USD), "en", "-$12.50"},
+		{New(125000, USD), "xx", "$1,250.00"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.Format(tt.language); got != tt.want {
+			t.Errorf("%v.Format(%q) = %q, want %q", tt.m, tt.language, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Package notify delivers messages members didn't ask for, such as telling an
+// applicant their loan was approved. Notifications are queued in an outbox
+// store first and sent afterwards, so a slow or failing provider never holds
+// up the conversation; failed sends are retried with growing delays until
+// they go through or run out of attempts.
+//
+// WhatsApp only delivers free-form messages within 24 hours of the member's
+// last message; outside that window the provider may reject them, and they
+// end up failed.
+package notify
+
+import (
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xetkloset/demo/bot"
+	"github.com/xetkloset/demo/meta"
+	"github.com/xetkloset/demo/transport"
+	"github.com/xetkloset/demo/twilio"
+)
+
+// Sender delivers one message. meta.Client and twilio.Client are Senders.
+type Sender interface {
+	Send(to string, reply transport.Reply) error
+}
+
+// Delivery settings.
+const (
+	DefaultMaxAttempts = 6
+	batch              = 50
+	lease              = 2 * time.Minute // how long a claimed notification is left alone
+	firstRetry         = time.Minute
+	maxRetry           = 6 * time.Hour
+)
+
+// Dispatcher queues notifications and sends them.
+type Dispatcher struct {
+	Outbox      bot.OutboxStore
+	Sender      Sender
+	MaxAttempts int // DefaultMaxAttempts when 0
+
+	running atomic.Bool
+	now     func() time.Time
+}
+
+// New returns a Dispatcher sending the notifications in outbox through sender.
+func New(outbox bot.OutboxStore, sender Sender) *Dispatcher {
+	return &Dispatcher{Outbox: outbox, Sender: sender, now: time.Now}
+}
+
+// Enqueue queues text for to and starts delivering it in the background.
+func (d *Dispatcher) Enqueue(to, language, loanID, text string) error {
+	now := d.now()
+	n := &bot.Notification{
+		To:       to,
+		Text:     text,
+		Language: language,
+		LoanID:   loanID,
+		Status:   bot.NotificationPending,
+		NextAt:   now,
+		Created:  now,
+	}
+	if err := d.Outbox.Enqueue(n); err != nil {
+		return err
+	}
+	d.Kick()
+	return nil
+}
+
+// Kick starts a background delivery run unless one is already going.
+// Notifications it doesn't reach, say because the process is frozen after
+// the response, are picked up by the next run: see Run and Deliver.
+func (d *Dispatcher) Kick() {
+	if !d.running.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer d.running.Store(false)
+		if _, err := d.Deliver(); err != nil {
+			log.Printf("notify: %v", err)
+		}
+	}()
+}
+
+// Run delivers due notifications every interval until stop is closed, for
+// deployments that run as a long-lived process.
+func (d *Dispatcher) Run(every time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			d.Kick()
+		}
+	}
+}
+
+// Deliver sends every notification that is due and reports how many went
+// out. A failed send is retried later; only a failing outbox is an error.
+func (d *Dispatcher) Deliver() (int, error) {
+	sent := 0
+	for {
+		due, err := d.Outbox.Claim(d.now(), lease, batch)
+		if err != nil || len(due) == 0 {
+			return sent, err
+		}
+		for i := range due {
+			n := &due[i]
+			d.attempt(n)
+			if n.Status == bot.NotificationSent {
+				sent++
+			}
+			if err := d.Outbox.Save(n); err != nil {
+				return sent, err
+			}
+		}
+	}
+}
+
+// attempt sends n once and records the outcome on it.
+func (d *Dispatcher) attempt(n *bot.Notification) {
+	n.Attempts++
+	err := d.Sender.Send(n.To, transport.Reply{Text: n.Text, Language: n.Language})
+	now := d.now()
+	switch {
+	case err == nil:
+		n.Status = bot.NotificationSent
+		n.SentAt = &now
+		n.LastError = ""
+	case n.Attempts >= d.maxAttempts():
+		n.Status = bot.NotificationFailed
+		n.LastError = err.Error()
+		log.Printf("notify %s to %s: giving up after %d attempts: %v", n.ID, n.To, n.Attempts, err)
+	default:
+		n.NextAt = now.Add(retryAfter(n.Attempts))
+		n.LastError = err.Error()
+	}
+}
+
+func (d *Dispatcher) maxAttempts() int {
+	if d.MaxAttempts > 0 {
+		return d.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+// retryAfter is the wait after the given number of failed attempts: a
+// minute, then four times longer each time, up to maxRetry.
+func retryAfter(attempts int) time.Duration {
+	wait := firstRetry
+	for i := 1; i < attempts && wait < maxRetry; i++ {
+		wait *= 4
+	}
+	return min(wait, maxRetry)
+}
+
+// SenderFromEnv picks the provider notifications go out through: the Cloud
+// API when META_ACCESS_TOKEN is set, Twilio when TWILIO_ACCOUNT_SID is set
+// (sending from TWILIO_WHATSAPP_FROM), and otherwise a Fake that only logs.
+func SenderFromEnv() Sender {
+	switch {
+	case os.Getenv("META_ACCESS_TOKEN") != "":
+		return &meta.Client{Token: os.Getenv("META_ACCESS_TOKEN"), PhoneNumberID: os.Getenv("META_PHONE_NUMBER_ID")}
+	case os.Getenv("TWILIO_ACCOUNT_SID") != "":
+		return &twilio.Client{
+			AccountSID: os.Getenv("TWILIO_ACCOUNT_SID"),
+			AuthToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
+			From:       os.Getenv("TWILIO_WHATSAPP_FROM"),
+		}
+	}
+	return &Fake{Log: true}
+}
+
+// Fake is a Sender that keeps what it is given instead of sending it, for
+// tests and local runs.
+type Fake struct {
+	Log  bool  // also log each message
+	Fail error // returned by Send, to exercise retries, when set
+
+	mu   sync.Mutex
+	sent []Message
+}
+
+// Message is one message a Fake was given.
+type Message struct {
+	To    string
+	Reply transport.Reply
+}
+
+func (f *Fake) Send(to string, reply transport.Reply) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Fail != nil {
+		return f.Fail
+	}
+	if f.Log {
+		log.Printf("notify (fake) to %s: %s", to, reply.Text)
+	}
+	f.sent = append(f.sent, Message{To: to, Reply: reply})
+	return nil
+}
+
+// Sent returns the messages sent so far.
+func (f *Fake) Sent() []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Message(nil), f.sent...)
+}
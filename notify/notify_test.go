@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xetkloset/demo/bot"
+)
+
+// recorder is an outbox that remembers every notification saved to it, so
+// tests can see what an attempt recorded.
+type recorder struct {
+	bot.OutboxStore
+	saved []bot.Notification
+}
+
+func (r *recorder) Save(n *bot.Notification) error {
+	r.saved = append(r.saved, *n)
+	return r.OutboxStore.Save(n)
+}
+
+func (r *recorder) last() bot.Notification { return r.saved[len(r.saved)-1] }
+
+// testDispatcher returns a Dispatcher on an empty outbox whose clock reads
+// *clock. Enqueue doesn't start background deliveries: they happen when the
+// test calls Deliver.
+func testDispatcher(sender Sender, clock *time.Time) (*Dispatcher, *recorder) {
+	outbox := &recorder{OutboxStore: bot.NewMemoryOutbox()}
+	d := New(outbox, sender)
+	d.now = func() time.Time { return *clock }
+	d.running.Store(true)
+	return d, outbox
+}
+
+func TestDeliver(t *testing.T) {
+	clock := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	fake := &Fake{}
+	d, outbox := testDispatcher(fake, &clock)
+	if err := d.Enqueue("whatsapp:+263771111111", "sn", "L0001", "Chikwereti chabvumirwa"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Enqueue("whatsapp:+263772222222", "nd", "", "Imalimboleko ivunyiwe"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.Deliver(); n != 2 || err != nil {
+		t.Fatalf("delivered %d, %v; want 2", n, err)
+	}
+	sent := fake.Sent()
+	if len(sent) != 2 || sent[0].To != "whatsapp:+263771111111" || sent[0].Reply.Text != "Chikwereti chabvumirwa" || sent[0].Reply.Language != "sn" {
+		t.Fatalf("sent %+v", sent)
+	}
+	if n := outbox.last(); n.Status != bot.NotificationSent || n.Attempts != 1 || n.SentAt == nil || !n.SentAt.Equal(clock) {
+		t.Errorf("recorded %+v", n)
+	}
+	clock = clock.Add(24 * time.Hour)
+	if n, err := d.Deliver(); n != 0 || err != nil || len(fake.Sent()) != 2 {
+		t.Errorf("second run delivered %d, %v; sent %d in all", n, err, len(fake.Sent()))
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 4 * time.Minute},
+		{3, 16 * time.Minute},
+		{4, 64 * time.Minute},
+		{5, 256 * time.Minute},
+		{6, maxRetry},
+		{20, maxRetry},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.attempts); got != tt.want {
+			t.Errorf("retryAfter(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// A failed send waits out its backoff, however often the dispatcher runs,
+// and goes out once the provider recovers.
+func TestRetry(t *testing.T) {
+	clock := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	fake := &Fake{Fail: errors.New("provider down")}
+	d, outbox := testDispatcher(fake, &clock)
+	if err := d.Enqueue("whatsapp:+263771111111", "en", "L0001", "Approved"); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 1; attempt <= 3; attempt++ {
+		if n, err := d.Deliver(); n != 0 || err != nil {
+			t.Fatalf("attempt %d: delivered %d, %v", attempt, n, err)
+		}
+		n := outbox.last()
+		wait := retryAfter(attempt)
+		if n.Attempts != attempt || n.Status != bot.NotificationPending || !n.NextAt.Equal(clock.Add(wait)) || n.LastError != "provider down" {
+			t.Fatalf("attempt %d recorded %+v, want a retry after %s", attempt, n, wait)
+		}
+		saves := len(outbox.saved)
+		clock = clock.Add(wait - time.Second)
+		if _, err := d.Deliver(); err != nil || len(outbox.saved) != saves {
+			t.Fatalf("attempt %d: retried a second early (%v)", attempt, err)
+		}
+		clock = clock.Add(time.Second)
+	}
+	fake.Fail = nil
+	if n, err := d.Deliver(); n != 1 || err != nil {
+		t.Fatalf("delivered %d, %v after recovery", n, err)
+	}
+	if n := outbox.last(); n.Status != bot.NotificationSent || n.Attempts != 4 || n.LastError != "" {
+		t.Errorf("recorded %+v", n)
+	}
+}
+
+// A notification claimed by a dispatcher that never reports back is left
+// alone for the lease, then picked up by another.
+func TestLeaseExpiry(t *testing.T) {
+	clock := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	fake := &Fake{}
+	d, outbox := testDispatcher(fake, &clock)
+	if err := d.Enqueue("whatsapp:+263771111111", "en", "L0001", "Approved"); err != nil {
+		t.Fatal(err)
+	}
+	// another instance claims it and is frozen before sending
+	if claimed, err := outbox.Claim(clock, lease, batch); err != nil || len(claimed) != 1 {
+		t.Fatalf("claimed %v, %v", claimed, err)
+	}
+	clock = clock.Add(lease - time.Second)
+	if n, err := d.Deliver(); n != 0 || err != nil || len(fake.Sent()) != 0 {
+		t.Fatalf("delivered %d, %v during the lease", n, err)
+	}
+	clock = clock.Add(time.Second)
+	if n, err := d.Deliver(); n != 1 || err != nil || len(fake.Sent()) != 1 {
+		t.Fatalf("delivered %d, %v after the lease", n, err)
+	}
+}
+
+func TestGiveUp(t *testing.T) {
+	clock := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	fake := &Fake{Fail: errors.New("outside the 24 hour window")}
+	d, outbox := testDispatcher(fake, &clock)
+	d.MaxAttempts = 3
+	if err := d.Enqueue("whatsapp:+263771111111", "en", "L0001", "Approved"); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 1; attempt <= 3; attempt++ {
+		if _, err := d.Deliver(); err != nil {
+			t.Fatal(err)
+		}
+		clock = clock.Add(maxRetry)
+	}
+	n := outbox.last()
+	if n.Status != bot.NotificationFailed || n.Attempts != 3 || n.LastError != "outside the 24 hour window" {
+		t.Fatalf("recorded %+v, want failed after 3 attempts", n)
+	}
+	fake.Fail = nil
+	saves := len(outbox.saved)
+	clock = clock.Add(7 * 24 * time.Hour)
+	if sent, err := d.Deliver(); sent != 0 || err != nil || len(outbox.saved) != saves || len(fake.Sent()) != 0 {
+		t.Errorf("failed notification tried again: delivered %d, %v", sent, err)
+	}
+}
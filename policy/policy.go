@@ -0,0 +1,362 @@
+// Package policy sets a loan's limit and term from the approvals it has
+// gathered. The rules are data: a versioned JSON file that can vary by region
+// and is re-read when it changes, so tiers, caps and terms can be tuned
+// without a redeploy.
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xetkloset/demo/money"
+)
+
+// ErrInvalid is returned for a policy file that can't be used.
+var ErrInvalid = errors.New("policy: invalid policy")
+
+// Tier is the limit and term a loan gets once its quorum is met and at least
+// Elders elders have approved it.
+type Tier struct {
+	Elders     int
+	Limit      money.Money
+	TermMonths int
+}
+
+// Quorum is who has to approve a loan before money can be drawn, and whose
+// decline ends it.
+type Quorum struct {
+	Mufundisi     bool     // the mufundisi must approve
+	Elders        int      // elders who must approve
+	Veto          []string // roles whose single decline declines the loan
+	ElderDeclines int      // elder declines that decline the loan; 0 never does
+}
+
+// Met reports whether the approvals gathered satisfy the quorum.
+func (q Quorum) Met(mufundisi bool, elders int) bool {
+	return (mufundisi || !q.Mufundisi) && elders >= q.Elders
+}
+
+// Vetoes reports whether a decline from role declines the loan outright.
+func (q Quorum) Vetoes(role string) bool {
+	for _, r := range q.Veto {
+		if strings.EqualFold(r, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules price loans in one region.
+type Rules struct {
+	Quorum             Quorum
+	Tiers              []Tier // ascending by Elders
+	PerRecommendation  money.Money
+	MaxRecommendations int
+	Cap                money.Money
+
+	// Underwriting limits (see package underwrite); zero turns a rule off.
+	MinActivity  int         // wallet transactions below which NewWalletCap applies
+	NewWalletCap money.Money // most a little-used wallet can get
+	MaxExposure  money.Money // most an applicant may owe or have undrawn across all loans
+}
+
+// Policy is one version of the limit rules. Regions not listed use Default.
+type Policy struct {
+	Version  string
+	Currency string
+	Default  Rules
+	Regions  map[string]Rules
+}
+
+// Builtin is the policy used when no policy file is configured.
+var Builtin = &Policy{
+	Version:  "builtin",
+	Currency: money.USD,
+	Default: Rules{
+		Quorum: Quorum{Mufundisi: true, Veto: []string{"mufundisi"}, ElderDeclines: 2},
+		Tiers: []Tier{
+			{Elders: 0, Limit: money.FromMajor(300, money.USD), TermMonths: 6},
+			{Elders: 1, Limit: money.FromMajor(500, money.USD), TermMonths: 6},
+			{Elders: 2, Limit: money.FromMajor(800, money.USD), TermMonths: 9},
+		},
+		PerRecommendation:  money.FromMajor(100, money.USD),
+		MaxRecommendations: 2,
+		Cap:                money.FromMajor(1000, money.USD),
+		MinActivity:        3,
+		NewWalletCap:       money.FromMajor(200, money.USD),
+		MaxExposure:        money.FromMajor(1000, money.USD),
+	},
+}
+
+// Approvals is what a loan has gathered so far.
+type Approvals struct {
+	Region          string
+	Mufundisi       bool
+	Elders          int
+	Recommendations int // distinct recommenders
+}
+
+// Result is the limit a policy gives a set of approvals, with the parts it
+// was built from.
+type Result struct {
+	Version         string
+	Limit           money.Money
+	TermMonths      int
+	Tier            *Tier       // nil until the quorum is met
+	Recommendations int         // recommendations that counted
+	Bonus           money.Money // added for recommendations
+	Capped          bool        // the cap cut the limit down
+}
+
+// Rules returns the rules for region.
+func (p *Policy) Rules(region string) Rules {
+	for name, r := range p.Regions {
+		if strings.EqualFold(name, region) {
+			return r
+		}
+	}
+	return p.Default
+}
+
+// Evaluate works out the limit and term for a. A tier applies once the
+// region's quorum is met, whether or not that quorum asks for the mufundisi.
+func (p *Policy) Evaluate(a Approvals) Result {
+	r := p.Rules(a.Region)
+	res := Result{Version: p.Version, Limit: money.Zero(p.Currency), Bonus: money.Zero(p.Currency)}
+	if r.Quorum.Met(a.Mufundisi, a.Elders) {
+		for i := range r.Tiers {
+			if a.Elders >= r.Tiers[i].Elders {
+				res.Tier = &r.Tiers[i]
+			}
+		}
+	}
+	if res.Tier != nil {
+		res.Limit = res.Tier.Limit
+		res.TermMonths = res.Tier.TermMonths
+	}
+	res.Recommendations = min(a.Recommendations, r.MaxRecommendations)
+	res.Bonus = r.PerRecommendation.Mul(int64(res.Recommendations))
+	res.Limit = res.Limit.Add(res.Bonus)
+	if res.Limit.Cmp(r.Cap) > 0 {
+		res.Limit, res.Capped = r.Cap, true
+	}
+	return res
+}
+
+// Explain describes how r was reached, for the dry-run evaluator and logs.
+func (r Result) Explain() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "policy %s\n", r.Version)
+	if r.Tier == nil {
+		b.WriteString("no tier: the quorum is not met\n")
+	} else {
+		fmt.Fprintf(&b, "tier for %d+ elders: %s over %d months\n", r.Tier.Elders, r.Tier.Limit, r.Tier.TermMonths)
+	}
+	fmt.Fprintf(&b, "%d recommendations counted: +%s\n", r.Recommendations, r.Bonus)
+	if r.Capped {
+		b.WriteString("capped\n")
+	}
+	fmt.Fprintf(&b, "limit %s, term %d months\n", r.Limit, r.TermMonths)
+	return b.String()
+}
+
+// file is the JSON layout of a policy file. Amounts are decimal strings in
+// the policy's currency, e.g. "300" or "250.50"; the currency may only be
+// USD, the wallets' currency.
+type file struct {
+	Version  string               `json:"version"`
+	Currency string               `json:"currency"`
+	Default  rulesFile            `json:"default"`
+	Regions  map[string]rulesFile `json:"regions"`
+}
+
+type rulesFile struct {
+	Quorum *struct {
+		Mufundisi     bool     `json:"mufundisi"`
+		Elders        int      `json:"elders"`
+		Veto          []string `json:"veto"`
+		ElderDeclines int      `json:"elder_declines"`
+	} `json:"quorum"`
+	Tiers []struct {
+		Elders     int    `json:"elders"`
+		Limit      string `json:"limit"`
+		TermMonths int    `json:"term_months"`
+	} `json:"tiers"`
+	PerRecommendation  string `json:"per_recommendation"`
+	MaxRecommendations int    `json:"max_recommendations"`
+	Cap                string `json:"cap"`
+	MinActivity        int    `json:"min_activity"`
+	NewWalletCap       string `json:"new_wallet_cap"`
+	MaxExposure        string `json:"max_exposure"`
+}
+
+// Parse reads and validates a policy file.
+func Parse(data []byte) (*Policy, error) {
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if strings.TrimSpace(f.Version) == "" {
+		return nil, fmt.Errorf("%w: no version", ErrInvalid)
+	}
+	// Wallets and loans are all kept in dollars, so a limit in anything else
+	// couldn't be compared with what is asked for or owed.
+	if f.Currency == "" {
+		f.Currency = money.USD
+	}
+	if f.Currency != money.USD {
+		return nil, fmt.Errorf("%w: currency %s, wallets are kept in %s", ErrInvalid, f.Currency, money.USD)
+	}
+	p := &Policy{Version: f.Version, Currency: f.Currency, Regions: map[string]Rules{}}
+	var err error
+	if p.Default, err = f.Default.compile(f.Currency); err != nil {
+		return nil, fmt.Errorf("%w: default: %v", ErrInvalid, err)
+	}
+	for name, rf := range f.Regions {
+		if strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("%w: unnamed region", ErrInvalid)
+		}
+		if p.Regions[name], err = rf.compile(f.Currency); err != nil {
+			return nil, fmt.Errorf("%w: region %s: %v", ErrInvalid, name, err)
+		}
+	}
+	return p, nil
+}
+
+func (rf rulesFile) compile(cur string) (Rules, error) {
+	var r Rules
+	if rf.Quorum == nil {
+		return r, errors.New("no quorum")
+	}
+	r.Quorum = Quorum(*rf.Quorum)
+	if r.Quorum.Elders < 0 || r.Quorum.ElderDeclines < 0 {
+		return r, errors.New("quorum counts are negative")
+	}
+	if !r.Quorum.Mufundisi && r.Quorum.Elders == 0 {
+		return r, errors.New("quorum needs the mufundisi or at least one elder")
+	}
+	if len(rf.Tiers) == 0 {
+		return r, errors.New("no tiers")
+	}
+	for _, t := range rf.Tiers {
+		limit, err := money.Parse(t.Limit, cur)
+		if err != nil {
+			return r, fmt.Errorf("tier for %d elders: %v", t.Elders, err)
+		}
+		if t.Elders < 0 || t.TermMonths <= 0 {
+			return r, fmt.Errorf("tier for %d elders: needs 0 or more elders and a term of at least a month", t.Elders)
+		}
+		r.Tiers = append(r.Tiers, Tier{Elders: t.Elders, Limit: limit, TermMonths: t.TermMonths})
+	}
+	sort.Slice(r.Tiers, func(i, j int) bool { return r.Tiers[i].Elders < r.Tiers[j].Elders })
+	for i := 1; i < len(r.Tiers); i++ {
+		if r.Tiers[i].Elders == r.Tiers[i-1].Elders {
+			return r, fmt.Errorf("two tiers for %d elders", r.Tiers[i].Elders)
+		}
+	}
+	// a loan is approved as soon as the quorum is met, so it must have a
+	// tier by then or it would be approved for nothing
+	if r.Tiers[0].Elders > r.Quorum.Elders {
+		return r, fmt.Errorf("no tier for a loan the quorum approves with %d elders", r.Quorum.Elders)
+	}
+	var err error
+	if r.PerRecommendation, err = amount(rf.PerRecommendation, cur); err != nil {
+		return r, fmt.Errorf("per_recommendation: %v", err)
+	}
+	if rf.MaxRecommendations < 0 {
+		return r, errors.New("max_recommendations is negative")
+	}
+	r.MaxRecommendations = rf.MaxRecommendations
+	if r.Cap, err = money.Parse(rf.Cap, cur); err != nil {
+		return r, fmt.Errorf("cap: %v", err)
+	}
+	if rf.MinActivity < 0 {
+		return r, errors.New("min_activity is negative")
+	}
+	r.MinActivity = rf.MinActivity
+	if r.NewWalletCap, err = amount(rf.NewWalletCap, cur); err != nil {
+		return r, fmt.Errorf("new_wallet_cap: %v", err)
+	}
+	if r.MaxExposure, err = amount(rf.MaxExposure, cur); err != nil {
+		return r, fmt.Errorf("max_exposure: %v", err)
+	}
+	return r, nil
+}
+
+// amount parses an amount that may also be zero (written "0" or left out).
+func amount(s, cur string) (money.Money, error) {
+	if s = strings.TrimSpace(s); s == "" || strings.Trim(s, "0.") == "" {
+		return money.Zero(cur), nil
+	}
+	return money.Parse(s, cur)
+}
+
+// Source hands out the current policy, re-reading its file whenever the file
+// changes.
+type Source struct {
+	path string
+
+	mu      sync.Mutex
+	policy  *Policy
+	modTime time.Time
+	size    int64
+}
+
+// Static is a Source that always returns p.
+func Static(p *Policy) *Source {
+	return &Source{policy: p}
+}
+
+// Open loads the policy file at path. A broken file fails here, at startup,
+// rather than on the first loan.
+func Open(path string) (*Source, error) {
+	s := &Source{path: path}
+	if _, err := s.Current(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// FromEnv opens the policy file named by WALLETBOT_POLICY_FILE, or returns
+// the built-in policy when it isn't set.
+func FromEnv() (*Source, error) {
+	path := os.Getenv("WALLETBOT_POLICY_FILE")
+	if path == "" {
+		return Static(Builtin), nil
+	}
+	return Open(path)
+}
+
+// Current returns the policy in force. If the file has changed but can no
+// longer be read or parsed, the last good policy is returned along with the
+// error, so a bad edit doesn't stop loans being priced.
+func (s *Source) Current() (*Policy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" {
+		return s.policy, nil
+	}
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return s.policy, err
+	}
+	if s.policy != nil && fi.ModTime().Equal(s.modTime) && fi.Size() == s.size {
+		return s.policy, nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return s.policy, err
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return s.policy, fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.policy, s.modTime, s.size = p, fi.ModTime(), fi.Size()
+	return p, nil
+}
@@ -0,0 +1,116 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/xetkloset/demo/money"
+)
+
+func usd(units int64) money.Money { return money.FromMajor(units, money.USD) }
+
+// elderOnly approves with two elders and no mufundisi.
+const elderOnly = `{
+  "version": "elders",
+  "default": {
+    "quorum": {"mufundisi": false, "elders": 2, "elder_declines": 1},
+    "tiers": [
+      {"elders": 2, "limit": "400", "term_months": 6},
+      {"elders": 3, "limit": "600", "term_months": 9}
+    ],
+    "per_recommendation": "50",
+    "max_recommendations": 2,
+    "cap": "650"
+  }
+}`
+
+func TestEvaluate(t *testing.T) {
+	elders, err := Parse([]byte(elderOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		p      *Policy
+		a      Approvals
+		tier   int // elders of the tier applied, -1 for none
+		limit  money.Money
+		months int
+		capped bool
+	}{
+		{"nothing yet", Builtin, Approvals{}, -1, usd(0), 0, false},
+		{"recommendations only", Builtin, Approvals{Recommendations: 5}, -1, usd(200), 0, false},
+		{"elders without the mufundisi", Builtin, Approvals{Elders: 2}, -1, usd(0), 0, false},
+		{"mufundisi", Builtin, Approvals{Mufundisi: true}, 0, usd(300), 6, false},
+		{"mufundisi and an elder", Builtin, Approvals{Mufundisi: true, Elders: 1, Recommendations: 1}, 1, usd(600), 6, false},
+		{"top tier, at the cap", Builtin, Approvals{Mufundisi: true, Elders: 4, Recommendations: 3}, 2, usd(1000), 9, false},
+		{"elder-only quorum not met", elders, Approvals{Elders: 1, Recommendations: 1}, -1, usd(50), 0, false},
+		{"elder-only quorum met", elders, Approvals{Elders: 2}, 2, usd(400), 6, false},
+		{"elder-only quorum, higher tier", elders, Approvals{Elders: 3, Recommendations: 1}, 3, usd(650), 9, false},
+		{"capped", elders, Approvals{Elders: 3, Recommendations: 2}, 3, usd(650), 9, true},
+		{"mufundisi alone under elder-only quorum", elders, Approvals{Mufundisi: true}, -1, usd(0), 0, false},
+	}
+	for _, tt := range tests {
+		res := tt.p.Evaluate(tt.a)
+		tier := -1
+		if res.Tier != nil {
+			tier = res.Tier.Elders
+		}
+		if tier != tt.tier || res.Limit != tt.limit || res.TermMonths != tt.months || res.Capped != tt.capped {
+			t.Errorf("%s: tier %d, %s over %d months, capped %v; want tier %d, %s over %d months, capped %v",
+				tt.name, tier, res.Limit, res.TermMonths, res.Capped, tt.tier, tt.limit, tt.months, tt.capped)
+		}
+		// a loan whose quorum is met must never be approved for nothing
+		if tt.p.Rules(tt.a.Region).Quorum.Met(tt.a.Mufundisi, tt.a.Elders) && !res.Limit.IsPositive() {
+			t.Errorf("%s: quorum met with a limit of %s", tt.name, res.Limit)
+		}
+	}
+}
+
+func TestParseExample(t *testing.T) {
+	data, err := os.ReadFile("example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := p.Rules("nyika"); r.Quorum.Elders != 1 || r.Cap != usd(1000) {
+		t.Errorf("Nyika rules %+v", r)
+	}
+	if r := p.Rules("Elsewhere"); r.MaxExposure != usd(1000) {
+		t.Errorf("default rules %+v", r)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	rules := func(quorum, tiers string) string {
+		return `{"version": "v", "default": {"quorum": ` + quorum + `, "tiers": ` + tiers + `, "cap": "1000"}}`
+	}
+	tier0 := `[{"elders": 0, "limit": "300", "term_months": 6}]`
+	tests := []struct {
+		name, file, want string
+	}{
+		{"not JSON", `{`, "unexpected end"},
+		{"no version", `{"default": {}}`, "no version"},
+		{"other currency", `{"version": "v", "currency": "ZAR"}`, "currency ZAR"},
+		{"no quorum", `{"version": "v", "default": {"tiers": ` + tier0 + `, "cap": "1000"}}`, "no quorum"},
+		{"empty quorum", rules(`{"mufundisi": false, "elders": 0}`, tier0), "needs the mufundisi or at least one elder"},
+		{"negative quorum", rules(`{"mufundisi": true, "elders": -1}`, tier0), "negative"},
+		{"no tiers", rules(`{"mufundisi": true}`, `[]`), "no tiers"},
+		{"no tier at the quorum", rules(`{"elders": 2}`, `[{"elders": 3, "limit": "300", "term_months": 6}]`), "no tier for a loan the quorum approves with 2 elders"},
+		{"duplicate tiers", rules(`{"mufundisi": true}`, `[{"elders": 0, "limit": "300", "term_months": 6}, {"elders": 0, "limit": "400", "term_months": 6}]`), "two tiers for 0 elders"},
+		{"no term", rules(`{"mufundisi": true}`, `[{"elders": 0, "limit": "300", "term_months": 0}]`), "term of at least a month"},
+		{"bad limit", rules(`{"mufundisi": true}`, `[{"elders": 0, "limit": "lots", "term_months": 6}]`), "tier for 0 elders"},
+		{"bad region", `{"version": "v", "default": {"quorum": {"mufundisi": true}, "tiers": ` + tier0 + `, "cap": "1000"}, "regions": {"Nyika": {}}}`, "region Nyika: no quorum"},
+	}
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.file))
+		if !errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
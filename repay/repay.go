@@ -0,0 +1,211 @@
+// Package repay builds loan installment schedules and applies repayments to
+// them. Amounts are exact money; rounding leftovers go to the last
+// installment so a schedule always adds up to what was drawn.
+package repay
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/xetkloset/demo/money"
+)
+
+// ErrTerms is returned for a schedule that can't be built.
+var ErrTerms = errors.New("repay: invalid terms")
+
+// Method is how interest is charged.
+type Method string
+
+const (
+	// Flat charges interest on the amount drawn for the whole term.
+	Flat Method = "flat"
+	// Declining charges interest on the principal still outstanding each
+	// month, with equal (amortized) installments.
+	Declining Method = "declining"
+)
+
+// Terms price a loan. MonthlyRateBP is the monthly interest rate in basis
+// points: 200 is 2% a month.
+type Terms struct {
+	Method        Method
+	MonthlyRateBP int64
+}
+
+// Valid reports whether the terms can price a schedule.
+func (t Terms) Valid() bool {
+	return (t.Method == Flat || t.Method == Declining) && t.MonthlyRateBP >= 0
+}
+
+// Installment is one monthly payment of a schedule.
+type Installment struct {
+	Due           time.Time
+	Principal     money.Money
+	Interest      money.Money
+	PaidPrincipal money.Money
+	PaidInterest  money.Money
+}
+
+// Owed is what is left to pay on the installment.
+func (i Installment) Owed() money.Money {
+	return i.Principal.Sub(i.PaidPrincipal).Add(i.Interest.Sub(i.PaidInterest))
+}
+
+// Settled reports whether the installment is fully paid.
+func (i Installment) Settled() bool { return !i.Owed().IsPositive() }
+
+// Schedule splits principal drawn at start into months installments, the
+// first due a month after start.
+func Schedule(t Terms, principal money.Money, months int, start time.Time) ([]Installment, error) {
+	if !t.Valid() || months <= 0 || !principal.IsPositive() {
+		return nil, ErrTerms
+	}
+	out := make([]Installment, months)
+	for i := range out {
+		out[i].Due = addMonths(start, i+1)
+		out[i].PaidPrincipal = money.Zero(principal.Currency)
+		out[i].PaidInterest = money.Zero(principal.Currency)
+	}
+	n := int64(months)
+	switch t.Method {
+	case Flat:
+		interest := percent(principal.Minor*n, t.MonthlyRateBP)
+		for i := range out {
+			out[i].Principal = share(principal.Minor, n, i, principal.Currency)
+			out[i].Interest = share(interest, n, i, principal.Currency)
+		}
+	case Declining:
+		payment := annuity(principal.Minor, t.MonthlyRateBP, months)
+		balance := principal.Minor
+		for i := range out {
+			interest := percent(balance, t.MonthlyRateBP)
+			p := payment - interest
+			if i == months-1 || p > balance {
+				p = balance
+			}
+			balance -= p
+			out[i].Principal = money.New(p, principal.Currency)
+			out[i].Interest = money.New(interest, principal.Currency)
+		}
+	}
+	return out, nil
+}
+
+// addMonths moves t n months on, keeping its day of the month where the
+// target month has it and using the month's last day otherwise (31 Jan + 1
+// month is 28 or 29 Feb).
+func addMonths(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// percent is minor*bp/10000 rounded half up.
+func percent(minor, bp int64) int64 {
+	return (minor*bp + 5000) / 10000
+}
+
+// share is installment i's part of total split evenly over n, the last one
+// taking the remainder.
+func share(total, n int64, i int, cur string) money.Money {
+	part := total / n
+	if int64(i) == n-1 {
+		part = total - part*(n-1)
+	}
+	return money.New(part, cur)
+}
+
+// annuity is the level monthly payment repaying principal over months at
+// bp a month, rounded to the nearest minor unit.
+func annuity(principal, bp int64, months int) int64 {
+	if bp == 0 {
+		return (principal + int64(months) - 1) / int64(months)
+	}
+	r := float64(bp) / 10000
+	return int64(math.Round(float64(principal) * r / (1 - math.Pow(1+r, -float64(months)))))
+}
+
+// Apply pays amt into the schedule, oldest installment first and interest
+// before principal, and returns how much went to each. Anything beyond what
+// is owed is left unapplied.
+func Apply(sched []Installment, amt money.Money) (principal, interest money.Money) {
+	principal, interest = money.Zero(amt.Currency), money.Zero(amt.Currency)
+	left := amt
+	for i := range sched {
+		in := &sched[i]
+		if pay := in.Interest.Sub(in.PaidInterest).Min(left); pay.IsPositive() {
+			in.PaidInterest = in.PaidInterest.Add(pay)
+			interest = interest.Add(pay)
+			left = left.Sub(pay)
+		}
+		if pay := in.Principal.Sub(in.PaidPrincipal).Min(left); pay.IsPositive() {
+			in.PaidPrincipal = in.PaidPrincipal.Add(pay)
+			principal = principal.Add(pay)
+			left = left.Sub(pay)
+		}
+		if !left.IsPositive() {
+			break
+		}
+	}
+	return principal, interest
+}
+
+// Owed is everything left to pay on the schedule.
+func Owed(sched []Installment) money.Money {
+	total := zero(sched)
+	for _, in := range sched {
+		total = total.Add(in.Owed())
+	}
+	return total
+}
+
+// OutstandingPrincipal is the principal not yet repaid.
+func OutstandingPrincipal(sched []Installment) money.Money {
+	total := zero(sched)
+	for _, in := range sched {
+		total = total.Add(in.Principal.Sub(in.PaidPrincipal))
+	}
+	return total
+}
+
+// Arrears is what should have been paid before now but wasn't.
+func Arrears(sched []Installment, now time.Time) money.Money {
+	total := zero(sched)
+	for _, in := range sched {
+		if in.Due.Before(now) {
+			total = total.Add(in.Owed())
+		}
+	}
+	return total
+}
+
+// zero is nothing in the schedule's currency.
+func zero(sched []Installment) money.Money {
+	if len(sched) == 0 {
+		return money.Money{}
+	}
+	return money.Zero(sched[0].Principal.Currency)
+}
+
+// NextDue returns the earliest installment not yet settled.
+func NextDue(sched []Installment) (Installment, bool) {
+	for _, in := range sched {
+		if !in.Settled() {
+			return in, true
+		}
+	}
+	return Installment{}, false
+}
+
+// Merge adds a new draw's installments to a schedule, keeping it in due
+// order.
+func Merge(sched, more []Installment) []Installment {
+	out := append(append([]Installment(nil), sched...), more...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Due.Before(out[j].Due) })
+	return out
+}
@@ -0,0 +1,114 @@
+package repay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xetkloset/demo/money"
+)
+
+func usd(cents int64) money.Money { return money.New(cents, money.USD) }
+
+func date(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 9, 0, 0, 0, time.UTC) }
+
+func TestScheduleAmounts(t *testing.T) {
+	tests := []struct {
+		name       string
+		terms      Terms
+		principal  int64
+		months     int
+		principals []int64
+		interests  []int64
+	}{
+		{"flat splits evenly, last takes the rest", Terms{Flat, 200}, 10000, 3,
+			[]int64{3333, 3333, 3334}, []int64{200, 200, 200}},
+		{"flat leaves odd cents to the last", Terms{Flat, 125}, 1001, 2,
+			[]int64{500, 501}, []int64{12, 13}},
+		{"flat without interest", Terms{Flat, 0}, 100, 3,
+			[]int64{33, 33, 34}, []int64{0, 0, 0}},
+		{"declining amortizes", Terms{Declining, 200}, 10000, 3,
+			[]int64{3268, 3333, 3399}, []int64{200, 135, 68}},
+		{"declining without interest", Terms{Declining, 0}, 100000, 3,
+			[]int64{33334, 33334, 33332}, []int64{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sched, err := Schedule(tt.terms, usd(tt.principal), tt.months, date(2026, 1, 10))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sched) != tt.months {
+				t.Fatalf("%d installments, want %d", len(sched), tt.months)
+			}
+			for i, in := range sched {
+				if in.Principal.Minor != tt.principals[i] || in.Interest.Minor != tt.interests[i] {
+					t.Errorf("installment %d = %v + %v, want %d + %d cents", i+1, in.Principal, in.Interest, tt.principals[i], tt.interests[i])
+				}
+			}
+			if got := OutstandingPrincipal(sched); got.Minor != tt.principal {
+				t.Errorf("principal adds up to %v, want %d cents", got, tt.principal)
+			}
+		})
+	}
+}
+
+func TestScheduleDueDates(t *testing.T) {
+	tests := []struct {
+		start time.Time
+		want  []time.Time
+	}{
+		{date(2026, 1, 10), []time.Time{date(2026, 2, 10), date(2026, 3, 10)}},
+		{date(2026, 1, 31), []time.Time{date(2026, 2, 28), date(2026, 3, 31), date(2026, 4, 30)}},
+		{date(2028, 1, 31), []time.Time{date(2028, 2, 29)}},
+		{date(2026, 8, 31), []time.Time{date(2026, 9, 30), date(2026, 10, 31)}},
+		{date(2026, 12, 15), []time.Time{date(2027, 1, 15)}},
+	}
+	for _, tt := range tests {
+		sched, err := Schedule(Terms{Flat, 0}, usd(10000), len(tt.want), tt.start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, in := range sched {
+			if !in.Due.Equal(tt.want[i]) {
+				t.Errorf("from %s, installment %d due %s, want %s", tt.start.Format("2 Jan 2006"), i+1, in.Due.Format("2 Jan 2006"), tt.want[i].Format("2 Jan 2006"))
+			}
+		}
+	}
+}
+
+func TestScheduleRejectsBadTerms(t *testing.T) {
+	start := date(2026, 1, 1)
+	for name, err := range map[string]error{
+		"no months":      func() error { _, err := Schedule(Terms{Flat, 100}, usd(100), 0, start); return err }(),
+		"nothing drawn":  func() error { _, err := Schedule(Terms{Flat, 100}, usd(0), 3, start); return err }(),
+		"unknown method": func() error { _, err := Schedule(Terms{"balloon", 100}, usd(100), 3, start); return err }(),
+		"negative rate":  func() error { _, err := Schedule(Terms{Flat, -1}, usd(100), 3, start); return err }(),
+	} {
+		if err != ErrTerms {
+			t.Errorf("%s: error = %v, want %v", name, err, ErrTerms)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	sched, err := Schedule(Terms{Flat, 200}, usd(10000), 3, date(2026, 1, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		pay                 int64
+		principal, interest int64
+		owed                int64
+	}{
+		{100, 0, 100, 10500},    // interest first
+		{3500, 3333, 167, 7000}, // rest of the first installment, then the next one's interest
+		{10000, 6667, 333, 0},   // everything else; the surplus is left unapplied
+	}
+	for _, tt := range tests {
+		p, i := Apply(sched, usd(tt.pay))
+		if p.Minor != tt.principal || i.Minor != tt.interest || Owed(sched).Minor != tt.owed {
+			t.Errorf("paying %d: %v principal, %v interest, %v owed; want %d, %d, %d",
+				tt.pay, p, i, Owed(sched), tt.principal, tt.interest, tt.owed)
+		}
+	}
+}
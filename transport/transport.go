@@ -0,0 +1,166 @@
+// Package transport is what the conversation core and the messaging channels
+// agree on: a channel adapter turns its webhook into a Message, runs it
+// through a Conversation and delivers the Reply in whatever form the channel
+// speaks (TwiML, Cloud API calls, ...).
+package transport
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Channels messages arrive on.
+const (
+	ChannelWhatsApp = "whatsapp"
+	ChannelUSSD     = "ussd"
+)
+
+// Message is one inbound user message. From is the member's identity in
+// "whatsapp:+<digits>" form whatever channel it came in on, so every channel
+// reaches the same wallet and session.
+type Message struct {
+	From    string
+	Body    string
+	Channel string
+	// Start marks the first message of a channel session, such as a USSD
+	// dial: the conversation starts over from the PIN rather than carrying
+	// on where the member left it.
+	Start bool
+}
+
+// Reply is the conversation's answer, in the recipient's language.
+type Reply struct {
+	Text     string
+	Language string
+	// Done is set when the member has signed out; channels with sessions of
+	// their own end them.
+	Done bool
+}
+
+// Conversation answers one message. An error means nothing was stored and
+// the channel should report a temporary failure.
+type Conversation func(Message) (Reply, error)
+
+// Option is one numbered menu choice. ID is what the user would type to pick
+// it.
+type Option struct {
+	ID    string
+	Title string
+}
+
+// keycap follows a digit to make the "1️⃣" emoji menus are numbered with.
+const keycap = "️⃣"
+
+// Menu splits a reply into its "1️⃣ Send Money" style choices and the rest of
+// the text, so channels with buttons or lists can show the choices natively.
+// Text without such lines comes back whole with no options.
+func Menu(text string) (body string, options []Option) {
+	var rest []string
+	for _, line := range strings.Split(text, "\n") {
+		if o, ok := Choice(line); ok {
+			options = append(options, o)
+			continue
+		}
+		t := strings.TrimSpace(line)
+		if t == "" && len(rest) > 0 && strings.TrimSpace(rest[len(rest)-1]) == "" {
+			continue // where the menu was cut out
+		}
+		rest = append(rest, line)
+	}
+	if len(options) == 0 {
+		return text, nil
+	}
+	return strings.TrimSpace(strings.Join(rest, "\n")), options
+}
+
+// keycapTen is the single emoji for 10.
+const keycapTen = "🔟"
+
+// Choice reads one "1️⃣ Send Money" menu line. Numbers past 9 may be written
+// "1️⃣0️⃣", "10️⃣" or "🔟"; the number must end in a keycap.
+func Choice(line string) (Option, bool) {
+	t := strings.TrimSpace(line)
+	id, capped := "", false
+	for {
+		if strings.HasPrefix(t, keycapTen) {
+			id, t, capped = id+"10", t[len(keycapTen):], true
+			continue
+		}
+		if t == "" || t[0] < '0' || t[0] > '9' {
+			break
+		}
+		id, t, capped = id+t[:1], t[1:], false
+		if strings.HasPrefix(t, keycap) {
+			t, capped = t[len(keycap):], true
+		}
+	}
+	if title := strings.TrimSpace(t); capped && title != "" {
+		return Option{ID: id, Title: title}, true
+	}
+	return Option{}, false
+}
+
+// Truncate shortens s to at most n characters, marking the cut with "…".
+func Truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:n-1]) + "…"
+}
+
+// Replays remembers the IDs of handled messages, so a captured, validly
+// signed request can't be sent again and a webhook delivered twice is handled
+// once. First records id and reports whether it is new; Forget drops it again
+// so the redelivery of a message that failed is handled. An error means it
+// couldn't tell, and the message should be refused for now.
+type Replays interface {
+	First(id string) (bool, error)
+	Forget(id string) error
+}
+
+// ReplayGuard is Replays kept in memory for a while. It is per process: behind
+// several serverless instances each would keep its own and a request replayed
+// to another instance would get through, so deployments like that keep the
+// IDs in a store they share (see bot.Stores).
+type ReplayGuard struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	seen map[string]time.Time
+	now  func() time.Time
+}
+
+func NewReplayGuard(ttl time.Duration) *ReplayGuard {
+	return &ReplayGuard{ttl: ttl, seen: make(map[string]time.Time), now: time.Now}
+}
+
+// First records id and reports whether it hadn't been seen within the TTL.
+// An empty id is never accepted.
+func (g *ReplayGuard) First(id string) (bool, error) {
+	if id == "" {
+		return false, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	for k, t := range g.seen {
+		if now.Sub(t) > g.ttl {
+			delete(g.seen, k)
+		}
+	}
+	if _, dup := g.seen[id]; dup {
+		return false, nil
+	}
+	g.seen[id] = now
+	return true, nil
+}
+
+// Forget drops id so a redelivery of a message that failed is handled.
+func (g *ReplayGuard) Forget(id string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.seen, id)
+	return nil
+}
@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChoice(t *testing.T) {
+	tests := []struct {
+		line string
+		want Option
+		ok   bool
+	}{
+		{"1️⃣ Send Money", Option{"1", "Send Money"}, true},
+		{"  0️⃣ Back  ", Option{"0", "Back"}, true},
+		{"1️⃣0️⃣ Help", Option{"10", "Help"}, true},
+		{"10️⃣ Help", Option{"10", "Help"}, true},
+		{"🔟 Help", Option{"10", "Help"}, true},
+		{"1️⃣Tight", Option{"1", "Tight"}, true},
+		{"1. Send Money", Option{}, false},
+		{"1️⃣0 Help", Option{}, false}, // the number doesn't end in a keycap
+		{"1️⃣", Option{}, false},       // no title
+		{"Send 1️⃣ now", Option{}, false},
+		{"✅ Yes", Option{}, false},
+		{"", Option{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := Choice(tt.line)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Choice(%q) = %+v, %v; want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMenu(t *testing.T) {
+	tests := []struct {
+		name, text, body string
+		options          []Option
+	}{
+		{
+			"menu after text",
+			"Welcome, Ann.\n\n1️⃣ Balance\n2️⃣ Send Money\n0️⃣ Exit",
+			"Welcome, Ann.",
+			[]Option{{"1", "Balance"}, {"2", "Send Money"}, {"0", "Exit"}},
+		},
+		{
+			"text either side",
+			"Pick a loan:\n1️⃣ L0001\n2️⃣ L0002\n\nReply 0 to go back.",
+			"Pick a loan:\n\nReply 0 to go back.",
+			[]Option{{"1", "L0001"}, {"2", "L0002"}},
+		},
+		{
+			"no menu",
+			"Your balance is $5.00\n",
+			"Your balance is $5.00\n",
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		body, options := Menu(tt.text)
+		if body != tt.body || !reflect.DeepEqual(options, tt.options) {
+			t.Errorf("%s: got %q, %+v; want %q, %+v", tt.name, body, options, tt.body, tt.options)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package twilio
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/xetkloset/demo/transport"
+)
+
+// DefaultBaseURL is the REST API the client talks to unless told otherwise.
+const DefaultBaseURL = "https://api.twilio.com"
+
+// Client sends WhatsApp messages through the Messages REST API, for messages
+// that aren't a reply to a webhook.
+type Client struct {
+	AccountSID string
+	AuthToken  string
+	From       string       // the sender, "whatsapp:+<digits>"
+	BaseURL    string       // DefaultBaseURL when empty
+	HTTP       *http.Client // a client with a 10s timeout when nil
+}
+
+var defaultHTTP = &http.Client{Timeout: 10 * time.Second}
+
+// Send delivers reply to the WhatsApp ID to.
+func (c *Client) Send(to string, reply transport.Reply) error {
+	if c.AccountSID == "" || c.AuthToken == "" || c.From == "" {
+		return fmt.Errorf("twilio: account SID, auth token or sender not configured")
+	}
+	base := c.BaseURL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	form := url.Values{"From": {c.From}, "To": {to}, "Body": {reply.Text}}
+	endpoint := strings.TrimSuffix(base, "/") + "/2010-04-01/Accounts/" + url.PathEscape(c.AccountSID) + "/Messages.json"
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(c.AccountSID, c.AuthToken)
+	hc := c.HTTP
+	if hc == nil {
+		hc = defaultHTTP
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("twilio: send to %s: %s: %s", to, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package twilio
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+
+	"github.com/xetkloset/demo/transport"
+)
+
+// MessageResponse is a TwiML reply carrying one message.
+type MessageResponse struct {
+	XMLName xml.Name `xml:"Response"`
+	Message string   `xml:"Message"`
+}
+
+// TwiML serves Twilio's WhatsApp message webhook: the form post is run
+// through conv and the reply goes back in the response as TwiML. Wrap it in
+// a Validator.
+func TwiML(conv transport.Conversation) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from := r.FormValue("From")
+		reply, err := conv(transport.Message{From: from, Body: r.FormValue("Body"), Channel: transport.ChannelWhatsApp})
+		if err != nil {
+			log.Printf("twilio %s: %v", from, err)
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(MessageResponse{Message: reply.Text})
+	})
+}
@@ -0,0 +1,169 @@
+// Package twilio is the Twilio WhatsApp channel: it verifies that webhook
+// requests really come from Twilio and answers them with TwiML.
+//
+// Twilio signs every webhook with HMAC-SHA1 over the public URL followed by
+// the sorted POST parameters, keyed with the account's auth token, and sends
+// the result in X-Twilio-Signature. See
+// https://www.twilio.com/docs/usage/security#validating-requests
+package twilio
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/xetkloset/demo/transport"
+)
+
+// SignatureHeader carries the request signature.
+const SignatureHeader = "X-Twilio-Signature"
+
+// TestAuthToken is the stand-in auth token used in test mode, shared by the
+// validator and the local Signer (see cmd/twiliosim).
+const TestAuthToken = "walletbot-test-auth-token"
+
+// Signature computes the X-Twilio-Signature for a POST to fullURL.
+func Signature(authToken, fullURL string, params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(fullURL)
+	for _, k := range keys {
+		for _, v := range params[k] {
+			b.WriteString(k)
+			b.WriteString(v)
+		}
+	}
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Signer plays Twilio's side: it signs webhook requests the way Twilio does.
+type Signer struct {
+	AuthToken string
+}
+
+// NewRequest builds a signed form POST of params to fullURL.
+func (s Signer) NewRequest(fullURL string, params url.Values) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, fullURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(SignatureHeader, Signature(s.AuthToken, fullURL, params))
+	return req, nil
+}
+
+// Validator rejects webhook requests whose signature doesn't match, or that
+// repeat a MessageSid already handled.
+type Validator struct {
+	// AuthToken is the Twilio account auth token the signatures are keyed with.
+	AuthToken string
+	// URL is the public webhook URL exactly as configured in Twilio. When
+	// empty it is rebuilt from the request, which only works when no proxy
+	// rewrites the scheme, host or path.
+	URL string
+	// Replays remembers handled MessageSids; nil disables replay checks.
+	// Instances that don't share memory must share the store.
+	Replays transport.Replays
+}
+
+// FromEnv configures a Validator from TWILIO_AUTH_TOKEN and
+// TWILIO_WEBHOOK_URL, remembering MessageSids in replays. With
+// TWILIO_TEST_MODE=1 the auth token is TestAuthToken so requests from a local
+// Signer are accepted; since that token is public, test mode alongside a real
+// auth token is refused rather than quietly accepting requests anyone can
+// sign.
+func FromEnv(replays transport.Replays) (*Validator, error) {
+	v := &Validator{
+		AuthToken: os.Getenv("TWILIO_AUTH_TOKEN"),
+		URL:       os.Getenv("TWILIO_WEBHOOK_URL"),
+		Replays:   replays,
+	}
+	if os.Getenv("TWILIO_TEST_MODE") == "1" {
+		if v.AuthToken != "" {
+			return nil, errors.New("twilio: TWILIO_TEST_MODE=1 with TWILIO_AUTH_TOKEN set; unset one of them")
+		}
+		v.AuthToken = TestAuthToken
+	}
+	return v, nil
+}
+
+// Wrap returns next guarded by the validator: forged and replayed requests
+// get a 403 and never reach next. A message next fails on (a 5xx status) is
+// forgotten again, so Twilio's retry of it is handled rather than refused.
+func (v *Validator) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v.AuthToken == "" {
+			log.Printf("twilio: no auth token configured, rejecting webhook")
+			http.Error(w, "Webhook not configured", http.StatusInternalServerError)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		want := Signature(v.AuthToken, v.requestURL(r), r.PostForm)
+		if !hmac.Equal([]byte(want), []byte(r.Header.Get(SignatureHeader))) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		sid := r.PostForm.Get("MessageSid")
+		if v.Replays == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		first, err := v.Replays.First(sid)
+		if err != nil {
+			log.Printf("twilio: replay check %s: %v", sid, err)
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !first {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		if sw.status >= 500 {
+			if err := v.Replays.Forget(sid); err != nil {
+				log.Printf("twilio: forget %s: %v", sid, err)
+			}
+		}
+	})
+}
+
+// statusWriter notes the status a handler responds with.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (v *Validator) requestURL(r *http.Request) string {
+	if v.URL != "" {
+		return v.URL
+	}
+	scheme := "https"
+	if p := r.Header.Get("X-Forwarded-Proto"); p != "" {
+		scheme = p
+	} else if r.TLS == nil {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
@@ -0,0 +1,130 @@
+package twilio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/xetkloset/demo/bot"
+	"github.com/xetkloset/demo/transport"
+)
+
+// The examples from Twilio's webhook security docs and its Python helper
+// library.
+func TestSignature(t *testing.T) {
+	const u = "https://mycompany.com/myapp.php?foo=1&bar=2"
+	tests := []struct {
+		caller string
+		want   string
+	}{
+		{"+12349013030", "0/KCTR6DLpKmkAf8muzZqo1nDgQ="},
+		{"+14158675309", "RSOYDt4T1cUTdK1PDd93/VVr8B8="},
+	}
+	for _, tt := range tests {
+		params := url.Values{
+			"CallSid": {"CA1234567890ABCDE"},
+			"Caller":  {tt.caller},
+			"Digits":  {"1234"},
+			"From":    {tt.caller},
+			"To":      {"+18005551212"},
+		}
+		if got := Signature("12345", u, params); got != tt.want {
+			t.Errorf("Signature for caller %s = %s, want %s", tt.caller, got, tt.want)
+		}
+	}
+}
+
+func TestValidatorWrap(t *testing.T) {
+	const u = "https://bot.example.com/api/whatsapp"
+	status := http.StatusOK
+	calls := 0
+	h := (&Validator{AuthToken: "token", URL: u, Replays: transport.NewReplayGuard(time.Hour)}).Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(status)
+		}))
+	send := func(sid, token string) int {
+		params := url.Values{"MessageSid": {sid}, "From": {"whatsapp:+263771111111"}, "Body": {"hi"}}
+		req, err := Signer{AuthToken: token}.NewRequest(u, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name    string
+		sid     string
+		token   string
+		handler int // status the wrapped handler answers with
+		want    int
+		calls   int
+	}{
+		{"signed", "SM1", "token", http.StatusOK, http.StatusOK, 1},
+		{"replayed", "SM1", "token", http.StatusOK, http.StatusForbidden, 1},
+		{"forged", "SM2", "other", http.StatusOK, http.StatusForbidden, 1},
+		{"handler fails", "SM3", "token", http.StatusServiceUnavailable, http.StatusServiceUnavailable, 2},
+		{"retry after failure", "SM3", "token", http.StatusOK, http.StatusOK, 3},
+		{"replay after success", "SM3", "token", http.StatusOK, http.StatusForbidden, 3},
+		{"no MessageSid", "", "token", http.StatusOK, http.StatusForbidden, 3},
+	}
+	for _, tt := range tests {
+		status = tt.handler
+		if got := send(tt.sid, tt.token); got != tt.want || calls != tt.calls {
+			t.Errorf("%s: status %d after %d calls, want %d after %d", tt.name, got, calls, tt.want, tt.calls)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		name, token, testMode string
+		want                  string // auth token, or "" for refusing to start
+	}{
+		{"auth token", "real", "", "real"},
+		{"test mode", "", "1", TestAuthToken},
+		{"test mode with a real token", "real", "1", ""},
+		{"test mode off", "real", "0", "real"},
+	}
+	for _, tt := range tests {
+		t.Setenv("TWILIO_AUTH_TOKEN", tt.token)
+		t.Setenv("TWILIO_TEST_MODE", tt.testMode)
+		v, err := FromEnv(nil)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("%s: started with auth token %q", tt.name, v.AuthToken)
+		case tt.want != "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != "" && v.AuthToken != tt.want:
+			t.Errorf("%s: auth token %q, want %q", tt.name, v.AuthToken, tt.want)
+		}
+	}
+}
+
+// Two validators stand in for two serverless instances: with the store they
+// share, a request replayed to the second is refused.
+func TestValidatorSharedReplays(t *testing.T) {
+	const u = "https://bot.example.com/api/whatsapp"
+	replays, err := bot.OpenFileReplayStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	params := url.Values{"MessageSid": {"SM1"}, "From": {"whatsapp:+263771111111"}, "Body": {"hi"}}
+	for i, want := range []int{http.StatusOK, http.StatusForbidden} {
+		h := (&Validator{AuthToken: "token", URL: u, Replays: replays}).Wrap(ok)
+		req, err := Signer{AuthToken: "token"}.NewRequest(u, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("instance %d: status %d, want %d", i+1, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Package underwrite turns the limit the policy allows into the limit a loan
+// actually gets, by checking it against the applicant: what they asked for,
+// how they use their wallet, how their other loans have gone and how much
+// they already owe. Every rule's effect is kept in the Decision so approvers
+// can see why a loan got its limit.
+package underwrite
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xetkloset/demo/money"
+	"github.com/xetkloset/demo/policy"
+)
+
+// Rule names, in the order rules are applied.
+const (
+	RulePolicy      = "policy"
+	RuleRequested   = "requested"
+	RuleWallet      = "wallet_history"
+	RulePerformance = "loan_performance"
+	RuleExposure    = "exposure"
+)
+
+// Facts is what is known about the applicant when the loan is underwritten.
+type Facts struct {
+	Requested money.Money
+	Activity  int         // wallet transactions the applicant made themselves
+	Repaid    int         // other loans fully repaid
+	InArrears []string    // other loans with installments overdue
+	Exposure  money.Money // owed plus undrawn limit on other loans
+}
+
+// Step is one rule's contribution: the limit after the rule, and why. Note
+// says why in English, for logs and the admin API; Key names the catalog
+// message that says it in the reader's language, filled in from Counts,
+// Amounts and Texts.
+type Step struct {
+	Rule    string
+	Limit   money.Money
+	Note    string
+	Key     string                 `json:",omitempty"`
+	Counts  map[string]int         `json:",omitempty"`
+	Amounts map[string]money.Money `json:",omitempty"`
+	Texts   map[string]string      `json:",omitempty"`
+}
+
+// Decision is the underwriting record kept on a loan.
+type Decision struct {
+	At            time.Time
+	PolicyVersion string
+	Limit         money.Money
+	TermMonths    int
+	Steps         []Step
+}
+
+// Decide applies the rules in order to the policy result res. Rules only
+// ever lower the limit.
+func Decide(r policy.Rules, res policy.Result, f Facts, now time.Time) Decision {
+	d := Decision{At: now, PolicyVersion: res.Version, Limit: res.Limit, TermMonths: res.TermMonths}
+	st := Step{Key: "underwrite_no_tier", Counts: map[string]int{"recs": res.Recommendations}, Texts: map[string]string{"capped": fmt.Sprint(res.Capped)}}
+	st.Note = "no tier yet"
+	if res.Tier != nil {
+		st.Key, st.Counts["elders"] = "underwrite_tier", res.Tier.Elders
+		st.Note = fmt.Sprintf("tier for %d+ elders", res.Tier.Elders)
+	}
+	st.Note += fmt.Sprintf(", %d recommendations", res.Recommendations)
+	if res.Capped {
+		st.Note += ", capped"
+	}
+	d.step(RulePolicy, res.Limit, st)
+
+	if f.Requested.IsPositive() {
+		d.step(RuleRequested, f.Requested, Step{Note: "requested " + f.Requested.String(),
+			Key: "underwrite_requested", Amounts: map[string]money.Money{"amount": f.Requested}})
+	}
+
+	activity := map[string]int{"activity": f.Activity, "min": r.MinActivity}
+	if r.MinActivity > 0 && f.Activity < r.MinActivity {
+		d.step(RuleWallet, r.NewWalletCap, Step{Note: fmt.Sprintf("%d wallet transactions, fewer than %d", f.Activity, r.MinActivity),
+			Key: "underwrite_new_wallet", Counts: activity})
+	} else {
+		d.step(RuleWallet, d.Limit, Step{Note: fmt.Sprintf("%d wallet transactions", f.Activity),
+			Key: "underwrite_wallet", Counts: activity})
+	}
+
+	if len(f.InArrears) > 0 {
+		loans := strings.Join(f.InArrears, ", ")
+		d.step(RulePerformance, money.Zero(d.Limit.Currency), Step{Note: "in arrears on " + loans,
+			Key: "underwrite_arrears", Texts: map[string]string{"loans": loans}})
+	} else {
+		d.step(RulePerformance, d.Limit, Step{Note: fmt.Sprintf("%d loans repaid, none in arrears", f.Repaid),
+			Key: "underwrite_repaid", Counts: map[string]int{"repaid": f.Repaid}})
+	}
+
+	if r.MaxExposure.IsPositive() {
+		room := r.MaxExposure.Sub(f.Exposure)
+		if room.IsNegative() {
+			room = money.Zero(room.Currency)
+		}
+		d.step(RuleExposure, room, Step{Note: fmt.Sprintf("%s already owed or undrawn, at most %s", f.Exposure, r.MaxExposure),
+			Key: "underwrite_exposure", Amounts: map[string]money.Money{"exposure": f.Exposure, "max": r.MaxExposure}})
+	}
+	return d
+}
+
+// step records st as rule's, capping the limit at most at limit.
+func (d *Decision) step(rule string, limit money.Money, st Step) {
+	d.Limit = d.Limit.Min(limit)
+	st.Rule, st.Limit = rule, d.Limit
+	d.Steps = append(d.Steps, st)
+}
+
+// Explain lists each rule's effect in English, one per line, for the dry-run
+// evaluator and logs. The chat renders Key instead.
+func (d Decision) Explain() string {
+	var b strings.Builder
+	for _, st := range d.Steps {
+		fmt.Fprintf(&b, "• %s: %s → %s\n", st.Rule, st.Note, st.Limit)
+	}
+	return b.String()
+}
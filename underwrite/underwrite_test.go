@@ -0,0 +1,89 @@
+package underwrite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xetkloset/demo/money"
+	"github.com/xetkloset/demo/policy"
+)
+
+func usd(units int64) money.Money { return money.FromMajor(units, money.USD) }
+
+func TestDecide(t *testing.T) {
+	r := policy.Builtin.Default
+	// Mufundisi and one elder: the $500 tier plus two recommendations.
+	approved := policy.Builtin.Evaluate(policy.Approvals{Mufundisi: true, Elders: 1, Recommendations: 3})
+	unapproved := policy.Builtin.Evaluate(policy.Approvals{Recommendations: 1})
+	good := Facts{Requested: usd(1000), Activity: 5, Repaid: 1, Exposure: usd(0)}
+
+	tests := []struct {
+		name  string
+		res   policy.Result
+		facts func(f *Facts)
+		want  money.Money
+		keys  []string
+	}{
+		{"policy limit stands", approved, func(*Facts) {}, usd(700),
+			[]string{"underwrite_tier", "underwrite_requested", "underwrite_wallet", "underwrite_repaid", "underwrite_exposure"}},
+		{"no tier", unapproved, func(*Facts) {}, usd(100),
+			[]string{"underwrite_no_tier", "underwrite_requested", "underwrite_wallet", "underwrite_repaid", "underwrite_exposure"}},
+		{"asked for less", approved, func(f *Facts) { f.Requested = usd(250) }, usd(250), nil},
+		{"nothing requested", approved, func(f *Facts) { f.Requested = money.Money{} }, usd(700),
+			[]string{"underwrite_tier", "underwrite_wallet", "underwrite_repaid", "underwrite_exposure"}},
+		{"new wallet", approved, func(f *Facts) { f.Activity = 2 }, usd(200),
+			[]string{"underwrite_tier", "underwrite_requested", "underwrite_new_wallet", "underwrite_repaid", "underwrite_exposure"}},
+		{"in arrears", approved, func(f *Facts) { f.InArrears = []string{"L1"} }, usd(0),
+			[]string{"underwrite_tier", "underwrite_requested", "underwrite_wallet", "underwrite_arrears", "underwrite_exposure"}},
+		{"some exposure", approved, func(f *Facts) { f.Exposure = usd(600) }, usd(400), nil},
+		{"over exposed", approved, func(f *Facts) { f.Exposure = usd(1200) }, usd(0), nil},
+	}
+	for _, tt := range tests {
+		f := good
+		tt.facts(&f)
+		d := Decide(r, tt.res, f, time.Time{})
+		if d.Limit != tt.want {
+			t.Errorf("%s: limit %s, want %s", tt.name, d.Limit, tt.want)
+		}
+		if last := d.Steps[len(d.Steps)-1].Limit; last != d.Limit {
+			t.Errorf("%s: last step %s, decision %s", tt.name, last, d.Limit)
+		}
+		for i := 1; i < len(d.Steps); i++ {
+			if d.Steps[i].Limit.Cmp(d.Steps[i-1].Limit) > 0 {
+				t.Errorf("%s: %s raised the limit to %s", tt.name, d.Steps[i].Rule, d.Steps[i].Limit)
+			}
+		}
+		if tt.keys == nil {
+			continue
+		}
+		var keys []string
+		for _, st := range d.Steps {
+			keys = append(keys, st.Key)
+		}
+		if len(keys) != len(tt.keys) {
+			t.Errorf("%s: keys %v, want %v", tt.name, keys, tt.keys)
+			continue
+		}
+		for i := range keys {
+			if keys[i] != tt.keys[i] {
+				t.Errorf("%s: keys %v, want %v", tt.name, keys, tt.keys)
+				break
+			}
+		}
+	}
+}
+
+func TestDecideNoExposureRule(t *testing.T) {
+	r := policy.Builtin.Default
+	r.MaxExposure, r.MinActivity = money.Money{}, 0
+	res := policy.Builtin.Evaluate(policy.Approvals{Mufundisi: true, Elders: 2})
+	d := Decide(r, res, Facts{Activity: 0, Exposure: usd(5000)}, time.Time{})
+	if d.Limit != usd(800) {
+		t.Errorf("limit %s, want %s", d.Limit, usd(800))
+	}
+	for _, st := range d.Steps {
+		if st.Rule == RuleExposure {
+			t.Errorf("exposure rule applied with no maximum: %+v", st)
+		}
+	}
+}
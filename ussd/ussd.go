@@ -0,0 +1,249 @@
+// Package ussd is the USSD channel for members on feature phones. It speaks
+// the Africa's Talking callback format: each step of a session is a form post
+// carrying sessionId, phoneNumber and text, the member's inputs so far joined
+// with "*", and is answered in plain text starting with "CON " to ask for
+// more input or "END " to close the session. See
+// https://developers.africastalking.com/docs/ussd/handle_sessions
+//
+// Replies are reduced to what a feature phone shows well, numbered menus as
+// "1. Send Money" and no emoji, and split into screens that fit the network's
+// limit. Members page through long menus with 98 and 99, which no flow uses.
+package ussd
+
+import (
+	"crypto/hmac"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xetkloset/demo/bot"
+	"github.com/xetkloset/demo/transport"
+)
+
+// DefaultLimit is the most characters a USSD screen carries.
+const DefaultLimit = 182
+
+// Paging inputs.
+const (
+	More = "98"
+	Back = "99"
+)
+
+// PageLabels names the paging choices, by language; English is used for
+// languages it lacks.
+var PageLabels = map[string][2]string{
+	"en": {"More", "Back"},
+}
+
+// sessionTTL is how long the screens of a session are kept; networks close
+// USSD sessions well within it.
+const sessionTTL = 5 * time.Minute
+
+// Gateway serves the USSD callback.
+type Gateway struct {
+	// Secret must be passed as the "key" query parameter of the callback
+	// URL configured with the provider, since callbacks aren't signed.
+	Secret string
+	// Limit is the characters per screen; DefaultLimit when 0.
+	Limit int
+
+	mu       sync.Mutex
+	sessions map[string]*screens
+	now      func() time.Time
+}
+
+// screens are the pages of the last reply in a USSD session. They are kept
+// per process: if the next step lands on another instance, paging input goes
+// to the conversation, which asks again.
+type screens struct {
+	pages []string
+	at    int
+	seen  time.Time
+}
+
+// FromEnv configures a Gateway from USSD_CALLBACK_SECRET.
+func FromEnv() *Gateway {
+	return New(os.Getenv("USSD_CALLBACK_SECRET"))
+}
+
+// New returns a Gateway accepting callbacks that carry secret.
+func New(secret string) *Gateway {
+	return &Gateway{Secret: secret, sessions: map[string]*screens{}, now: time.Now}
+}
+
+// Handler returns the callback handler. The phone number is turned into the
+// member's WhatsApp ID, so a member reaches the same wallet and session from
+// either channel. The first step of a session (empty text) starts the
+// conversation over from the PIN.
+func (g *Gateway) Handler(conv transport.Conversation) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.Secret == "" {
+			log.Printf("ussd: no callback secret configured, rejecting callback")
+			http.Error(w, "Callback not configured", http.StatusInternalServerError)
+			return
+		}
+		if !hmac.Equal([]byte(r.URL.Query().Get("key")), []byte(g.Secret)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		id := r.PostForm.Get("sessionId")
+		from, ok := bot.WhatsAppID(r.PostForm.Get("phoneNumber"))
+		if id == "" || !ok {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		text := r.PostForm.Get("text")
+		input := text[strings.LastIndex(text, "*")+1:]
+		if text != "" {
+			if page, ok := g.turnPage(id, input); ok {
+				respond(w, "CON ", page)
+				return
+			}
+		}
+		reply, err := conv(transport.Message{From: from, Body: input, Channel: transport.ChannelUSSD, Start: text == ""})
+		if err != nil {
+			log.Printf("ussd %s: %v", from, err)
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		pages := paginate(plain(reply.Text), g.limit(), reply.Language)
+		if reply.Done {
+			g.end(id)
+			respond(w, "END ", pages[0])
+			return
+		}
+		g.keep(id, pages)
+		respond(w, "CON ", pages[0])
+	})
+}
+
+func respond(w http.ResponseWriter, kind, screen string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, kind+screen)
+}
+
+func (g *Gateway) limit() int {
+	if g.Limit > 0 {
+		return g.Limit
+	}
+	return DefaultLimit
+}
+
+// turnPage answers a paging input from the session's kept screens.
+func (g *Gateway) turnPage(id, input string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sc := g.sessions[id]
+	if sc == nil || (input != More && input != Back) {
+		return "", false
+	}
+	// past either end the same screen is shown again
+	if input == More && sc.at < len(sc.pages)-1 {
+		sc.at++
+	} else if input == Back && sc.at > 0 {
+		sc.at--
+	}
+	sc.seen = g.now()
+	return sc.pages[sc.at], true
+}
+
+// keep stores the screens of a reply, dropping expired sessions.
+func (g *Gateway) keep(id string, pages []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	for k, sc := range g.sessions {
+		if now.Sub(sc.seen) > sessionTTL {
+			delete(g.sessions, k)
+		}
+	}
+	if len(pages) > 1 {
+		g.sessions[id] = &screens{pages: pages, seen: now}
+	} else {
+		delete(g.sessions, id)
+	}
+}
+
+func (g *Gateway) end(id string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.sessions, id)
+}
+
+// plain rewrites a chat reply for a feature phone: menu lines become
+// "1. Send Money", emoji and blank lines go.
+func plain(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if o, ok := transport.Choice(line); ok {
+			line = o.ID + ". " + o.Title
+		}
+		line = strings.Join(strings.Fields(strings.Map(dropSymbol, line)), " ")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func dropSymbol(r rune) rune {
+	if unicode.In(r, unicode.So, unicode.Sk, unicode.Variation_Selector) || r == '\u200d' {
+		return -1
+	}
+	return r
+}
+
+// paginate packs lines into screens of at most limit characters. When they
+// don't fit on one, every screen but the last ends with a More choice and
+// every one but the first with a Back choice.
+func paginate(lines []string, limit int, language string) []string {
+	whole := strings.Join(lines, "\n")
+	if utf8.RuneCountInString(whole) <= limit {
+		return []string{whole}
+	}
+	labels, ok := PageLabels[language]
+	if !ok {
+		labels = PageLabels["en"]
+	}
+	more, back := "\n"+More+". "+labels[0], "\n"+Back+". "+labels[1]
+	room := limit - utf8.RuneCountInString(more+back)
+
+	var pages []string
+	var cur string
+	for _, line := range lines {
+		line = transport.Truncate(line, room)
+		switch {
+		case cur == "":
+			cur = line
+		case utf8.RuneCountInString(cur)+1+utf8.RuneCountInString(line) <= room:
+			cur += "\n" + line
+		default:
+			pages = append(pages, cur)
+			cur = line
+		}
+	}
+	pages = append(pages, cur)
+	for i := range pages {
+		if i < len(pages)-1 {
+			pages[i] += more
+		}
+		if i > 0 {
+			pages[i] += back
+		}
+	}
+	return pages
+}
@@ -0,0 +1,112 @@
+package ussd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/xetkloset/demo/transport"
+)
+
+func TestPaginate(t *testing.T) {
+	// "ŵ" and "ü" are two bytes each: limits count characters
+	wide := strings.Repeat("ŵ", 30)
+	tests := []struct {
+		name  string
+		lines []string
+		limit int
+		pages int
+	}{
+		{"fits", []string{"1. Send Money", "2. Airtime"}, 40, 1},
+		{"exactly at the limit", []string{wide, wide}, 61, 1},
+		{"multibyte at the limit", []string{wide + "ü"}, 31, 1},
+		{"one over", []string{wide, wide}, 60, 2},
+		{"three screens", []string{wide, wide, wide}, 60, 3},
+		{"line longer than a screen", []string{strings.Repeat("ü", 100), "0. Back"}, 60, 2},
+	}
+	for _, tt := range tests {
+		pages := paginate(tt.lines, tt.limit, "en")
+		if len(pages) != tt.pages {
+			t.Errorf("%s: %d pages %q, want %d", tt.name, len(pages), pages, tt.pages)
+			continue
+		}
+		for i, p := range pages {
+			if n := utf8.RuneCountInString(p); n > tt.limit {
+				t.Errorf("%s: page %d has %d characters, limit %d", tt.name, i, n, tt.limit)
+			}
+			if !utf8.ValidString(p) {
+				t.Errorf("%s: page %d cut a character: %q", tt.name, i, p)
+			}
+			more := strings.HasSuffix(p, "\n98. More") || strings.Contains(p, "\n98. More\n")
+			back := strings.HasSuffix(p, "\n99. Back")
+			if tt.pages > 1 && (more != (i < len(pages)-1) || back != (i > 0)) {
+				t.Errorf("%s: page %d of %d is %q", tt.name, i, len(pages), p)
+			}
+		}
+	}
+	if got := paginate([]string{wide, wide}, 60, "sn"); !strings.Contains(got[0], "98. More") {
+		t.Errorf("no English labels for a language without its own: %q", got[0])
+	}
+}
+
+// step posts one USSD callback and returns the screen sent back.
+func step(t *testing.T, h http.Handler, text string) string {
+	t.Helper()
+	form := url.Values{"sessionId": {"ATUid_1"}, "phoneNumber": {"+263771111111"}, "text": {text}}
+	req := httptest.NewRequest(http.MethodPost, "/api/ussd?key=secret", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	body, _ := io.ReadAll(rec.Body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%q: status %d: %s", text, rec.Code, body)
+	}
+	return string(body)
+}
+
+// 98 and 99 page through a long reply without reaching the conversation;
+// past either end the same screen comes back.
+func TestPaging(t *testing.T) {
+	var inputs []string
+	menu := "Choose:\n1️⃣ " + strings.Repeat("a", 40) + "\n2️⃣ " + strings.Repeat("b", 40) + "\n3️⃣ " + strings.Repeat("c", 40)
+	conv := func(m transport.Message) (transport.Reply, error) {
+		inputs = append(inputs, m.Body)
+		return transport.Reply{Text: menu, Language: "en"}, nil
+	}
+	g := New("secret")
+	g.Limit = 70
+	h := g.Handler(conv)
+
+	first := step(t, h, "")
+	if !strings.HasPrefix(first, "CON Choose:\n1. aaa") || !strings.HasSuffix(first, "\n98. More") {
+		t.Fatalf("first screen %q", first)
+	}
+	if got := step(t, h, "99"); got != first {
+		t.Errorf("99 on the first screen gave %q", got)
+	}
+	second := step(t, h, "99*98")
+	if !strings.HasPrefix(second, "CON 2. bbb") || !strings.HasSuffix(second, "\n98. More\n99. Back") {
+		t.Errorf("second screen %q", second)
+	}
+	last := step(t, h, "99*98*98")
+	if !strings.HasPrefix(last, "CON 3. ccc") || !strings.HasSuffix(last, "\n99. Back") || strings.Contains(last, "98.") {
+		t.Errorf("last screen %q", last)
+	}
+	if got := step(t, h, "99*98*98*98"); got != last {
+		t.Errorf("98 on the last screen gave %q", got)
+	}
+	if got := step(t, h, "99*98*98*98*99"); got != second {
+		t.Errorf("99 from the last screen gave %q", got)
+	}
+	if len(inputs) != 1 {
+		t.Errorf("paging reached the conversation: %q", inputs)
+	}
+	step(t, h, "99*98*98*98*99*2")
+	if len(inputs) != 2 || inputs[1] != "2" {
+		t.Errorf("conversation got %q, want the choice 2", inputs)
+	}
+}